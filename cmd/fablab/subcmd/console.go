@@ -0,0 +1,72 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package subcmd
+
+import (
+	"github.com/openziti/fablab/kernel/lib/tui"
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(newConsoleCmd())
+}
+
+func newConsoleCmd() *cobra.Command {
+	action := &consoleCmd{}
+
+	var cmd = &cobra.Command{
+		Use:   "console",
+		Short: "interactively browse and run model and component actions",
+		Long: "Bootstraps the model once, then lists the model actions, and the actions of components selected\n" +
+			"directly or through their hosts. Actions are run after confirmation, with their output shown in a\n" +
+			"pane, and can be run again without bootstrapping again.",
+		Args: cobra.NoArgs,
+		Run:  action.run,
+	}
+
+	cmd.Flags().StringArrayVarP(&action.bindings, "variable", "b", []string{}, "specify variable binding ('<hostSpec>.a.b.c=value')")
+
+	return cmd
+}
+
+type consoleCmd struct {
+	bindings []string
+}
+
+func (self *consoleCmd) run(_ *cobra.Command, _ []string) {
+	if err := model.Bootstrap(); err != nil {
+		logrus.Fatalf("unable to bootstrap (%s)", err)
+	}
+
+	m := model.GetModel()
+
+	if !m.IsBound() {
+		logrus.Fatalf("model not bound")
+	}
+
+	for _, binding := range self.bindings {
+		if err := execCmdBind(m, binding); err != nil {
+			logrus.Fatalf("error binding [%s] (%v)", binding, err)
+		}
+	}
+
+	if err := tui.RunConsole(m); err != nil {
+		logrus.WithError(err).Fatal("console failed")
+	}
+}
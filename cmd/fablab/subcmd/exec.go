@@ -21,23 +21,33 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"os"
 	"strings"
 )
 
 func init() {
 	execCmd.Flags().StringArrayVarP(&execCmdBindings, "variable", "b", []string{}, "specify variable binding ('<hostSpec>.a.b.c=value')")
+	execCmd.Flags().StringArrayVarP(&execCmdArgs, "arg", "a", []string{}, "specify action argument ('name=value')")
+	execCmd.Flags().StringVarP(&execCmdOutput, "output", "o", outputFormatTable, "result output format [table|json]")
 	RootCmd.AddCommand(execCmd)
 }
 
 var execCmd = &cobra.Command{
-	Use:   "exec <action> [<actions>...]",
-	Short: "execute one or more actions",
-	Args:  cobra.MinimumNArgs(1),
-	Run:   runExec,
+	Use:     "exec <action> [<actions>...]",
+	Short:   "execute one or more actions",
+	Example: "fablab exec restart-routers --arg batch=5 --arg selector=.edge",
+	Args:    cobra.MinimumNArgs(1),
+	Run:     runExec,
 }
 var execCmdBindings []string
+var execCmdArgs []string
+var execCmdOutput string
+
+func runExec(cmd *cobra.Command, args []string) {
+	if err := validateOutputFormat(execCmdOutput); err != nil {
+		logrus.WithError(err).Fatal("invalid arguments")
+	}
 
-func runExec(_ *cobra.Command, args []string) {
 	if err := model.Bootstrap(); err != nil {
 		logrus.Fatalf("unable to bootstrap (%s)", err)
 	}
@@ -59,6 +69,14 @@ func runExec(_ *cobra.Command, args []string) {
 		}
 	}
 
+	// disruptions are intentionally left in place when actions complete, but not when they're interrupted
+	healOnInterrupt(ctx.GetId())
+
+	rawArgs, err := model.ParseArgs(execCmdArgs)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid action arguments")
+	}
+
 	var actions []model.Action
 
 	for _, name := range args {
@@ -69,11 +87,51 @@ func runExec(_ *cobra.Command, args []string) {
 		actions = append(actions, action)
 	}
 
-	for _, action := range actions {
-		if err := action.Execute(ctx); err != nil {
-			logrus.WithError(err).Fatalf("action failed [%+v]", action)
+	if err = checkActionArgs(args, actions, rawArgs); err != nil {
+		logrus.WithError(err).Fatal("invalid action arguments")
+	}
+
+	var results []*model.ActionResult
+	var failed error
+	for idx, action := range actions {
+		result, err := model.ExecuteWithResult(ctx, args[idx], action, rawArgs)
+		results = append(results, result)
+		if err != nil {
+			logrus.WithError(err).Errorf("action failed [%s]", args[idx])
+			failed = err
+			break
 		}
 	}
+
+	if err := renderActionResults(cmd.OutOrStdout(), execCmdOutput, results); err != nil {
+		logrus.WithError(err).Error("unable to output action results")
+	}
+
+	if failed != nil {
+		os.Exit(1)
+	}
+}
+
+// checkActionArgs ensures that every supplied argument is accepted by at least one of the actions
+// and that all required arguments were supplied, so that mistakes are reported before anything runs
+func checkActionArgs(names []string, actions []model.Action, rawArgs map[string]string) error {
+	declared := map[string]bool{}
+	for idx, action := range actions {
+		params := model.GetActionParams(action)
+		for _, param := range params {
+			declared[param.Name] = true
+		}
+		if _, err := model.ResolveArgs(params, rawArgs); err != nil {
+			return errors.Wrapf(err, "action [%s]", names[idx])
+		}
+	}
+
+	for name := range rawArgs {
+		if !declared[name] {
+			return errors.Errorf("argument [%s] is not accepted by any of the actions %v", name, names)
+		}
+	}
+	return nil
 }
 
 func execCmdBind(m *model.Model, binding string) error {
@@ -19,14 +19,19 @@ package subcmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/fablab/kernel/lib/execstats"
 	"github.com/openziti/fablab/kernel/lib/figlet"
 	"github.com/openziti/fablab/kernel/lib/tui"
 	"github.com/openziti/fablab/kernel/model"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
@@ -37,26 +42,62 @@ func init() {
 }
 
 type execLoopCmd struct {
-	bindings []string
-	useTui   bool
+	bindings       []string
+	useTui         bool
+	heal           bool
+	runId          string
+	workerSpecs    []string
+	metricsFormat  string
+	metrics        *execstats.Recorder
+	metricsDone    atomic.Bool
+	maxFailures    string
+	failureLimit   *execstats.FailureLimit
+	capture        string
+	captureAction  model.Action
+	program        *tea.Program
+	statusInterval time.Duration
+	stopping       atomic.Bool
+}
+
+// execLoopWorker runs a sequence of actions in a loop. When multiple workers are defined, they run concurrently,
+// sharing the model and run, but each with its own iterations, stop condition and failure count.
+type execLoopWorker struct {
+	name     string
+	actions  []namedAction
+	until    untilPredicate
+	failures int
+	log      *logrus.Entry
+}
+
+type namedAction struct {
+	name   string
+	action model.Action
 }
 
 func newExecLoopCmd() *cobra.Command {
 	execLoop := &execLoopCmd{}
 
 	cobraCmd := &cobra.Command{
-		Use:   "exec-loop <until> <action> [<actions>...]",
+		Use:   "exec-loop <until> [<action>...]",
 		Short: "execute one or more actions",
 		Example: "fablab exec-loop forever make-changes validate\n" +
 			"fablab exec-loop 100 make-changes validate\n" +
 			"fablab exec-loop 10m make-changes validate\n" +
-			"fablab exec-loop --tui forever make-changes validate",
-		Args: cobra.MinimumNArgs(2),
+			"fablab exec-loop --tui forever make-changes validate\n" +
+			"fablab exec-loop --max-failures 5% --capture capture-logs 24h make-changes validate\n" +
+			"fablab exec-loop --worker churn=make-changes,validate --worker chaos@30m=kill-random-router 2h",
+		Args: cobra.MinimumNArgs(1),
 		Run:  execLoop.runExec,
 	}
 
 	cobraCmd.Flags().StringArrayVarP(&execCmdBindings, "variable", "b", []string{}, "specify variable binding ('<hostSpec>.a.b.c=value')")
 	cobraCmd.Flags().BoolVar(&execLoop.useTui, "tui", false, "enable TUI mode with separate actions/validation panes")
+	cobraCmd.Flags().BoolVar(&execLoop.heal, "heal", true, "heal network disruptions recorded by this run when the loop ends or is interrupted")
+	cobraCmd.Flags().StringArrayVar(&execLoop.workerSpecs, "worker", nil, "run a concurrent worker ('<name>[@<until>]=<action>,<action>...'), may be repeated")
+	cobraCmd.Flags().StringVar(&execLoop.maxFailures, "max-failures", "0", "number, or percentage, of failed iterations to tolerate before stopping")
+	cobraCmd.Flags().StringVar(&execLoop.capture, "capture", "", "action to run after each failed iteration, with argument scenario=iteration-N")
+	cobraCmd.Flags().DurationVar(&execLoop.statusInterval, "status-interval", 10*time.Second, "how often the TUI component status pane polls components, 0 to hide the pane")
+	cobraCmd.Flags().StringVar(&execLoop.metricsFormat, "metrics-format", string(execstats.FormatCsv), "format of the per-iteration metrics file (csv or json)")
 
 	return cobraCmd
 }
@@ -70,6 +111,7 @@ func (self *execLoopCmd) runExec(_ *cobra.Command, args []string) {
 	if err != nil {
 		logrus.WithError(err).Fatal("error initializing run")
 	}
+	self.runId = ctx.GetId()
 
 	m := model.GetModel()
 
@@ -83,19 +125,20 @@ func (self *execLoopCmd) runExec(_ *cobra.Command, args []string) {
 		}
 	}
 
-	var actions []model.Action
+	workers, err := self.parseWorkers(m, args)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid exec-loop arguments")
+	}
 
-	for _, name := range args[1:] {
-		action, found := m.GetAction(name)
-		if !found {
-			logrus.Fatalf("no such action [%s]", name)
+	if self.capture != "" {
+		var found bool
+		if self.captureAction, found = m.GetAction(self.capture); !found {
+			logrus.Fatalf("no such capture action [%s]", self.capture)
 		}
-		actions = append(actions, action)
 	}
 
-	until, err := self.parseUntil(args[0])
-	if err != nil {
-		logrus.Fatalf("invalid until specification, must 'forever', a number (iterations) or a duration [%s]", args[0])
+	if self.failureLimit, err = execstats.ParseFailureLimit(self.maxFailures); err != nil {
+		logrus.WithError(err).Fatal("invalid max failures")
 	}
 
 	// Auto-disable TUI when stdout is not a terminal.
@@ -104,66 +147,311 @@ func (self *execLoopCmd) runExec(_ *cobra.Command, args []string) {
 		self.useTui = false
 	}
 
+	format, err := execstats.ParseFormat(self.metricsFormat)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid metrics format")
+	}
+
+	metricsPath := filepath.Join(model.BuildPath(), "exec-loop", ctx.GetId()+"."+string(format))
+	if self.metrics, err = execstats.NewRecorder(metricsPath, format); err != nil {
+		logrus.WithError(err).Fatal("unable to record exec-loop metrics")
+	}
+	pfxlog.Logger().Infof("recording iteration metrics to [%s]", metricsPath)
+
+	exitOnInterrupt(self.finish)
+
 	if self.useTui {
-		self.runExecWithTui(ctx, actions, until)
+		self.runExecWithTui(ctx, workers)
 	} else {
-		self.runExecPlain(ctx, actions, until)
+		self.runExecPlain(ctx, workers)
 	}
 }
 
-func (self *execLoopCmd) runExecPlain(ctx model.Run, actions []model.Action, until untilPredicate) {
-	iterations := 1
-	start := time.Now()
+// parseWorkers returns the workers defined with --worker, or a single unnamed worker running the actions given
+// as arguments
+func (self *execLoopCmd) parseWorkers(m *model.Model, args []string) ([]*execLoopWorker, error) {
+	if len(self.workerSpecs) == 0 {
+		if len(args) < 2 {
+			return nil, errors.New("at least one action, or a --worker, is required")
+		}
+		worker, err := self.newWorker(m, "", args[0], args[1:])
+		if err != nil {
+			return nil, err
+		}
+		return []*execLoopWorker{worker}, nil
+	}
 
-	for {
-		iterationStart := time.Now()
-		figlet.Figlet(fmt.Sprintf("ITERATION-%03d", iterations))
-		for _, action := range actions {
-			if err := action.Execute(ctx); err != nil {
-				logrus.WithError(err).Fatalf("action failed [%+v]", action)
-			}
+	if len(args) > 1 {
+		return nil, errors.Errorf("actions %v can't be combined with --worker, define another worker instead", args[1:])
+	}
+
+	var result []*execLoopWorker
+	names := map[string]struct{}{}
+	for _, spec := range self.workerSpecs {
+		name, actionList, found := strings.Cut(spec, "=")
+		untilSpec := args[0]
+		name, workerUntil, hasUntil := strings.Cut(name, "@")
+		if !found || name == "" || (hasUntil && workerUntil == "") || actionList == "" {
+			return nil, errors.Errorf("invalid worker [%s], must be of the form <name>[@<until>]=<action>,<action>...", spec)
+		}
+		if hasUntil {
+			untilSpec = workerUntil
+		}
+		if name == tui.PaneActions {
+			return nil, errors.Errorf("invalid worker name [%s], it's reserved", name)
+		}
+		if _, found = names[name]; found {
+			return nil, errors.Errorf("duplicate worker [%s]", name)
 		}
-		if until.isDone() {
-			pfxlog.Logger().Infof("finished after %v iteration(s) in %v", iterations, time.Since(start))
-			return
+		names[name] = struct{}{}
+
+		worker, err := self.newWorker(m, name, untilSpec, strings.Split(actionList, ","))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid worker [%s]", name)
 		}
-		pfxlog.Logger().Infof("iteration: %v, iteration time: %v, total time: %v",
-			iterations, time.Since(iterationStart), time.Since(start))
-		iterations++
+		result = append(result, worker)
 	}
+	return result, nil
 }
 
-func (self *execLoopCmd) runExecWithTui(ctx model.Run, actions []model.Action, until untilPredicate) {
-	program, err := tui.RunTUI()
+func (self *execLoopCmd) newWorker(m *model.Model, name, untilSpec string, actionNames []string) (*execLoopWorker, error) {
+	until, err := self.parseUntil(untilSpec)
+	if err != nil {
+		return nil, errors.Errorf("invalid until specification, must 'forever', a number (iterations) or a duration [%s]", untilSpec)
+	}
+
+	worker := &execLoopWorker{
+		name:  name,
+		until: until,
+	}
+
+	for _, actionName := range actionNames {
+		action, found := m.GetAction(actionName)
+		if !found {
+			return nil, errors.Errorf("no such action [%s]", actionName)
+		}
+		worker.actions = append(worker.actions, namedAction{name: actionName, action: action})
+	}
+	return worker, nil
+}
+
+func (self *execLoopCmd) runExecPlain(ctx model.Run, workers []*execLoopWorker) {
+	for _, worker := range workers {
+		worker.log = pfxlog.Logger().WithFields(nil)
+		if worker.name != "" {
+			worker.log = worker.log.WithField("worker", worker.name)
+		}
+	}
+
+	err := self.runWorkers(ctx, workers)
+	self.finish()
+	if err != nil {
+		logrus.WithError(err).Fatal("exec-loop failed")
+	}
+}
+
+func (self *execLoopCmd) runExecWithTui(ctx model.Run, workers []*execLoopWorker) {
+	var err error
+	if len(workers) == 1 {
+		self.program, err = tui.RunTUI()
+		workers[0].log = tui.ValidationLogger()
+	} else {
+		var names []string
+		for _, worker := range workers {
+			names = append(names, worker.name)
+		}
+		self.program, err = tui.RunWorkersTUI(names...)
+		for _, worker := range workers {
+			worker.log = tui.PaneLogger(worker.name)
+		}
+	}
 	if err != nil {
 		logrus.WithError(err).Fatal("failed to start TUI")
 	}
+	if self.statusInterval > 0 {
+		tui.EnableStatus(self.program, ctx, self.statusInterval)
+	}
+	tui.EnableMetrics(self.program, ctx)
+	tui.SetSaveDir(self.program, filepath.Join(model.BuildPath(), "exec-loop"))
+
+	// quitting the TUI before the loop is done interrupts the loop
+	var finished atomic.Bool
+	go func() {
+		self.program.Wait()
+		if !finished.Load() {
+			pfxlog.Logger().Warn("TUI closed, stopping exec-loop")
+			self.finish()
+			os.Exit(1)
+		}
+	}()
+
+	err = self.runWorkers(ctx, workers)
 
-	iterations := 1
+	finished.Store(true)
+	self.healDisruptions()
+	tui.SendDone(self.program, err)
+	self.program.Wait()
+	self.finish()
+	if err != nil {
+		logrus.WithError(err).Fatal("exec-loop failed")
+	}
+}
+
+// runWorkers runs the workers until they're all done. If a worker fails, the other workers stop at the end of
+// their current iteration, and the first failure is returned.
+func (self *execLoopCmd) runWorkers(ctx model.Run, workers []*execLoopWorker) error {
+	if len(workers) == 1 {
+		return self.runWorker(ctx, workers[0])
+	}
+
+	results := make(chan error, len(workers))
+	for _, worker := range workers {
+		go func() {
+			results <- self.runWorker(ctx, worker)
+		}()
+	}
+
+	var result error
+	for range workers {
+		if err := <-results; err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+func (self *execLoopCmd) runWorker(ctx model.Run, worker *execLoopWorker) error {
 	start := time.Now()
 
-	tui.SendIteration(program, iterations)
+	for iteration := 1; ; iteration++ {
+		if self.stopping.Load() {
+			worker.log.Warnf("stopping after %v iteration(s), another worker failed", iteration-1)
+			return nil
+		}
 
-	for {
-		iterStart := time.Now()
-		for _, action := range actions {
-			if err := action.Execute(ctx); err != nil {
-				tui.ValidationLogger().WithError(err).Errorf("action failed [%+v]", action)
-				tui.SendDone(program, err)
-				program.Wait()
-				logrus.WithError(err).Fatalf("action failed [%+v]", action)
+		iterationStart := time.Now()
+		self.startIteration(worker, iteration)
+
+		if name, err := self.executeIteration(ctx, worker, iteration); err != nil {
+			worker.log.WithError(err).Errorf("action failed [%s]", name)
+			if self.failureLimitExceeded(ctx, worker, iteration) {
+				self.stopping.Store(true)
+				return errors.Wrapf(err, "%saction failed [%s], stopping after %d failed iteration(s)", worker.prefix(), name, worker.failures)
 			}
 		}
-		if until.isDone() {
-			tui.ValidationLogger().Infof("finished after %v iteration(s) in %v", iterations, time.Since(start))
-			tui.SendDone(program, nil)
-			program.Wait()
-			return
+		if worker.until.isDone() {
+			worker.log.Infof("finished after %v iteration(s) in %v", iteration, time.Since(start))
+			return nil
+		}
+		worker.log.Infof("iteration: %v, iteration time: %v, total time: %v",
+			iteration, time.Since(iterationStart), time.Since(start))
+	}
+}
+
+func (self *execLoopCmd) startIteration(worker *execLoopWorker, iteration int) {
+	if self.program != nil {
+		if worker.name == "" {
+			tui.SendIteration(self.program, iteration)
+		} else {
+			tui.SendWorkerIteration(self.program, worker.name, iteration)
 		}
-		tui.ValidationLogger().Infof("iteration: %v, iteration time: %v, total time: %v",
-			iterations, time.Since(iterStart), time.Since(start))
-		iterations++
-		tui.SendIteration(program, iterations)
+	} else if worker.name == "" {
+		figlet.Figlet(fmt.Sprintf("ITERATION-%03d", iteration))
+	} else {
+		worker.log.Infof("starting iteration %v", iteration)
+	}
+}
+
+func (self *execLoopWorker) prefix() string {
+	if self.name == "" {
+		return ""
+	}
+	return "worker [" + self.name + "] "
+}
+
+// executeIteration runs the actions in order, recording the duration and result of each, along with the duration
+// and result of the whole iteration. It stops at the first failure, returning the name of the failed action.
+func (self *execLoopCmd) executeIteration(ctx model.Run, worker *execLoopWorker, iteration int) (string, error) {
+	iterationStart := time.Now()
+	for _, action := range worker.actions {
+		actionStart := time.Now()
+		err := action.action.Execute(ctx)
+		self.record(&execstats.Sample{
+			Timestamp: actionStart,
+			Worker:    worker.name,
+			Iteration: iteration,
+			Action:    action.name,
+			Duration:  time.Since(actionStart),
+			Err:       err,
+		})
+		if err != nil {
+			self.recordIteration(worker, iteration, iterationStart, err)
+			return action.name, err
+		}
+	}
+	self.recordIteration(worker, iteration, iterationStart, nil)
+	return "", nil
+}
+
+func (self *execLoopCmd) recordIteration(worker *execLoopWorker, iteration int, start time.Time, err error) {
+	self.record(&execstats.Sample{
+		Timestamp: start,
+		Worker:    worker.name,
+		Iteration: iteration,
+		Action:    execstats.IterationAction,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
+
+// failureLimitExceeded counts a failed iteration and runs the capture action, if there is one. It returns true
+// if the worker should stop.
+func (self *execLoopCmd) failureLimitExceeded(ctx model.Run, worker *execLoopWorker, iteration int) bool {
+	worker.failures++
+
+	if self.captureAction != nil {
+		scenario := fmt.Sprintf("iteration-%d", iteration)
+		if worker.name != "" {
+			scenario = worker.name + "-" + scenario
+		}
+		worker.log.Infof("running capture action [%s] for %s", self.capture, scenario)
+		if err := model.ExecuteWithArgs(ctx, self.captureAction, map[string]string{"scenario": scenario}); err != nil {
+			worker.log.WithError(err).Errorf("capture action [%s] failed", self.capture)
+		}
+	}
+
+	if self.failureLimit.Exceeded(worker.failures, iteration) {
+		return true
+	}
+	worker.log.Warnf("%d of %d iteration(s) failed, max failures is %s, continuing", worker.failures, iteration, self.failureLimit)
+	return false
+}
+
+func (self *execLoopCmd) record(sample *execstats.Sample) {
+	if err := self.metrics.Record(sample); err != nil {
+		pfxlog.Logger().WithError(err).Error("unable to record exec-loop metrics")
+	}
+}
+
+// finish heals disruptions and prints the metrics summary. It may be called from the interrupt handler while
+// the loop is finishing, so only the first call has any effect.
+func (self *execLoopCmd) finish() {
+	if !self.metricsDone.CompareAndSwap(false, true) {
+		return
+	}
+	self.healDisruptions()
+	if err := self.metrics.Close(); err != nil {
+		pfxlog.Logger().WithError(err).Error("unable to close exec-loop metrics file")
+	}
+	fmt.Printf("iteration metrics recorded to [%s]\n", self.metrics.GetPath())
+	if err := self.metrics.WriteSummary(os.Stdout); err != nil {
+		pfxlog.Logger().WithError(err).Error("unable to write exec-loop metrics summary")
+	}
+}
+
+func (self *execLoopCmd) healDisruptions() {
+	if self.heal {
+		healDisruptions(self.runId)
 	}
 }
 
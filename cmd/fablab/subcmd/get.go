@@ -79,6 +79,10 @@ func (self *getFilesAction) run(_ *cobra.Command, args []string) error {
 			return errors.Wrapf(err, "unable to execute template for destination directory '%s'", localPath)
 		}
 		localPath = buf.String()
-		return libssh.RetrieveRemoteFiles(host.NewSshConfigFactory(), localPath, args[2:]...)
+		factory, err := host.NewSshConfigFactory()
+		if err != nil {
+			return err
+		}
+		return libssh.RetrieveRemoteFiles(factory, localPath, args[2:]...)
 	})
 }
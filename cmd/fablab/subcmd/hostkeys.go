@@ -0,0 +1,63 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package subcmd
+
+import (
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	hostKeysCmd.AddCommand(newHostKeysResetCmd())
+	RootCmd.AddCommand(hostKeysCmd)
+}
+
+var hostKeysCmd = &cobra.Command{
+	Use:   "hostkeys",
+	Short: "manage the ssh host keys recorded for the instance",
+}
+
+func newHostKeysResetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "reset <hostSpec>",
+		Short: "forget the recorded host keys of hosts, trusting the keys they present on the next connection",
+		Long: "Host keys are recorded on the first connection to each host and verified on every later connection.\n" +
+			"Use reset when hosts have legitimately been replaced, for example after being recreated.",
+		Args: cobra.ExactArgs(1),
+		Run:  resetHostKeys,
+	}
+}
+
+func resetHostKeys(_ *cobra.Command, args []string) {
+	if err := model.Bootstrap(); err != nil {
+		logrus.Fatalf("unable to bootstrap (%s)", err)
+	}
+
+	hosts := model.GetModel().SelectHosts(args[0])
+	if len(hosts) == 0 {
+		logrus.Fatalf("no hosts matched [%s]", args[0])
+	}
+
+	for _, host := range hosts {
+		removed, err := host.ResetHostKey()
+		if err != nil {
+			logrus.WithError(err).Fatalf("error resetting host key for [%s]", host.GetPath())
+		}
+		logrus.WithField("hostId", host.Id).Infof("removed %d recorded host key(s) for [%s]", removed, host.PublicIp)
+	}
+}
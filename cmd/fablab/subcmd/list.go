@@ -221,8 +221,12 @@ func listActions(*cobra.Command, []string) {
 
 	m := model.GetModel()
 
-	for _, action := range m.GetActions() {
-		fmt.Println(action)
+	for _, name := range m.GetActions() {
+		fmt.Println(name)
+		action, _ := m.GetAction(name)
+		for _, param := range model.GetActionParams(action) {
+			fmt.Printf("\t%s\n", param)
+		}
 	}
 }
 
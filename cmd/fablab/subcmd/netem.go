@@ -0,0 +1,167 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package subcmd
+
+import (
+	"fmt"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/openziti/fablab/kernel/model"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	netemCmd.AddCommand(newNetemAddCmd())
+	netemCmd.AddCommand(newNetemListCmd())
+	netemCmd.AddCommand(newNetemClearCmd())
+	RootCmd.AddCommand(netemCmd)
+}
+
+var netemCmd = &cobra.Command{
+	Use:   "netem",
+	Short: "manage latency, loss and bandwidth emulation on hosts",
+}
+
+func newNetemAddCmd() *cobra.Command {
+	action := &netemAddAction{}
+
+	cmd := &cobra.Command{
+		Use:   "add <hostSpec>",
+		Short: "add or replace a netem rule on hosts",
+		Example: "fablab netem add 'host.region=us-east-1' --ip 10.0.0.1 --delay 80ms --jitter 10ms --loss 0.5\n" +
+			"fablab netem add router1 --port 6262 --rate 10mbit",
+		Args: cobra.ExactArgs(1),
+		Run:  action.run,
+	}
+
+	cmd.Flags().StringVar(&action.target.Ip, "ip", "", "only affect traffic to this ip")
+	cmd.Flags().Uint16Var(&action.target.Port, "port", 0, "only affect traffic to or from this port")
+	cmd.Flags().DurationVar(&action.config.Delay, "delay", 0, "delay to add to outgoing packets")
+	cmd.Flags().DurationVar(&action.config.Jitter, "jitter", 0, "random variation of the delay")
+	cmd.Flags().Float64Var(&action.config.Loss, "loss", 0, "percentage of packets to drop")
+	cmd.Flags().Float64Var(&action.config.Reorder, "reorder", 0, "percentage of packets to send immediately, out of order")
+	cmd.Flags().Float64Var(&action.config.Corrupt, "corrupt", 0, "percentage of packets to corrupt")
+	cmd.Flags().StringVar(&action.config.Rate, "rate", "", "bandwidth limit, for example 10mbit")
+
+	return cmd
+}
+
+type netemAddAction struct {
+	target model.NetemTarget
+	config model.NetemConfig
+}
+
+func (self *netemAddAction) run(_ *cobra.Command, args []string) {
+	if err := model.Bootstrap(); err != nil {
+		logrus.Fatalf("unable to bootstrap (%s)", err)
+	}
+
+	err := model.GetModel().ForEachHost(args[0], 10, func(host *model.Host) error {
+		return host.AddNetem(self.target, &self.config)
+	})
+	if err != nil {
+		logrus.WithError(err).Fatal("error adding netem rules")
+	}
+}
+
+func newNetemListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list <hostSpec?>",
+		Short: "list netem rules on hosts",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   listNetem,
+	}
+}
+
+func listNetem(cmd *cobra.Command, args []string) {
+	if err := model.Bootstrap(); err != nil {
+		logrus.Fatalf("unable to bootstrap (%s)", err)
+	}
+
+	hostSpec := "*"
+	if len(args) > 0 {
+		hostSpec = args[0]
+	}
+
+	m := model.GetModel()
+	rules := cmap.New[[]*model.NetemRule]()
+	err := m.ForEachHost(hostSpec, 10, func(host *model.Host) error {
+		hostRules, err := host.ListNetem()
+		if err != nil {
+			return err
+		}
+		rules.Set(host.GetPath(), hostRules)
+		return nil
+	})
+	if err != nil {
+		logrus.WithError(err).Fatal("error listing netem rules")
+	}
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.AppendHeader(table.Row{"Host", "Class", "Target", "Netem"})
+	for _, host := range m.SelectHosts(hostSpec) {
+		hostRules, _ := rules.Get(host.GetPath())
+		for _, rule := range hostRules {
+			t.AppendRow(table.Row{host.GetId(), fmt.Sprintf("1:%x", max(rule.ClassId, 1)), rule.Target, rule.Params})
+		}
+	}
+
+	if _, err = fmt.Fprintln(cmd.OutOrStdout(), t.Render()); err != nil {
+		panic(err)
+	}
+}
+
+func newNetemClearCmd() *cobra.Command {
+	action := &netemClearAction{}
+
+	cmd := &cobra.Command{
+		Use:   "clear <hostSpec>",
+		Short: "remove netem rules from hosts",
+		Args:  cobra.ExactArgs(1),
+		Run:   action.run,
+	}
+
+	cmd.Flags().StringVar(&action.target.Ip, "ip", "", "only remove the rule for this ip")
+	cmd.Flags().Uint16Var(&action.target.Port, "port", 0, "only remove the rule for this port")
+	cmd.Flags().BoolVar(&action.global, "global", false, "only remove the rule applying to all traffic")
+
+	return cmd
+}
+
+type netemClearAction struct {
+	target model.NetemTarget
+	global bool
+}
+
+func (self *netemClearAction) run(_ *cobra.Command, args []string) {
+	if err := model.Bootstrap(); err != nil {
+		logrus.Fatalf("unable to bootstrap (%s)", err)
+	}
+
+	err := model.GetModel().ForEachHost(args[0], 10, func(host *model.Host) error {
+		if self.global || !self.target.IsGlobal() {
+			return host.ClearNetem(self.target)
+		}
+		return host.ClearAllNetem()
+	})
+	if err != nil {
+		logrus.WithError(err).Fatal("error clearing netem rules")
+	}
+}
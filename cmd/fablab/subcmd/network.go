@@ -0,0 +1,143 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package subcmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	networkCmd.AddCommand(newNetworkStatusCmd())
+	networkCmd.AddCommand(newNetworkHealCmd())
+	RootCmd.AddCommand(networkCmd)
+}
+
+var networkCmd = &cobra.Command{
+	Use:   "network",
+	Short: "inspect and remove network disruptions",
+}
+
+func newNetworkStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "list active network disruptions",
+		Args:  cobra.NoArgs,
+		Run:   networkStatus,
+	}
+}
+
+func networkStatus(cmd *cobra.Command, _ []string) {
+	if err := model.Bootstrap(); err != nil {
+		logrus.Fatalf("unable to bootstrap (%s)", err)
+	}
+
+	disruptions, err := model.GetDisruptionRegistry().List()
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to list network disruptions")
+	}
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.AppendHeader(table.Row{"#", "Host", "Firewall", "Rule", "Since"})
+	for idx, d := range disruptions {
+		t.AppendRow(table.Row{idx + 1, d.Host, d.Firewall, d.Rule, d.Created.Format(time.DateTime)})
+	}
+
+	if _, err = fmt.Fprintln(cmd.OutOrStdout(), t.Render()); err != nil {
+		panic(err)
+	}
+}
+
+func newNetworkHealCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "heal <hostSpec?>",
+		Short: "remove recorded network disruptions",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   networkHeal,
+	}
+}
+
+func networkHeal(_ *cobra.Command, args []string) {
+	if err := model.Bootstrap(); err != nil {
+		logrus.Fatalf("unable to bootstrap (%s)", err)
+	}
+
+	hostSpec := "*"
+	if len(args) > 0 {
+		hostSpec = args[0]
+	}
+
+	if err := model.GetModel().HealDisruptions(hostSpec, 25); err != nil {
+		logrus.WithError(err).Fatal("error healing network disruptions")
+	}
+}
+
+// healDisruptions removes the network disruptions recorded by the given run, logging any failure. Disruptions
+// recorded by other runs, which may still be in progress, are left in place.
+func healDisruptions(runId string) {
+	disruptions, err := model.GetDisruptionRegistry().List()
+	if err != nil {
+		pfxlog.Logger().WithError(err).Error("unable to list network disruptions")
+		return
+	}
+	count := 0
+	for _, d := range disruptions {
+		if d.Run == runId {
+			count++
+		}
+	}
+	if count == 0 {
+		return
+	}
+	pfxlog.Logger().Infof("healing %d network disruptions", count)
+	if err = model.GetModel().HealRunDisruptions(runId, 25); err != nil {
+		pfxlog.Logger().WithError(err).Error("error healing network disruptions")
+	}
+}
+
+// healOnInterrupt heals the network disruptions recorded by the given run and exits when the process is
+// interrupted or terminated
+func healOnInterrupt(runId string) {
+	exitOnInterrupt(func() {
+		healDisruptions(runId)
+	})
+}
+
+// exitOnInterrupt runs the handlers registered with model.OnInterrupt and the cleanup function, and exits when the
+// process is interrupted or terminated
+func exitOnInterrupt(cleanup func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		pfxlog.Logger().Warnf("received %s, cleaning up before exiting", sig)
+		signal.Stop(signals)
+		model.RunInterruptHandlers()
+		cleanup()
+		os.Exit(1)
+	}()
+}
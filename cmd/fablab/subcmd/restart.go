@@ -21,6 +21,7 @@ import (
 	"github.com/openziti/fablab/kernel/model"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"time"
 )
 
 func init() {
@@ -33,17 +34,25 @@ func newRestartCmd() *cobra.Command {
 	var cmd = &cobra.Command{
 		Use:   "restart <component-spec> [-c concurrency]",
 		Short: "restart components",
-		Args:  cobra.ExactArgs(1),
-		Run:   action.run,
+		Example: "fablab restart .router -c 20\n" +
+			"fablab restart .router --batch 5 --max-unavailable 1 --timeout 1m",
+		Args: cobra.ExactArgs(1),
+		Run:  action.run,
 	}
 
 	cmd.Flags().IntVarP(&action.concurrency, "concurrency", "c", 10, "Number of components to restart in parallel")
+	cmd.Flags().IntVar(&action.rolling.BatchSize, "batch", 0, "Restart components in health gated batches of this size")
+	cmd.Flags().IntVar(&action.rolling.BatchPercent, "batch-percent", 0, "Restart components in health gated batches of this percentage of the selected components")
+	cmd.Flags().IntVar(&action.rolling.MaxUnavailablePerRegion, "max-unavailable", 0, "Maximum number of components per region restarted in the same batch")
+	cmd.Flags().DurationVar(&action.rolling.HealthTimeout, "timeout", time.Minute, "How long to wait for a batch of components to be running")
+	cmd.Flags().DurationVar(&action.rolling.Pause, "pause", 0, "Time to wait between batches")
 
 	return cmd
 }
 
 type restartAction struct {
 	concurrency int
+	rolling     component.RollingRestartConfig
 }
 
 func (self *restartAction) run(_ *cobra.Command, args []string) {
@@ -56,6 +65,13 @@ func (self *restartAction) run(_ *cobra.Command, args []string) {
 		logrus.WithError(err).Fatal("error initializing run")
 	}
 
+	if self.rolling.BatchSize > 0 || self.rolling.BatchPercent > 0 {
+		if err = component.RollingRestart(args[0], self.rolling).Execute(ctx); err != nil {
+			logrus.WithError(err).Fatalf("error restarting components")
+		}
+		return
+	}
+
 	if err = component.StopInParallel(args[0], self.concurrency).Execute(ctx); err != nil {
 		logrus.WithError(err).Fatalf("error stopping components")
 	}
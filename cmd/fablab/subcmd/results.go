@@ -0,0 +1,99 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package subcmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	outputFormatTable = "table"
+	outputFormatJson  = "json"
+)
+
+func validateOutputFormat(format string) error {
+	if format != outputFormatTable && format != outputFormatJson {
+		return errors.Errorf("invalid output format [%s], must be one of [%s, %s]", format, outputFormatTable, outputFormatJson)
+	}
+	return nil
+}
+
+func renderActionResults(out io.Writer, format string, results []*model.ActionResult) error {
+	if format == outputFormatJson {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	}
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.AppendHeader(table.Row{"Action", "Entity", "Status", "Duration", "Details"})
+
+	for _, result := range results {
+		t.AppendRow(table.Row{result.Action, "", formatStatus(result.Success), formatResultDuration(result.Duration),
+			joinDetails(result.Error, result.Values)})
+
+		outcomes := result.GetOutcomes()
+		sort.SliceStable(outcomes, func(i, j int) bool {
+			return outcomes[i].EntityId < outcomes[j].EntityId
+		})
+		for _, outcome := range outcomes {
+			t.AppendRow(table.Row{"", outcome.EntityType + ": " + outcome.EntityId, formatStatus(outcome.Success),
+				formatResultDuration(outcome.Duration), joinDetails(outcome.Error, outcome.Values)})
+		}
+		t.AppendSeparator()
+	}
+
+	_, err := fmt.Fprintln(out, t.Render())
+	return err
+}
+
+func formatStatus(success bool) string {
+	if success {
+		return "ok"
+	}
+	return "FAILED"
+}
+
+func formatResultDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+func joinDetails(err string, values map[string]any) string {
+	var details []string
+	if err != "" {
+		details = append(details, err)
+	}
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		details = append(details, fmt.Sprintf("%s=%v", k, values[k]))
+	}
+	return strings.Join(details, "\n")
+}
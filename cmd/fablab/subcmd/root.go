@@ -21,6 +21,7 @@ import (
 	"path/filepath"
 
 	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/fablab/kernel/lib/actions/declarative"
 	"github.com/openziti/fablab/kernel/model"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -34,6 +35,8 @@ func init() {
 	options := pfxlog.DefaultOptions().SetTrimPrefix("github.com/openziti/").NoColor()
 	pfxlog.GlobalInit(logrus.InfoLevel, options)
 
+	model.AddBootstrapExtension(declarative.NewInstanceBootstrapExtension())
+
 	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose logging")
 	RootCmd.PersistentFlags().StringVarP(&model.CliInstanceId, "instance", "i", "", "specify the instance to use")
 	RootCmd.PersistentFlags().StringVar(&logFormatter, "log-formatter", "", "Specify log formatter [json|pfxlog|text]")
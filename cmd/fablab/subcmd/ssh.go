@@ -70,9 +70,13 @@ func (self *sshCmd) ssh(_ *cobra.Command, args []string) {
 		}
 	}
 
-	sshCfg := hosts[0].NewSshConfigFactory()
+	sshCfg, err := hosts[0].NewSshConfigFactory()
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to configure ssh")
+	}
 
-	if !self.forceBuiltIn {
+	// the ssh client can't be given a password, so hosts using passwords get the built-in client
+	if !self.forceBuiltIn && sshCfg.Password() == "" {
 		_, err := exec.LookPath("ssh")
 		if err == nil {
 			nativeSsh(sshCfg)
@@ -86,11 +90,12 @@ func (self *sshCmd) ssh(_ *cobra.Command, args []string) {
 }
 
 func nativeSsh(sshCfg libssh.SshConfigFactory) {
-	cmdArgs := []string{
-		"-i", sshCfg.KeyPath(),
-		"-o", "StrictHostKeyChecking no",
-		sshCfg.User() + "@" + sshCfg.Hostname(),
+	var cmdArgs []string
+	if keyPath := libssh.IdentityFile(sshCfg); keyPath != "" {
+		cmdArgs = append(cmdArgs, "-i", keyPath)
 	}
+	cmdArgs = append(cmdArgs, libssh.SshOptions(sshCfg)...)
+	cmdArgs = append(cmdArgs, sshCfg.User()+"@"+sshCfg.Hostname())
 
 	if sshCfg.Port() != 22 {
 		cmdArgs = append(cmdArgs, "-p", fmt.Sprintf("%v", sshCfg.Port()))
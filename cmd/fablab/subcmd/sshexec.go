@@ -36,6 +36,8 @@ func init() {
 type sshExecCmd struct {
 	cobraCmd    *cobra.Command
 	concurrency int
+	env         map[string]string
+	stdin       bool
 }
 
 func newSshExecCmd() *sshExecCmd {
@@ -43,16 +45,27 @@ func newSshExecCmd() *sshExecCmd {
 		cobraCmd: &cobra.Command{
 			Use:   "sshexec <hostSpec> <cmd> [<output-file-template>]",
 			Short: "establish an ssh connection to the model and runs the given command on the selected hosts",
-			Args:  cobra.RangeArgs(2, 3),
+			Long: "Runs the command on the selected hosts, showing stdout and stderr as they're written, with each line\n" +
+				"prefixed by the host id. If an output file template is given, the output of each host is written to\n" +
+				"its own file instead.",
+			Args: cobra.RangeArgs(2, 3),
 		},
 	}
 
 	cmd.cobraCmd.Run = cmd.run
 	cmd.cobraCmd.Flags().IntVarP(&cmd.concurrency, "concurrency", "c", 1, "Number of hosts to run in parallel")
+	cmd.cobraCmd.Flags().StringToStringVarP(&cmd.env, "env", "e", nil, "Environment variables for the command, as name=value")
+	cmd.cobraCmd.Flags().BoolVar(&cmd.stdin, "stdin", false, "Read stdin and send it to the command on each host")
 	return cmd
 }
 
 func (cmd *sshExecCmd) run(_ *cobra.Command, args []string) {
+	for name := range cmd.env {
+		if err := libssh.ValidateEnvName(name); err != nil {
+			logrus.WithError(err).Fatal("invalid --env")
+		}
+	}
+
 	if err := model.Bootstrap(); err != nil {
 		logrus.Fatalf("unable to bootstrap (%s)", err)
 	}
@@ -68,9 +81,27 @@ func (cmd *sshExecCmd) run(_ *cobra.Command, args []string) {
 		}
 	}
 
+	var input []byte
+	if cmd.stdin {
+		var err error
+		if input, err = io.ReadAll(os.Stdin); err != nil {
+			logrus.WithError(err).Fatal("unable to read stdin")
+		}
+	}
+
+	hostWidth := 0
+	for _, h := range m.SelectHosts(args[0]) {
+		hostWidth = max(hostWidth, len(h.Id))
+	}
+	stdout := &libssh.SyncWriter{Writer: os.Stdout}
+	stderr := &libssh.SyncWriter{Writer: os.Stderr}
+
 	err := m.ForEachHost(args[0], cmd.concurrency, func(h *model.Host) error {
-		var buf *libssh.SyncBuffer
-		var out io.Writer
+		options := &libssh.ExecOptions{Env: cmd.env}
+		if input != nil {
+			options.Stdin = bytes.NewReader(input)
+		}
+
 		if tmpl != nil {
 			buf := &bytes.Buffer{}
 			if err := tmpl.Execute(buf, h); err != nil {
@@ -82,22 +113,18 @@ func (cmd *sshExecCmd) run(_ *cobra.Command, args []string) {
 				return err
 			}
 			defer func() { _ = file.Close() }()
-			out = &libssh.SyncWriter{
+			out := &libssh.SyncWriter{
 				Writer: file,
 			}
+			options.Stdout, options.Stderr = out, out
 			logrus.Infof("[%v] output -> %v", h.PublicIp, fileName)
 		} else {
-			buf = &libssh.SyncBuffer{}
-			out = buf
+			options.Prefix = fmt.Sprintf("%-*s | ", hostWidth, h.Id)
+			options.Stdout, options.Stderr = stdout, stderr
 		}
-		if err := h.Exec(out, args[1]); err != nil {
-			if buf != nil {
-				logrus.Errorf("output [%s]", buf.String())
-			}
-			return fmt.Errorf("error executing process on [%s] (%s)", h.PublicIp, err)
-		}
-		if buf != nil {
-			logrus.Infof("[%v] output:\n%s", h.PublicIp, buf.String())
+
+		if err := h.ExecStream(args[1], options); err != nil {
+			return fmt.Errorf("error executing process on host [%s] (%w)", h.Id, err)
 		}
 		return nil
 	})
@@ -0,0 +1,139 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package subcmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/openziti/fablab/kernel/libssh"
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd := newTunnelCmd()
+	RootCmd.AddCommand(cmd.cobraCmd)
+}
+
+type tunnelCmd struct {
+	cobraCmd *cobra.Command
+	local    []string
+	remote   []string
+}
+
+func newTunnelCmd() *tunnelCmd {
+	cmd := &tunnelCmd{
+		cobraCmd: &cobra.Command{
+			Use:   "tunnel <hostSpec>",
+			Short: "forward ports to and from the selected hosts until interrupted",
+			Long: "Forwards are given as [bind_address:]port:[host:]hostport, as with the -L and -R options of ssh, or as a\n" +
+				"bare port which is forwarded to the same port. Local forwards (-L) listen locally and connect to the host\n" +
+				"and hostport from the model host, so they reach ports bound to the host's loopback and private addresses\n" +
+				"reachable from it. Remote forwards (-R) listen on the model host and connect back to the local host and\n" +
+				"hostport.\n\n" +
+				"When several hosts are selected, each host's local forwards listen on the port plus the host's index in\n" +
+				"the selection, so -L 6262 on three hosts listens on 6262, 6263 and 6264. A port of 0 listens on any free\n" +
+				"port. The chosen addresses are logged.",
+			Example: "  fablab tunnel 'component.ctrl' -L 1280\n" +
+				"  fablab tunnel 'host.router' -L 0:localhost:6060 -R 9000:localhost:9000",
+			Args: cobra.ExactArgs(1),
+		},
+	}
+
+	cmd.cobraCmd.Run = cmd.run
+	cmd.cobraCmd.Flags().StringArrayVarP(&cmd.local, "local", "L", nil, "Local forward, as [bind_address:]port:[host:]hostport")
+	cmd.cobraCmd.Flags().StringArrayVarP(&cmd.remote, "remote", "R", nil, "Remote forward, as [bind_address:]port:[host:]hostport")
+	return cmd
+}
+
+func (cmd *tunnelCmd) run(_ *cobra.Command, args []string) {
+	local, err := parseForwards(cmd.local)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid local forward")
+	}
+	remote, err := parseForwards(cmd.remote)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid remote forward")
+	}
+	if len(local) == 0 && len(remote) == 0 {
+		logrus.Fatal("no forwards given, use -L or -R")
+	}
+
+	if err = model.Bootstrap(); err != nil {
+		logrus.Fatalf("unable to bootstrap (%s)", err)
+	}
+
+	hosts := model.GetModel().SelectHosts(args[0])
+	if len(hosts) == 0 {
+		logrus.Fatalf("no hosts matched [%s]", args[0])
+	}
+
+	var tunnels []*libssh.Tunnel
+	closeAll := func() {
+		for _, tunnel := range tunnels {
+			_ = tunnel.Close()
+		}
+	}
+
+	for idx, host := range hosts {
+		log := logrus.WithField("hostId", host.Id)
+		for _, forward := range local {
+			bind := *forward
+			if bind.Port != 0 {
+				bind.Port += idx
+			}
+			tunnel, err := host.LocalForward(bind.BindAddr(), bind.TargetAddr())
+			if err != nil {
+				closeAll()
+				log.WithError(err).Fatalf("unable to forward [%s] to [%s]", bind.BindAddr(), host.Id)
+			}
+			tunnels = append(tunnels, tunnel)
+			log.Infof("forwarding local [%s] to [%s] on [%s]", tunnel.Addr(), bind.TargetAddr(), host.Id)
+		}
+		for _, forward := range remote {
+			tunnel, err := host.RemoteForward(forward.BindAddr(), forward.TargetAddr())
+			if err != nil {
+				closeAll()
+				log.WithError(err).Fatalf("unable to forward [%s] on [%s]", forward.BindAddr(), host.Id)
+			}
+			tunnels = append(tunnels, tunnel)
+			log.Infof("forwarding [%s] on [%s] to local [%s]", tunnel.Addr(), host.Id, forward.TargetAddr())
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	logrus.Infof("%d tunnel(s) open, interrupt to close", len(tunnels))
+	sig := <-signals
+	logrus.Infof("received %s, closing tunnels", sig)
+	closeAll()
+}
+
+func parseForwards(specs []string) ([]*libssh.Forward, error) {
+	var result []*libssh.Forward
+	for _, spec := range specs {
+		forward, err := libssh.ParseForward(spec)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, forward)
+	}
+	return result, nil
+}
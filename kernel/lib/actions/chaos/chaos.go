@@ -0,0 +1,233 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package chaos
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/pkg/errors"
+)
+
+type FaultType string
+
+const (
+	// FaultStop stops components. They are started again when the fault is reverted
+	FaultStop FaultType = "stop"
+	// FaultKill sends SIGKILL to component processes. They are started again when the fault is reverted
+	FaultKill FaultType = "kill"
+	// FaultRestart stops and starts components. There is nothing to revert
+	FaultRestart FaultType = "restart"
+	// FaultBlock blocks network traffic on hosts. The block is removed when the fault is reverted
+	FaultBlock FaultType = "block"
+)
+
+// FaultSpec describes a kind of fault which may be injected
+type FaultSpec struct {
+	Type FaultType
+	// Selector picks the candidate components, or hosts for FaultBlock
+	Selector string
+	// Count is how many entities are affected by a single injection, defaults to 1
+	Count int
+	// Weight is the relative likelihood of this fault being picked, defaults to 1
+	Weight int
+	// Ports are the incoming TCP ports blocked by FaultBlock. If empty, all outgoing traffic
+	// to the other hosts in the model is blocked, isolating the host
+	Ports []uint16
+}
+
+func (self *FaultSpec) count() int {
+	return max(self.Count, 1)
+}
+
+func (self *FaultSpec) weight() int {
+	return max(self.Weight, 1)
+}
+
+func (self *FaultSpec) validate() error {
+	switch self.Type {
+	case FaultStop, FaultKill, FaultRestart, FaultBlock:
+	default:
+		return errors.Errorf("unsupported fault type [%s]", self.Type)
+	}
+	if self.Selector == "" {
+		return errors.Errorf("fault type [%s] requires a selector", self.Type)
+	}
+	return nil
+}
+
+// candidates returns the entities the fault may be applied to, in a stable order
+func (self *FaultSpec) candidates(m *model.Model) []model.Entity {
+	var result []model.Entity
+	if self.Type == FaultBlock {
+		for _, h := range m.SelectHosts(self.Selector) {
+			result = append(result, h)
+		}
+		return result
+	}
+
+	for _, c := range m.SelectComponents(self.Selector) {
+		if c.Type == nil {
+			continue
+		}
+		if self.Type == FaultKill {
+			if _, ok := c.Type.(model.ProcessComponent); !ok {
+				continue
+			}
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// Config controls the chaos schedule. The same seed, config and model always produce the same schedule
+type Config struct {
+	// Seed for the random schedule. If 0, a seed is generated and logged, so the run can be replayed
+	Seed int64
+	// Duration is how long faults are injected for
+	Duration time.Duration
+	// Interval is the minimum time between injections
+	Interval time.Duration
+	// Jitter is the maximum random delay added to each interval
+	Jitter time.Duration
+	// FaultDuration is how long a fault lasts before it is reverted. If 0, faults last until the end of the run
+	FaultDuration time.Duration
+	Faults        []*FaultSpec
+}
+
+// PlannedFault is a single scheduled fault injection
+type PlannedFault struct {
+	Spec     *FaultSpec
+	Targets  []model.Entity
+	Offset   time.Duration
+	RevertAt time.Duration
+}
+
+func (self *PlannedFault) revertible() bool {
+	return self.Spec.Type != FaultRestart
+}
+
+// Plan computes the fault schedule for the given model and config. Entities which are still affected by an
+// earlier fault are not picked again until that fault is reverted.
+func Plan(m *model.Model, config Config) ([]*PlannedFault, error) {
+	return plan(config, func(spec *FaultSpec) []model.Entity {
+		return spec.candidates(m)
+	})
+}
+
+func plan(config Config, candidates func(spec *FaultSpec) []model.Entity) ([]*PlannedFault, error) {
+	if len(config.Faults) == 0 {
+		return nil, errors.New("no faults configured")
+	}
+	if config.Interval <= 0 {
+		return nil, errors.New("interval must be greater than 0")
+	}
+
+	totalWeight := 0
+	for _, spec := range config.Faults {
+		if err := spec.validate(); err != nil {
+			return nil, err
+		}
+		totalWeight += spec.weight()
+	}
+
+	rng := rand.New(rand.NewSource(config.Seed))
+	nextInterval := func() time.Duration {
+		result := config.Interval
+		if config.Jitter > 0 {
+			result += time.Duration(rng.Int63n(int64(config.Jitter)))
+		}
+		return result
+	}
+
+	var result []*PlannedFault
+	activeUntil := map[model.Entity]time.Duration{}
+
+	for offset := nextInterval(); offset < config.Duration; offset += nextInterval() {
+		spec := pickFault(rng, config.Faults, totalWeight)
+
+		var available []model.Entity
+		for _, entity := range candidates(spec) {
+			if activeUntil[entity] <= offset {
+				available = append(available, entity)
+			}
+		}
+
+		if len(available) == 0 {
+			continue
+		}
+
+		fault := &PlannedFault{
+			Spec:     spec,
+			Offset:   offset,
+			RevertAt: config.Duration,
+		}
+
+		if !fault.revertible() {
+			fault.RevertAt = offset
+		} else if config.FaultDuration > 0 && offset+config.FaultDuration < config.Duration {
+			fault.RevertAt = offset + config.FaultDuration
+		}
+
+		for _, idx := range rng.Perm(len(available))[:min(spec.count(), len(available))] {
+			fault.Targets = append(fault.Targets, available[idx])
+			activeUntil[available[idx]] = fault.RevertAt
+		}
+
+		result = append(result, fault)
+	}
+
+	return result, nil
+}
+
+func pickFault(rng *rand.Rand, faults []*FaultSpec, totalWeight int) *FaultSpec {
+	choice := rng.Intn(totalWeight)
+	for _, spec := range faults {
+		if choice < spec.weight() {
+			return spec
+		}
+		choice -= spec.weight()
+	}
+	return faults[len(faults)-1]
+}
+
+type event struct {
+	offset time.Duration
+	revert bool
+	fault  *PlannedFault
+}
+
+// timeline orders injections and reverts by time. Reverts come before injections scheduled at the same time,
+// so an entity is restored before it is picked again.
+func timeline(plan []*PlannedFault) []*event {
+	var result []*event
+	for _, fault := range plan {
+		result = append(result, &event{offset: fault.Offset, fault: fault})
+		if fault.revertible() {
+			result = append(result, &event{offset: fault.RevertAt, revert: true, fault: fault})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].offset == result[j].offset {
+			return result[i].revert && !result[j].revert
+		}
+		return result[i].offset < result[j].offset
+	})
+	return result
+}
@@ -0,0 +1,150 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package chaos
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/stretchr/testify/require"
+)
+
+var testComponents = map[string]*model.Component{}
+
+func testComponent(id string) *model.Component {
+	if c, found := testComponents[id]; found {
+		return c
+	}
+	c := &model.Component{Id: id}
+	testComponents[id] = c
+	return c
+}
+
+var testHost = &model.Host{Id: "router"}
+
+func testCandidates(spec *FaultSpec) []model.Entity {
+	var result []model.Entity
+	switch spec.Selector {
+	case ".router":
+		for _, id := range []string{"router1", "router2", "router3", "router4"} {
+			result = append(result, testComponent(id))
+		}
+	case "*":
+		for _, id := range []string{"ctrl1", "router1", "router2"} {
+			result = append(result, testComponent(id))
+		}
+	case "host.router":
+		result = append(result, testHost)
+	}
+	return result
+}
+
+func describe(plan []*PlannedFault) []string {
+	var result []string
+	for _, fault := range plan {
+		entry := fault.Offset.String() + " " + string(fault.Spec.Type)
+		for _, target := range fault.Targets {
+			entry += " " + target.GetId()
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func TestPlanIsReproducible(t *testing.T) {
+	req := require.New(t)
+
+	config := Config{
+		Seed:          42,
+		Duration:      10 * time.Minute,
+		Interval:      20 * time.Second,
+		Jitter:        10 * time.Second,
+		FaultDuration: 45 * time.Second,
+		Faults: []*FaultSpec{
+			{Type: FaultStop, Selector: ".router", Weight: 3},
+			{Type: FaultRestart, Selector: "*", Count: 2},
+			{Type: FaultBlock, Selector: "host.router"},
+		},
+	}
+
+	schedule, err := plan(config, testCandidates)
+	req.NoError(err)
+	req.NotEmpty(schedule)
+
+	replay, err := plan(config, testCandidates)
+	req.NoError(err)
+	req.Equal(describe(schedule), describe(replay))
+
+	config.Seed = 43
+	other, err := plan(config, testCandidates)
+	req.NoError(err)
+	req.NotEqual(describe(schedule), describe(other))
+
+	// an entity is never targeted by two overlapping faults
+	for i, fault := range schedule {
+		req.True(fault.Offset < config.Duration)
+		req.True(fault.RevertAt <= config.Duration)
+		for _, later := range schedule[i+1:] {
+			if later.Offset >= fault.RevertAt {
+				continue
+			}
+			for _, target := range fault.Targets {
+				req.NotContains(later.Targets, target)
+			}
+		}
+	}
+}
+
+func TestPlanValidation(t *testing.T) {
+	req := require.New(t)
+
+	_, err := plan(Config{Duration: time.Minute, Interval: time.Second}, testCandidates)
+	req.ErrorContains(err, "no faults configured")
+
+	_, err = plan(Config{Duration: time.Minute, Faults: []*FaultSpec{{Type: FaultStop, Selector: "*"}}}, testCandidates)
+	req.ErrorContains(err, "interval")
+
+	_, err = plan(Config{Duration: time.Minute, Interval: time.Second, Faults: []*FaultSpec{{Type: "melt", Selector: "*"}}}, testCandidates)
+	req.ErrorContains(err, "unsupported fault type")
+
+	// faults without candidates are skipped
+	result, err := plan(Config{Duration: time.Minute, Interval: time.Second, Faults: []*FaultSpec{{Type: FaultStop, Selector: ".missing"}}}, testCandidates)
+	req.NoError(err)
+	req.Empty(result)
+}
+
+func TestTimelineRevertsBeforeInjecting(t *testing.T) {
+	req := require.New(t)
+	stop := &FaultSpec{Type: FaultStop, Selector: "*"}
+	restart := &FaultSpec{Type: FaultRestart, Selector: "*"}
+	schedule := []*PlannedFault{
+		{Spec: stop, Offset: time.Second, RevertAt: 3 * time.Second},
+		{Spec: restart, Offset: 2 * time.Second, RevertAt: 2 * time.Second},
+		{Spec: stop, Offset: 3 * time.Second, RevertAt: 5 * time.Second},
+	}
+
+	events := timeline(schedule)
+	req.Len(events, 5)
+	req.Equal(schedule[0], events[0].fault)
+	req.Equal(schedule[1], events[1].fault)
+	req.True(events[2].revert)
+	req.Equal(schedule[0], events[2].fault)
+	req.False(events[3].revert)
+	req.Equal(schedule[2], events[3].fault)
+	req.True(events[4].revert)
+}
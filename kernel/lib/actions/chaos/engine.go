@@ -0,0 +1,296 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/fablab/kernel/model"
+)
+
+// Engine returns an action which injects faults according to a seeded random schedule. Every injection and
+// revert is logged with a timestamp to a file in the forensics directory for the run. Faults which are still
+// active when the run ends, when the engine fails, or when fablab is interrupted, are reverted. A run can be
+// replayed by passing the seed from the log.
+//
+// The action accepts the arguments seed, duration and interval, which override the values provided here.
+func Engine(config Config) model.ParameterizedAction {
+	return &engine{config: config}
+}
+
+type engine struct {
+	config Config
+}
+
+func (self *engine) GetParams() []*model.ActionParam {
+	return []*model.ActionParam{
+		model.IntParam("seed", int(self.config.Seed), "seed for the fault schedule, 0 to generate one"),
+		model.DurationParam("duration", self.config.Duration, "how long to inject faults for"),
+		model.DurationParam("interval", self.config.Interval, "minimum time between fault injections"),
+	}
+}
+
+func (self *engine) Execute(run model.Run) error {
+	config := self.config
+
+	args := model.GetArgs(run)
+	if args.Has("seed") {
+		config.Seed = int64(args.GetInt("seed"))
+	}
+	if args.Has("duration") {
+		config.Duration = args.GetDuration("duration")
+	}
+	if args.Has("interval") {
+		config.Interval = args.GetDuration("interval")
+	}
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+
+	plan, err := Plan(run.GetModel(), config)
+	if err != nil {
+		return err
+	}
+
+	journal, err := newJournal(run, config.Seed)
+	if err != nil {
+		return err
+	}
+	defer journal.close()
+
+	model.GetResult(run).SetValue("seed", config.Seed)
+	model.GetResult(run).SetValue("journal", journal.path)
+
+	journal.log("starting chaos run, seed: %d, duration: %s, interval: %s, jitter: %s, planned faults: %d",
+		config.Seed, config.Duration, config.Interval, config.Jitter, len(plan))
+
+	// active holds the targets of each fault which need reverting, which may be fewer than the fault's targets if
+	// some injections failed. It's locked while a fault is injected or reverted, so that an interrupt waits for the
+	// injection in progress, and reverts it too.
+	var lock sync.Mutex
+	active := map[*PlannedFault][]model.Entity{}
+	stopped := false
+	revertActive := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		stopped = true
+		for _, fault := range plan {
+			if targets, found := active[fault]; found {
+				delete(active, fault)
+				self.revert(run, journal, fault, targets)
+			}
+		}
+	}
+
+	removeInterruptHandler := model.OnInterrupt(func() {
+		journal.log("chaos run interrupted, reverting active faults, seed: %d", config.Seed)
+		revertActive()
+	})
+	defer func() {
+		removeInterruptHandler()
+		revertActive()
+		journal.log("chaos run complete, seed: %d", config.Seed)
+	}()
+
+	failures := 0
+	step := func(evt *event) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		if stopped {
+			return false
+		}
+		if evt.revert {
+			if targets, found := active[evt.fault]; found {
+				delete(active, evt.fault)
+				self.revert(run, journal, evt.fault, targets)
+			}
+			return true
+		}
+		injected, err := self.inject(run, journal, evt.fault)
+		if err != nil {
+			failures++
+		}
+		if len(injected) > 0 && evt.fault.revertible() {
+			active[evt.fault] = injected
+		}
+		return true
+	}
+
+	start := time.Now()
+	for _, evt := range timeline(plan) {
+		time.Sleep(time.Until(start.Add(evt.offset)))
+		if !step(evt) {
+			break
+		}
+	}
+	time.Sleep(time.Until(start.Add(config.Duration)))
+
+	model.GetResult(run).SetValue("injected", len(plan)-failures)
+	model.GetResult(run).SetValue("failed", failures)
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d fault injections failed, see %s (seed: %d)", failures, len(plan), journal.path, config.Seed)
+	}
+	return nil
+}
+
+// inject applies the fault to each of its targets, returning the targets which need reverting along with an error
+// for the targets which failed. Hosts are returned even if they failed, as blocks may have been applied for some
+// ports or peers before the failure, and reverting blocks which weren't applied is harmless.
+func (self *engine) inject(run model.Run, journal *journal, fault *PlannedFault) ([]model.Entity, error) {
+	var injected []model.Entity
+	var errs []string
+	for _, target := range fault.Targets {
+		journal.log("inject %s %s", fault.Spec.Type, model.GetEntityPathId(target))
+		err := model.RecordOutcome(run, target, func(outcome *model.Outcome) error {
+			outcome.SetValue("fault", string(fault.Spec.Type))
+			return injectFault(run, fault.Spec, target)
+		})
+		if err != nil {
+			journal.log("inject %s %s failed: %v", fault.Spec.Type, model.GetEntityPathId(target), err)
+			errs = append(errs, err.Error())
+			if _, isHost := target.(*model.Host); !isHost {
+				continue
+			}
+		}
+		injected = append(injected, target)
+	}
+	if len(errs) > 0 {
+		return injected, errors.New(strings.Join(errs, "; "))
+	}
+	return injected, nil
+}
+
+func (self *engine) revert(run model.Run, journal *journal, fault *PlannedFault, targets []model.Entity) {
+	for _, target := range targets {
+		journal.log("revert %s %s", fault.Spec.Type, model.GetEntityPathId(target))
+		if err := revertFault(run, fault.Spec, target); err != nil {
+			journal.log("revert %s %s failed: %v", fault.Spec.Type, model.GetEntityPathId(target), err)
+		}
+	}
+}
+
+func injectFault(run model.Run, spec *FaultSpec, target model.Entity) error {
+	switch entity := target.(type) {
+	case *model.Component:
+		switch spec.Type {
+		case FaultStop:
+			return entity.Stop(run)
+		case FaultKill:
+			return entity.Host.KillProcesses("-KILL", entity.Type.(model.ProcessComponent).GetProcessFilter(entity))
+		case FaultRestart:
+			if err := entity.Stop(run); err != nil {
+				return err
+			}
+			return entity.Start(run)
+		}
+	case *model.Host:
+		if spec.Type == FaultBlock {
+			for _, port := range spec.Ports {
+				if err := entity.DisruptIncoming(port); err != nil {
+					return err
+				}
+			}
+			if len(spec.Ports) == 0 {
+				for _, ip := range peerIps(entity) {
+					if err := entity.DisruptOutgoingHost(ip); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("fault type [%s] can't be applied to %s [%s]", spec.Type, target.GetType(), target.GetId())
+}
+
+func revertFault(run model.Run, spec *FaultSpec, target model.Entity) error {
+	switch entity := target.(type) {
+	case *model.Component:
+		return entity.Start(run)
+	case *model.Host:
+		var errs []error
+		for _, port := range spec.Ports {
+			errs = append(errs, entity.RemoveRules(model.IncomingRule(model.ProtocolTcp, model.SinglePort(port))))
+		}
+		if len(spec.Ports) == 0 {
+			for _, ip := range peerIps(entity) {
+				errs = append(errs, entity.RemoveRules(model.OutgoingRule(model.ProtocolAll, ip, model.PortRange{})))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// peerIps returns the addresses of all other hosts in the model
+func peerIps(host *model.Host) []string {
+	var result []string
+	for _, peer := range host.GetModel().SelectHosts("*") {
+		if peer == host {
+			continue
+		}
+		for _, ip := range []string{peer.PublicIp, peer.PrivateIp} {
+			if ip != "" {
+				result = append(result, ip)
+			}
+		}
+	}
+	return result
+}
+
+type journal struct {
+	path string
+	file *os.File
+	lock sync.Mutex
+}
+
+func newJournal(run model.Run, seed int64) (*journal, error) {
+	path := filepath.Join(model.AllocateForensicScenario(run.GetId(), "chaos"), fmt.Sprintf("chaos-%d.log", seed))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("unable to create chaos forensics directory [%s] (%w)", filepath.Dir(path), err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open chaos log [%s] (%w)", path, err)
+	}
+	return &journal{path: path, file: file}, nil
+}
+
+func (self *journal) log(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	pfxlog.Logger().Info(msg)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, err := fmt.Fprintf(self.file, "%s %s\n", time.Now().Format(time.RFC3339Nano), msg); err != nil {
+		pfxlog.Logger().WithError(err).Errorf("unable to write to chaos log [%s]", self.path)
+	}
+}
+
+func (self *journal) close() {
+	if err := self.file.Close(); err != nil {
+		pfxlog.Logger().WithError(err).Errorf("unable to close chaos log [%s]", self.path)
+	}
+}
@@ -0,0 +1,278 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/fablab/kernel/lib/parallel"
+	"github.com/openziti/fablab/kernel/model"
+)
+
+// ErrTimedOut is wrapped by the error returned from an action run with Timeout, when the timeout is exceeded
+var ErrTimedOut = errors.New("timed out")
+
+// A NamedAction is an action which has a name that can be used when reporting errors
+type NamedAction interface {
+	model.Action
+	GetName() string
+}
+
+// ActionName returns the name of the given action, if it has one, otherwise its type
+func ActionName(action model.Action) string {
+	if named, ok := action.(NamedAction); ok {
+		return named.GetName()
+	}
+	return fmt.Sprintf("%T", action)
+}
+
+// Named wraps the given action with a name, which is used when reporting errors
+func Named(name string, action model.Action) NamedAction {
+	return &namedAction{
+		name:   name,
+		action: action,
+	}
+}
+
+type namedAction struct {
+	name   string
+	action model.Action
+}
+
+func (self *namedAction) GetName() string {
+	return self.name
+}
+
+func (self *namedAction) Execute(run model.Run) error {
+	return self.action.Execute(run)
+}
+
+// Parallel returns an action which executes all the given actions concurrently. All actions are run to
+// completion, and any errors are reported together.
+func Parallel(actions ...model.Action) model.Action {
+	return ParallelN(len(actions), actions...)
+}
+
+// ParallelN returns an action which executes the given actions with at most concurrency actions running
+// at once. All actions are run to completion, and any errors are reported together.
+func ParallelN(concurrency int, actions ...model.Action) model.Action {
+	return &parallelActions{
+		concurrency: concurrency,
+		actions:     actions,
+	}
+}
+
+type parallelActions struct {
+	concurrency int
+	actions     []model.Action
+}
+
+func (self *parallelActions) Execute(run model.Run) error {
+	var tasks []parallel.Task
+	for idx, action := range self.actions {
+		tasks = append(tasks, func() error {
+			if err := action.Execute(run); err != nil {
+				return fmt.Errorf("error executing parallel branch %d [%s] (%w)", idx+1, ActionName(action), err)
+			}
+			return nil
+		})
+	}
+	return parallel.Execute(tasks, int64(max(self.concurrency, 1)))
+}
+
+// Timeout returns an action which fails if the given action doesn't complete within the given duration.
+// Since actions can't be interrupted, the wrapped action will continue running in the background.
+func Timeout(timeout time.Duration, action model.Action) model.Action {
+	return &timeoutAction{
+		timeout: timeout,
+		action:  action,
+	}
+}
+
+type timeoutAction struct {
+	timeout time.Duration
+	action  model.Action
+}
+
+func (self *timeoutAction) Execute(run model.Run) error {
+	errC := make(chan error, 1)
+	go func() {
+		errC <- self.action.Execute(run)
+	}()
+
+	select {
+	case err := <-errC:
+		return err
+	case <-time.After(self.timeout):
+		return fmt.Errorf("[%s] did not complete after %v (%w)", ActionName(self.action), self.timeout, ErrTimedOut)
+	}
+}
+
+// RetryPolicy configures how Retry re-attempts a failing action
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times the action will be tried. Values less than 1 are treated as 1
+	MaxAttempts int
+	// Delay is the time to wait before the first retry
+	Delay time.Duration
+	// Backoff is the multiplier applied to the delay after each retry. Values less than 1 are treated as 1
+	Backoff float64
+	// MaxDelay caps the delay between retries, if set
+	MaxDelay time.Duration
+	// RetryIf, if set, decides whether a given error should be retried. If not set, all errors are retried
+	RetryIf func(err error) bool
+}
+
+func (self *RetryPolicy) nextDelay(delay time.Duration) time.Duration {
+	if self.Backoff > 1 {
+		delay = time.Duration(float64(delay) * self.Backoff)
+	}
+	if self.MaxDelay > 0 && delay > self.MaxDelay {
+		delay = self.MaxDelay
+	}
+	return delay
+}
+
+// Retry returns an action which executes the given action, retrying it on failure according to the given policy
+func Retry(policy RetryPolicy, action model.Action) model.Action {
+	return &retryAction{
+		policy: policy,
+		action: action,
+	}
+}
+
+// RetryN returns an action which will try the given action up to attempts times, waiting delay between attempts
+func RetryN(attempts int, delay time.Duration, action model.Action) model.Action {
+	return Retry(RetryPolicy{MaxAttempts: attempts, Delay: delay}, action)
+}
+
+type retryAction struct {
+	policy RetryPolicy
+	action model.Action
+}
+
+func (self *retryAction) Execute(run model.Run) error {
+	maxAttempts := max(self.policy.MaxAttempts, 1)
+	delay := self.policy.Delay
+
+	for attempt := 1; ; attempt++ {
+		err := self.action.Execute(run)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= maxAttempts {
+			return fmt.Errorf("[%s] failed after %d attempt(s) (%w)", ActionName(self.action), attempt, err)
+		}
+
+		if self.policy.RetryIf != nil && !self.policy.RetryIf(err) {
+			return fmt.Errorf("[%s] failed with non-retryable error on attempt %d (%w)", ActionName(self.action), attempt, err)
+		}
+
+		pfxlog.Logger().WithError(err).Warnf("[%s] failed on attempt %d of %d, retrying in %v",
+			ActionName(self.action), attempt, maxAttempts, delay)
+		time.Sleep(delay)
+		delay = self.policy.nextDelay(delay)
+	}
+}
+
+// A Predicate is evaluated against a run to decide whether an action should be executed
+type Predicate func(run model.Run) bool
+
+// Not returns a predicate which is the inverse of the given predicate
+func Not(p Predicate) Predicate {
+	return func(run model.Run) bool {
+		return !p(run)
+	}
+}
+
+// ComponentsExist returns a predicate which is true if the given component spec matches at least one component
+func ComponentsExist(componentSpec string) Predicate {
+	return func(run model.Run) bool {
+		return len(run.GetModel().SelectComponents(componentSpec)) > 0
+	}
+}
+
+// HostsExist returns a predicate which is true if the given host spec matches at least one host
+func HostsExist(hostSpec string) Predicate {
+	return func(run model.Run) bool {
+		return len(run.GetModel().SelectHosts(hostSpec)) > 0
+	}
+}
+
+// VariableIsTrue returns a predicate which is true if the given model variable is set to true
+func VariableIsTrue(name string) Predicate {
+	return func(run model.Run) bool {
+		return run.GetModel().BoolVariable(name)
+	}
+}
+
+// If returns an action which executes the given action only when the predicate is true
+func If(predicate Predicate, action model.Action) model.Action {
+	return IfElse(predicate, action, nil)
+}
+
+// IfElse returns an action which executes ifAction when the predicate is true, and elseAction otherwise.
+// Either action may be nil, in which case nothing is done for that branch.
+func IfElse(predicate Predicate, ifAction, elseAction model.Action) model.Action {
+	return &conditionalAction{
+		predicate:  predicate,
+		ifAction:   ifAction,
+		elseAction: elseAction,
+	}
+}
+
+type conditionalAction struct {
+	predicate  Predicate
+	ifAction   model.Action
+	elseAction model.Action
+}
+
+func (self *conditionalAction) Execute(run model.Run) error {
+	action := self.elseAction
+	if self.predicate(run) {
+		action = self.ifAction
+	}
+	if action == nil {
+		return nil
+	}
+	return action.Execute(run)
+}
+
+// Finally returns an action which executes the given action and then always executes the cleanup actions,
+// regardless of whether the action succeeded
+func Finally(action model.Action, cleanup ...model.Action) model.Action {
+	return &finallyAction{
+		action:  action,
+		cleanup: cleanup,
+	}
+}
+
+type finallyAction struct {
+	action  model.Action
+	cleanup []model.Action
+}
+
+func (self *finallyAction) Execute(run model.Run) error {
+	var err error
+	if actionErr := self.action.Execute(run); actionErr != nil {
+		err = fmt.Errorf("error executing [%s] (%w)", ActionName(self.action), actionErr)
+	}
+	return runFinally(run, err, self.cleanup)
+}
@@ -104,11 +104,13 @@ type exec struct {
 
 func (self *exec) Execute(run model.Run) error {
 	return run.GetModel().ForEachComponent(self.componentSpec, self.concurrency, func(c *model.Component) error {
-		actions := c.GetActions()
-		if componentAction, ok := actions[self.action]; ok {
-			return componentAction.Execute(run, c)
-		}
-		return errors.Errorf("component [%s] does not implement action [%s]", c.Id, self.action)
+		return model.RecordOutcome(run, c, func(*model.Outcome) error {
+			actions := c.GetActions()
+			if componentAction, ok := actions[self.action]; ok {
+				return componentAction.Execute(run, c)
+			}
+			return errors.Errorf("component [%s] does not implement action [%s]", c.Id, self.action)
+		})
 	})
 }
 
@@ -122,7 +124,9 @@ func (self *execIfApplies) Execute(run model.Run) error {
 	return run.GetModel().ForEachComponent(self.componentSpec, self.concurrency, func(c *model.Component) error {
 		actions := c.GetActions()
 		if componentAction, ok := actions[self.action]; ok {
-			return componentAction.Execute(run, c)
+			return model.RecordOutcome(run, c, func(*model.Outcome) error {
+				return componentAction.Execute(run, c)
+			})
 		}
 		return nil
 	})
@@ -136,6 +140,8 @@ type execF struct {
 
 func (self *execF) Execute(run model.Run) error {
 	return run.GetModel().ForEachComponent(self.componentSpec, self.concurrency, func(c *model.Component) error {
-		return self.f(run, c)
+		return model.RecordOutcome(run, c, func(*model.Outcome) error {
+			return self.f(run, c)
+		})
 	})
 }
@@ -0,0 +1,70 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package component
+
+import (
+	"time"
+
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/pkg/errors"
+)
+
+// Pause stops the processes of the selected components with SIGSTOP, resuming them with SIGCONT after the
+// duration. The components stay up from the point of view of their peers, but don't respond. The components
+// must implement model.ProcessComponent.
+func Pause(componentSpec string, duration time.Duration) model.Action {
+	return &pause{
+		componentSpec: componentSpec,
+		duration:      duration,
+	}
+}
+
+func (self *pause) Execute(run model.Run) error {
+	return run.GetModel().ForEachComponent(self.componentSpec, 10, func(c *model.Component) error {
+		return model.RecordOutcome(run, c, func(*model.Outcome) error {
+			processComponent, ok := c.Type.(model.ProcessComponent)
+			if !ok {
+				return errors.Errorf("component [%s] doesn't support finding its processes, unable to pause", c.Id)
+			}
+			return c.Host.PauseProcesses(c.Id, processComponent.GetProcessFilter(c), self.duration)
+		})
+	})
+}
+
+type pause struct {
+	componentSpec string
+	duration      time.Duration
+}
+
+// Resume ends a pause started with Pause before its duration has passed
+func Resume(componentSpec string) model.Action {
+	return &resume{
+		componentSpec: componentSpec,
+	}
+}
+
+func (self *resume) Execute(run model.Run) error {
+	return run.GetModel().ForEachComponent(self.componentSpec, 10, func(c *model.Component) error {
+		return model.RecordOutcome(run, c, func(*model.Outcome) error {
+			return c.Host.ResumeProcesses(c.Id)
+		})
+	})
+}
+
+type resume struct {
+	componentSpec string
+}
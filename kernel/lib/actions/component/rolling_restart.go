@@ -0,0 +1,184 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package component
+
+import (
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/pkg/errors"
+)
+
+// RollingRestartConfig controls how a rolling restart is batched and health gated
+type RollingRestartConfig struct {
+	// BatchSize is the maximum number of components restarted together
+	BatchSize int
+	// BatchPercent, if set, sizes batches as a percentage of the selected components, instead of using BatchSize
+	BatchPercent int
+	// MaxUnavailablePerRegion limits how many components from the same region may be in a single batch. 0 means no limit
+	MaxUnavailablePerRegion int
+	// HealthTimeout is how long to wait for a restarted batch to pass its health check
+	HealthTimeout time.Duration
+	// Pause is an optional delay between batches
+	Pause time.Duration
+	// HealthCheck, if set, is used instead of waiting for the component to report it is running
+	HealthCheck model.ComponentAction
+}
+
+// RollingRestart returns an action which restarts the selected components in batches. Each batch is stopped,
+// started and must pass a health check before the next batch is restarted. If a batch fails, the restart is
+// aborted and the remaining components are left untouched.
+//
+// The action accepts the arguments selector, batch, batchPercent, maxUnavailable and timeout, which override
+// the values provided here.
+func RollingRestart(componentSpec string, config RollingRestartConfig) model.ParameterizedAction {
+	return &rollingRestart{
+		componentSpec: componentSpec,
+		config:        config,
+	}
+}
+
+type rollingRestart struct {
+	componentSpec string
+	config        RollingRestartConfig
+}
+
+func (self *rollingRestart) GetParams() []*model.ActionParam {
+	return []*model.ActionParam{
+		model.StringParam("selector", self.componentSpec, "components to restart"),
+		model.IntParam("batch", self.config.BatchSize, "number of components to restart at once"),
+		model.IntParam("batchPercent", self.config.BatchPercent, "percentage of components to restart at once, overrides batch"),
+		model.IntParam("maxUnavailable", self.config.MaxUnavailablePerRegion, "maximum components per region restarted at once, 0 for no limit"),
+		model.DurationParam("timeout", self.config.HealthTimeout, "how long to wait for a batch to become healthy"),
+	}
+}
+
+func (self *rollingRestart) Execute(run model.Run) error {
+	componentSpec := self.componentSpec
+	config := self.config
+
+	args := model.GetArgs(run)
+	if args.Has("selector") {
+		componentSpec = args.GetString("selector")
+	}
+	if args.Has("batch") {
+		config.BatchSize = args.GetInt("batch")
+	}
+	if args.Has("batchPercent") {
+		config.BatchPercent = args.GetInt("batchPercent")
+	}
+	if args.Has("maxUnavailable") {
+		config.MaxUnavailablePerRegion = args.GetInt("maxUnavailable")
+	}
+	if args.Has("timeout") {
+		config.HealthTimeout = args.GetDuration("timeout")
+	}
+
+	components := run.GetModel().SelectComponents(componentSpec)
+	if err := checkRestartable(components); err != nil {
+		return err
+	}
+	batches := planRollingBatches(components, config)
+
+	log := pfxlog.Logger().WithField("selector", componentSpec)
+	log.Infof("restarting %d components in %d batches", len(components), len(batches))
+	model.GetResult(run).SetValue("batches", len(batches))
+
+	for idx, batch := range batches {
+		if idx > 0 && config.Pause > 0 {
+			time.Sleep(config.Pause)
+		}
+
+		log.Infof("restarting batch %d/%d (%d components)", idx+1, len(batches), len(batch))
+		if err := self.restartBatch(run, idx+1, batch, config); err != nil {
+			remaining := 0
+			for _, next := range batches[idx+1:] {
+				remaining += len(next)
+			}
+			model.GetResult(run).SetValue("untouched", remaining)
+			return errors.Wrapf(err, "rolling restart aborted at batch %d/%d, %d components left untouched", idx+1, len(batches), remaining)
+		}
+	}
+
+	log.Infof("%d components restarted", len(components))
+	return nil
+}
+
+func (self *rollingRestart) restartBatch(run model.Run, batchNumber int, batch []*model.Component, config RollingRestartConfig) error {
+	return run.GetModel().ForEachComponentIn(batch, len(batch), func(c *model.Component) error {
+		return model.RecordOutcome(run, c, func(outcome *model.Outcome) error {
+			outcome.SetValue("batch", batchNumber)
+
+			if err := c.Stop(run); err != nil {
+				return errors.Wrapf(err, "error stopping component [%s]", c.Id)
+			}
+
+			if err := c.Start(run); err != nil {
+				return errors.Wrapf(err, "error starting component [%s]", c.Id)
+			}
+
+			if config.HealthCheck != nil {
+				return config.HealthCheck.Execute(run, c)
+			}
+			return waitForRunning(run, c, config.HealthTimeout, outcome)
+		})
+	})
+}
+
+// checkRestartable returns an error if any of the components can't be started again once stopped, so that the
+// restart fails before anything is stopped
+func checkRestartable(components []*model.Component) error {
+	for _, c := range components {
+		if c.Type == nil {
+			return errors.Errorf("component [%s] has no component type defined", c.Id)
+		}
+		if _, ok := c.Type.(model.ServerComponent); !ok {
+			return errors.Errorf("component [%s] can't be started, only server components can be restarted", c.Id)
+		}
+	}
+	return nil
+}
+
+// planRollingBatches splits the components into batches, honoring the batch size and the per region limit.
+// Components which don't fit into a batch because of the region limit are deferred to a later batch.
+func planRollingBatches(components []*model.Component, config RollingRestartConfig) [][]*model.Component {
+	batchSize := config.BatchSize
+	if config.BatchPercent > 0 {
+		batchSize = (len(components)*config.BatchPercent + 99) / 100
+	}
+	batchSize = max(batchSize, 1)
+
+	var batches [][]*model.Component
+	remaining := components
+	for len(remaining) > 0 {
+		var batch, deferred []*model.Component
+		regionCounts := map[string]int{}
+		for _, c := range remaining {
+			regionId := c.GetRegion().Id
+			if len(batch) < batchSize && (config.MaxUnavailablePerRegion < 1 || regionCounts[regionId] < config.MaxUnavailablePerRegion) {
+				batch = append(batch, c)
+				regionCounts[regionId]++
+			} else {
+				deferred = append(deferred, c)
+			}
+		}
+		batches = append(batches, batch)
+		remaining = deferred
+	}
+	return batches
+}
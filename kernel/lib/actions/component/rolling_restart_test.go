@@ -0,0 +1,79 @@
+package component
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/stretchr/testify/require"
+)
+
+func testComponents(counts map[string]int, regionIds ...string) []*model.Component {
+	var result []*model.Component
+	for _, regionId := range regionIds {
+		region := &model.Region{Id: regionId}
+		for i := 0; i < counts[regionId]; i++ {
+			host := &model.Host{Id: fmt.Sprintf("%s-host%d", regionId, i), Region: region}
+			result = append(result, &model.Component{Id: fmt.Sprintf("%s-c%d", regionId, i), Host: host})
+		}
+	}
+	return result
+}
+
+func batchIds(batches [][]*model.Component) [][]string {
+	var result [][]string
+	for _, batch := range batches {
+		var ids []string
+		for _, c := range batch {
+			ids = append(ids, c.Id)
+		}
+		result = append(result, ids)
+	}
+	return result
+}
+
+func TestPlanRollingBatches_BatchSize(t *testing.T) {
+	components := testComponents(map[string]int{"us": 5}, "us")
+	batches := planRollingBatches(components, RollingRestartConfig{BatchSize: 2})
+	require.Equal(t, [][]string{{"us-c0", "us-c1"}, {"us-c2", "us-c3"}, {"us-c4"}}, batchIds(batches))
+}
+
+func TestPlanRollingBatches_BatchPercent(t *testing.T) {
+	components := testComponents(map[string]int{"us": 10}, "us")
+	batches := planRollingBatches(components, RollingRestartConfig{BatchPercent: 25})
+	require.Len(t, batches, 4)
+	require.Len(t, batches[0], 3)
+	require.Len(t, batches[3], 1)
+}
+
+func TestPlanRollingBatches_MaxUnavailablePerRegion(t *testing.T) {
+	components := testComponents(map[string]int{"eu": 3, "us": 1}, "eu", "us")
+	batches := planRollingBatches(components, RollingRestartConfig{BatchSize: 3, MaxUnavailablePerRegion: 1})
+	require.Equal(t, [][]string{{"eu-c0", "us-c0"}, {"eu-c1"}, {"eu-c2"}}, batchIds(batches))
+}
+
+type stoppableType struct{}
+
+func (stoppableType) Label() string      { return "stoppable" }
+func (stoppableType) GetVersion() string { return "" }
+func (stoppableType) Dump() any          { return nil }
+func (stoppableType) IsRunning(model.Run, *model.Component) (bool, error) {
+	return false, nil
+}
+func (stoppableType) Stop(model.Run, *model.Component) error { return nil }
+
+type serverType struct{ stoppableType }
+
+func (serverType) Start(model.Run, *model.Component) error { return nil }
+
+func TestCheckRestartable(t *testing.T) {
+	components := testComponents(map[string]int{"us": 2}, "us")
+	require.ErrorContains(t, checkRestartable(components), "no component type")
+
+	components[0].Type = serverType{}
+	components[1].Type = serverType{}
+	require.NoError(t, checkRestartable(components))
+
+	components[1].Type = stoppableType{}
+	require.ErrorContains(t, checkRestartable(components), "component [us-c1] can't be started")
+}
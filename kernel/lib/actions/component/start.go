@@ -31,10 +31,14 @@ func StartInParallel(componentSpec string, concurrency int) model.Action {
 	}
 }
 
+// Execute starts the selected components in dependency order. Components whose dependencies have all been started
+// are started concurrently.
 func (start *start) Execute(run model.Run) error {
-	return run.GetModel().ForEachComponent(start.componentSpec, start.concurrency, func(c *model.Component) error {
-		if startable, ok := c.Type.(model.ServerComponent); ok {
-			return startable.Start(run, c)
+	return run.GetModel().ForEachComponentOrdered(start.componentSpec, start.concurrency, false, func(c *model.Component) error {
+		if _, ok := c.Type.(model.ServerComponent); ok {
+			return model.RecordOutcome(run, c, func(*model.Outcome) error {
+				return c.Start(run)
+			})
 		}
 		return nil
 	})
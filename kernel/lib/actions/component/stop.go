@@ -38,10 +38,14 @@ func StopInParallelHostExclusive(componentSpec string, concurrency int) model.Ac
 	}
 }
 
+// Execute stops the selected components in reverse dependency order, so components are stopped before the
+// components they depend on
 func (stop *stop) Execute(run model.Run) error {
-	return run.GetModel().ForEachComponent(stop.componentSpec, stop.concurrency, func(c *model.Component) error {
+	return run.GetModel().ForEachComponentOrdered(stop.componentSpec, stop.concurrency, true, func(c *model.Component) error {
 		if c.Type != nil {
-			return c.Type.Stop(run, c)
+			return model.RecordOutcome(run, c, func(*model.Outcome) error {
+				return c.Stop(run)
+			})
 		}
 		return nil
 	})
@@ -58,10 +62,12 @@ type stopByHost struct {
 }
 
 func (stop *stopByHost) Execute(run model.Run) error {
-	return run.GetModel().ForEachComponent(stop.componentSpec, stop.concurrency, func(c *model.Component) error {
+	return run.GetModel().ForEachComponentOrdered(stop.componentSpec, stop.concurrency, true, func(c *model.Component) error {
 		return c.Host.DoExclusiveFallible(func() error {
 			if c.Type != nil {
-				return c.Type.Stop(run, c)
+				return model.RecordOutcome(run, c, func(*model.Outcome) error {
+					return c.Stop(run)
+				})
 			}
 			return nil
 		})
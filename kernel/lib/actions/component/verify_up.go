@@ -43,24 +43,33 @@ type verifyUp struct {
 
 func (self *verifyUp) Execute(run model.Run) error {
 	return run.GetModel().ForEachComponent(self.componentSpec, self.concurrency, func(c *model.Component) error {
-		log := pfxlog.Logger().WithField("componentId", c.Id)
-		deadline := time.Now().Add(self.timeout)
+		return model.RecordOutcome(run, c, func(outcome *model.Outcome) error {
+			return waitForRunning(run, c, self.timeout, outcome)
+		})
+	})
+}
 
-		for {
-			running, err := c.IsRunning(run)
-			if err != nil {
-				return err
-			}
-			if running {
-				log.Info("component is running")
-				return nil
-			}
-			if time.Now().After(deadline) {
-				log.Error("timed out waiting for component to be running")
-				return errors.Errorf("timed out waiting for component [%s] to be running", c.Id)
-			}
-			log.Info("component not running yet, waiting")
-			time.Sleep(500 * time.Millisecond)
+// waitForRunning polls the component until it reports it's running or the timeout expires
+func waitForRunning(run model.Run, c *model.Component, timeout time.Duration, outcome *model.Outcome) error {
+	log := pfxlog.Logger().WithField("componentId", c.Id)
+	deadline := time.Now().Add(timeout)
+
+	for checks := 1; ; checks++ {
+		running, err := c.IsRunning(run)
+		if err != nil {
+			return err
 		}
-	})
+		if running {
+			log.Info("component is running")
+			outcome.SetValue("checks", checks)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			log.Error("timed out waiting for component to be running")
+			outcome.SetValue("checks", checks)
+			return errors.Errorf("timed out waiting for component [%s] to be running", c.Id)
+		}
+		log.Info("component not running yet, waiting")
+		time.Sleep(500 * time.Millisecond)
+	}
 }
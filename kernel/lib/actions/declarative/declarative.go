@@ -0,0 +1,237 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package declarative allows actions to be defined in YAML, composed from the built-in action primitives.
+//
+// An actions file has the following form:
+//
+//	actions:
+//	  restart-routers:
+//	    steps:
+//	      - type: component.stop
+//	        selector: .router
+//	        concurrency: 5
+//	      - type: semaphore.sleep
+//	        duration: 5s
+//	      - type: component.start
+//	        selector: .router
+//	        concurrency: 5
+//	      - type: component.verifyUp
+//	        selector: .router
+//	        timeout: 30s
+//	    finally:
+//	      - type: host.groupExec
+//	        selector: "*"
+//	        cmds: ["sync"]
+package declarative
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/openziti/fablab/kernel/lib/actions"
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// InstanceActionsFile is the name of the actions file which is loaded from the instance working directory, if present
+const InstanceActionsFile = "actions.yml"
+
+// ActionsFile is the top level structure of a declarative actions file
+type ActionsFile struct {
+	Actions map[string]*ActionDef `yaml:"actions"`
+}
+
+// ActionDef defines a single action as a sequence of steps, with optional cleanup steps which always run
+type ActionDef struct {
+	Steps   []*StepDef `yaml:"steps"`
+	Finally []*StepDef `yaml:"finally"`
+}
+
+// StepDef defines a single step of an action. Which fields are used depends on the step type
+type StepDef struct {
+	Type           string     `yaml:"type"`
+	Selector       string     `yaml:"selector"`
+	Concurrency    int        `yaml:"concurrency"`
+	Action         string     `yaml:"action"`
+	Timeout        string     `yaml:"timeout"`
+	Duration       string     `yaml:"duration"`
+	Cmds           []string   `yaml:"cmds"`
+	Match          string     `yaml:"match"`
+	Src            string     `yaml:"src"`
+	Dst            string     `yaml:"dst"`
+	Paths          []string   `yaml:"paths"`
+	Attempts       int        `yaml:"attempts"`
+	BatchSize      int        `yaml:"batchSize"`
+	BatchPercent   int        `yaml:"batchPercent"`
+	MaxUnavailable int        `yaml:"maxUnavailable"`
+	Delay          string     `yaml:"delay"`
+	Groups         []string   `yaml:"groups"`
+	OneWay         bool       `yaml:"oneWay"`
+	Steps          []*StepDef `yaml:"steps"`
+}
+
+// Parse parses a declarative actions file
+func Parse(data []byte) (*ActionsFile, error) {
+	result := &ActionsFile{}
+	if err := yaml.UnmarshalStrict(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Build converts the action definitions into model actions
+func (self *ActionsFile) Build() (map[string]model.Action, error) {
+	result := map[string]model.Action{}
+	for name, def := range self.Actions {
+		action, err := def.Build()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid action [%s]", name)
+		}
+		result[name] = action
+	}
+	return result, nil
+}
+
+// Build converts the action definition into a model action
+func (self *ActionDef) Build() (model.Action, error) {
+	if len(self.Steps) == 0 {
+		return nil, errors.New("no steps defined")
+	}
+
+	steps, err := buildSteps(self.Steps)
+	if err != nil {
+		return nil, err
+	}
+
+	finally, err := buildSteps(self.Finally)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid finally steps")
+	}
+
+	return actions.Workflow(steps...).Finally(finally...), nil
+}
+
+func buildSteps(stepDefs []*StepDef) ([]model.Action, error) {
+	var result []model.Action
+	for idx, stepDef := range stepDefs {
+		step, err := BuildStep(stepDef)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid step %d", idx+1)
+		}
+		result = append(result, actions.Named(stepDef.Type, step))
+	}
+	return result, nil
+}
+
+// Register parses the given actions file data and adds the resulting actions to the model. It is an
+// error for a declarative action to have the same name as an action already defined on the model.
+func Register(m *model.Model, source string, data []byte) error {
+	actionsFile, err := Parse(data)
+	if err != nil {
+		return errors.Wrapf(err, "unable to parse actions file [%s]", source)
+	}
+
+	built, err := actionsFile.Build()
+	if err != nil {
+		return errors.Wrapf(err, "invalid actions file [%s]", source)
+	}
+
+	if m.Actions == nil {
+		m.Actions = model.ActionBinders{}
+	}
+
+	var names []string
+	for name := range built {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, found := m.Actions[name]; found {
+			return errors.Errorf("action [%s] from [%s] conflicts with an existing action", name, source)
+		}
+		m.AddAction(name, built[name])
+		logrus.Debugf("registered declarative action [%s] from [%s]", name, source)
+	}
+	return nil
+}
+
+// RegisterFile loads the actions file at the given path and adds the resulting actions to the model
+func RegisterFile(m *model.Model, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read actions file [%s]", path)
+	}
+	return Register(m, path, data)
+}
+
+// RegisterFS loads the actions file with the given name from the file system and adds the resulting actions
+// to the model. This is useful for action files embedded in model resources.
+func RegisterFS(m *model.Model, fsys fs.FS, name string) error {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read actions file [%s]", name)
+	}
+	return Register(m, name, data)
+}
+
+// NewBootstrapExtension returns a bootstrap extension which registers the actions from the given files. It
+// must be registered using model.AddBootstrapExtension, so that it runs before model actions are bound.
+func NewBootstrapExtension(paths ...string) model.BootstrapExtension {
+	return &bootstrapExtension{
+		paths: paths,
+	}
+}
+
+// NewInstanceBootstrapExtension returns a bootstrap extension which registers the actions found in
+// the InstanceActionsFile in the active instance working directory, if that file exists.
+func NewInstanceBootstrapExtension() model.BootstrapExtension {
+	return &bootstrapExtension{
+		instance: true,
+	}
+}
+
+type bootstrapExtension struct {
+	paths    []string
+	instance bool
+}
+
+func (self *bootstrapExtension) Bootstrap(m *model.Model) error {
+	paths := self.paths
+	if self.instance {
+		if instancePath := model.ActiveInstancePath(); instancePath != "" {
+			path := filepath.Join(instancePath, InstanceActionsFile)
+			if _, err := os.Stat(path); err == nil {
+				paths = append(paths, path)
+			} else if !os.IsNotExist(err) {
+				return fmt.Errorf("unable to check for instance actions file [%s] (%w)", path, err)
+			}
+		}
+	}
+
+	for _, path := range paths {
+		if err := RegisterFile(m, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package declarative
+
+import (
+	"testing"
+
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/stretchr/testify/require"
+)
+
+const testActions = `
+actions:
+  restart-routers:
+    steps:
+      - type: component.stop
+        selector: .router
+        concurrency: 5
+      - type: semaphore.sleep
+        duration: 5s
+      - type: retry
+        attempts: 3
+        delay: 1s
+        steps:
+          - type: component.start
+            selector: .router
+          - type: component.verifyUp
+            selector: .router
+            timeout: 30s
+    finally:
+      - type: host.groupExec
+        selector: "*"
+        cmds: ["sync"]
+  chaos:
+    steps:
+      - type: parallel
+        steps:
+          - type: action
+            action: restart-routers
+          - type: host.groupKill
+            selector: .edge
+            match: ziti
+`
+
+func TestParseAndBuild(t *testing.T) {
+	req := require.New(t)
+
+	actionsFile, err := Parse([]byte(testActions))
+	req.NoError(err)
+	req.Len(actionsFile.Actions, 2)
+
+	built, err := actionsFile.Build()
+	req.NoError(err)
+	req.Contains(built, "restart-routers")
+	req.Contains(built, "chaos")
+}
+
+func TestBuildErrors(t *testing.T) {
+	cases := map[string]string{
+		"unknown step type": `
+actions:
+  a:
+    steps:
+      - type: component.explode
+        selector: "*"`,
+		"requires a selector": `
+actions:
+  a:
+    steps:
+      - type: component.start`,
+		"invalid duration": `
+actions:
+  a:
+    steps:
+      - type: semaphore.sleep
+        duration: soon`,
+		"no steps defined": `
+actions:
+  a:
+    finally:
+      - type: semaphore.sleep
+        duration: 1s`,
+	}
+
+	for expected, def := range cases {
+		t.Run(expected, func(t *testing.T) {
+			actionsFile, err := Parse([]byte(def))
+			require.NoError(t, err)
+			_, err = actionsFile.Build()
+			require.ErrorContains(t, err, expected)
+		})
+	}
+}
+
+func TestParseRejectsUnknownFields(t *testing.T) {
+	_, err := Parse([]byte(`
+actions:
+  a:
+    steps:
+      - type: semaphore.sleep
+        duraton: 1s`))
+	require.Error(t, err)
+}
+
+func TestRegisterConflict(t *testing.T) {
+	req := require.New(t)
+
+	m := &model.Model{Actions: model.ActionBinders{}}
+	m.AddActionF("existing", func(model.Run) error { return nil })
+
+	req.NoError(Register(m, "test", []byte(testActions)))
+	req.Contains(m.Actions, "restart-routers")
+
+	err := Register(m, "test", []byte(testActions))
+	req.ErrorContains(err, "conflicts with an existing action")
+}
@@ -0,0 +1,322 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package declarative
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openziti/fablab/kernel/lib/actions"
+	"github.com/openziti/fablab/kernel/lib/actions/component"
+	"github.com/openziti/fablab/kernel/lib/actions/host"
+	"github.com/openziti/fablab/kernel/lib/actions/semaphore"
+	distribution "github.com/openziti/fablab/kernel/lib/runlevel/3_distribution"
+	"github.com/openziti/fablab/kernel/lib/runlevel/3_distribution/rsync"
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/pkg/errors"
+)
+
+// A StepFactory creates an action from a step definition
+type StepFactory func(def *StepDef) (model.Action, error)
+
+var stepTypesLock sync.Mutex
+var stepTypes = map[string]StepFactory{}
+
+func init() {
+	RegisterStepType("component.start", componentStart)
+	RegisterStepType("component.stop", componentStop)
+	RegisterStepType("component.exec", componentExec)
+	RegisterStepType("component.verifyUp", componentVerifyUp)
+	RegisterStepType("component.rollingRestart", componentRollingRestart)
+	RegisterStepType("host.groupExec", hostGroupExec)
+	RegisterStepType("host.groupKill", hostGroupKill)
+	RegisterStepType("host.partition", hostPartition)
+	RegisterStepType("host.heal", hostHeal)
+	RegisterStepType("semaphore.sleep", semaphoreSleep)
+	RegisterStepType("distribution.locations", distributionLocations)
+	RegisterStepType("distribution.rsync", distributionRsync)
+	RegisterStepType("distribution.rsyncStaged", distributionRsyncStaged)
+	RegisterStepType("action", namedModelAction)
+	RegisterStepType("workflow", workflowStep)
+	RegisterStepType("parallel", parallelStep)
+	RegisterStepType("retry", retryStep)
+	RegisterStepType("timeout", timeoutStep)
+}
+
+// RegisterStepType makes a new step type available to declarative actions. Models can use this to expose
+// their own primitives. Registering a type with an existing name replaces the previous factory.
+func RegisterStepType(name string, factory StepFactory) {
+	stepTypesLock.Lock()
+	defer stepTypesLock.Unlock()
+	stepTypes[name] = factory
+}
+
+// StepTypes returns the sorted names of all available step types
+func StepTypes() []string {
+	stepTypesLock.Lock()
+	defer stepTypesLock.Unlock()
+
+	var result []string
+	for name := range stepTypes {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// BuildStep creates an action from the given step definition, using the factory registered for its type
+func BuildStep(def *StepDef) (model.Action, error) {
+	if def == nil {
+		return nil, errors.New("empty step")
+	}
+
+	stepTypesLock.Lock()
+	factory, found := stepTypes[def.Type]
+	stepTypesLock.Unlock()
+
+	if !found {
+		return nil, errors.Errorf("unknown step type [%s], valid types: %v", def.Type, StepTypes())
+	}
+	return factory(def)
+}
+
+func (self *StepDef) requireSelector() error {
+	if self.Selector == "" {
+		return errors.Errorf("step type [%s] requires a selector", self.Type)
+	}
+	return nil
+}
+
+func (self *StepDef) concurrency() int {
+	return max(self.Concurrency, 1)
+}
+
+func (self *StepDef) requireDuration(field, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, errors.Errorf("step type [%s] requires %s", self.Type, field)
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s [%s] for step type [%s]", field, value, self.Type)
+	}
+	return d, nil
+}
+
+func (self *StepDef) optionalDuration(field, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return self.requireDuration(field, value)
+}
+
+func componentStart(def *StepDef) (model.Action, error) {
+	if err := def.requireSelector(); err != nil {
+		return nil, err
+	}
+	return component.StartInParallel(def.Selector, def.concurrency()), nil
+}
+
+func componentStop(def *StepDef) (model.Action, error) {
+	if err := def.requireSelector(); err != nil {
+		return nil, err
+	}
+	return component.StopInParallel(def.Selector, def.concurrency()), nil
+}
+
+func componentExec(def *StepDef) (model.Action, error) {
+	if err := def.requireSelector(); err != nil {
+		return nil, err
+	}
+	if def.Action == "" {
+		return nil, errors.Errorf("step type [%s] requires an action", def.Type)
+	}
+	return component.ExecInParallel(def.Selector, def.concurrency(), def.Action), nil
+}
+
+func componentVerifyUp(def *StepDef) (model.Action, error) {
+	if err := def.requireSelector(); err != nil {
+		return nil, err
+	}
+	timeout, err := def.requireDuration("timeout", def.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return component.VerifyUpInParallel(def.Selector, timeout, def.concurrency()), nil
+}
+
+func componentRollingRestart(def *StepDef) (model.Action, error) {
+	if err := def.requireSelector(); err != nil {
+		return nil, err
+	}
+	timeout, err := def.requireDuration("timeout", def.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	pause, err := def.optionalDuration("delay", def.Delay)
+	if err != nil {
+		return nil, err
+	}
+	return component.RollingRestart(def.Selector, component.RollingRestartConfig{
+		BatchSize:               def.BatchSize,
+		BatchPercent:            def.BatchPercent,
+		MaxUnavailablePerRegion: def.MaxUnavailable,
+		HealthTimeout:           timeout,
+		Pause:                   pause,
+	}), nil
+}
+
+func hostGroupExec(def *StepDef) (model.Action, error) {
+	if err := def.requireSelector(); err != nil {
+		return nil, err
+	}
+	if len(def.Cmds) == 0 {
+		return nil, errors.Errorf("step type [%s] requires cmds", def.Type)
+	}
+	return host.GroupExec(def.Selector, def.concurrency(), def.Cmds...), nil
+}
+
+func hostGroupKill(def *StepDef) (model.Action, error) {
+	if err := def.requireSelector(); err != nil {
+		return nil, err
+	}
+	if def.Match == "" {
+		return nil, errors.Errorf("step type [%s] requires match", def.Type)
+	}
+	return host.GroupKill(def.Selector, def.Match), nil
+}
+
+func hostPartition(def *StepDef) (model.Action, error) {
+	if err := def.requirePartitionGroups(); err != nil {
+		return nil, err
+	}
+	if def.OneWay {
+		return host.PartitionOneWay(def.Groups[0], def.Groups[1]), nil
+	}
+	return host.Partition(def.Groups...), nil
+}
+
+func hostHeal(def *StepDef) (model.Action, error) {
+	if err := def.requirePartitionGroups(); err != nil {
+		return nil, err
+	}
+	if def.OneWay {
+		return host.HealPartitionOneWay(def.Groups[0], def.Groups[1]), nil
+	}
+	return host.HealPartition(def.Groups...), nil
+}
+
+func (self *StepDef) requirePartitionGroups() error {
+	if len(self.Groups) == 0 {
+		return errors.Errorf("step type [%s] requires groups", self.Type)
+	}
+	if self.OneWay && len(self.Groups) != 2 {
+		return errors.Errorf("step type [%s] requires exactly two groups when oneWay is set", self.Type)
+	}
+	return nil
+}
+
+func semaphoreSleep(def *StepDef) (model.Action, error) {
+	duration, err := def.requireDuration("duration", def.Duration)
+	if err != nil {
+		return nil, err
+	}
+	return semaphore.Sleep(duration), nil
+}
+
+func distributionLocations(def *StepDef) (model.Action, error) {
+	if err := def.requireSelector(); err != nil {
+		return nil, err
+	}
+	if len(def.Paths) == 0 {
+		return nil, errors.Errorf("step type [%s] requires paths", def.Type)
+	}
+	return distribution.Locations(def.Selector, def.Paths...), nil
+}
+
+func distributionRsync(def *StepDef) (model.Action, error) {
+	if err := def.requireSelector(); err != nil {
+		return nil, err
+	}
+	if def.Src == "" || def.Dst == "" {
+		return nil, errors.Errorf("step type [%s] requires src and dst", def.Type)
+	}
+	return rsync.RsyncSelected(def.Selector, def.Src, def.Dst), nil
+}
+
+func distributionRsyncStaged(*StepDef) (model.Action, error) {
+	return rsync.RsyncStaged(), nil
+}
+
+func namedModelAction(def *StepDef) (model.Action, error) {
+	if def.Action == "" {
+		return nil, errors.Errorf("step type [%s] requires an action", def.Type)
+	}
+	return model.ActionFunc(func(run model.Run) error {
+		return run.GetModel().ExecuteAction(def.Action).Execute(run)
+	}), nil
+}
+
+func workflowStep(def *StepDef) (model.Action, error) {
+	steps, err := buildNestedSteps(def)
+	if err != nil {
+		return nil, err
+	}
+	return actions.Workflow(steps...), nil
+}
+
+func parallelStep(def *StepDef) (model.Action, error) {
+	steps, err := buildNestedSteps(def)
+	if err != nil {
+		return nil, err
+	}
+	if def.Concurrency > 0 {
+		return actions.ParallelN(def.Concurrency, steps...), nil
+	}
+	return actions.Parallel(steps...), nil
+}
+
+func retryStep(def *StepDef) (model.Action, error) {
+	steps, err := buildNestedSteps(def)
+	if err != nil {
+		return nil, err
+	}
+	delay, err := def.optionalDuration("delay", def.Delay)
+	if err != nil {
+		return nil, err
+	}
+	return actions.RetryN(def.Attempts, delay, actions.Workflow(steps...)), nil
+}
+
+func timeoutStep(def *StepDef) (model.Action, error) {
+	steps, err := buildNestedSteps(def)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := def.requireDuration("timeout", def.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return actions.Timeout(timeout, actions.Workflow(steps...)), nil
+}
+
+func buildNestedSteps(def *StepDef) ([]model.Action, error) {
+	if len(def.Steps) == 0 {
+		return nil, errors.Errorf("step type [%s] requires nested steps", def.Type)
+	}
+	return buildSteps(def.Steps)
+}
@@ -0,0 +1,63 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package host
+
+import (
+	"github.com/openziti/fablab/kernel/model"
+)
+
+// Block drops traffic matching the given rules on the selected hosts
+func Block(hostSpec string, rules ...model.NetworkRule) model.Action {
+	return &block{
+		hostSpec: hostSpec,
+		rules:    rules,
+	}
+}
+
+func (self *block) Execute(run model.Run) error {
+	return run.GetModel().ForEachHost(self.hostSpec, 10, func(h *model.Host) error {
+		return model.RecordOutcome(run, h, func(*model.Outcome) error {
+			return h.BlockRules(self.rules...)
+		})
+	})
+}
+
+type block struct {
+	hostSpec string
+	rules    []model.NetworkRule
+}
+
+// Unblock removes rules previously added using Block from the selected hosts
+func Unblock(hostSpec string, rules ...model.NetworkRule) model.Action {
+	return &unblock{
+		hostSpec: hostSpec,
+		rules:    rules,
+	}
+}
+
+func (self *unblock) Execute(run model.Run) error {
+	return run.GetModel().ForEachHost(self.hostSpec, 10, func(h *model.Host) error {
+		return model.RecordOutcome(run, h, func(*model.Outcome) error {
+			return h.UnblockRules(self.rules...)
+		})
+	})
+}
+
+type unblock struct {
+	hostSpec string
+	rules    []model.NetworkRule
+}
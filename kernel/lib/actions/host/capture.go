@@ -0,0 +1,66 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package host
+
+import (
+	"path/filepath"
+
+	"github.com/openziti/fablab/kernel/libssh"
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/pkg/errors"
+)
+
+// Capture retrieves the given files and directories from the selected hosts into the forensics directory of
+// the run, under AllocateForensicScenario(runId, scenario)/<host>. The scenario is taken from the scenario
+// argument, which exec-loop sets to iteration-N when it runs a capture action after a failed iteration.
+func Capture(hostSpec string, paths ...string) model.ParameterizedAction {
+	return &capture{
+		hostSpec: hostSpec,
+		paths:    paths,
+	}
+}
+
+func (self *capture) GetParams() []*model.ActionParam {
+	return []*model.ActionParam{
+		model.StringParam("scenario", "capture", "name of the forensics directory to capture files into"),
+	}
+}
+
+func (self *capture) Execute(run model.Run) error {
+	scenario := model.GetArgs(run).GetString("scenario")
+	if scenario == "" {
+		scenario = "capture"
+	}
+	return run.GetModel().ForEachHost(self.hostSpec, 10, func(h *model.Host) error {
+		return model.RecordOutcome(run, h, func(*model.Outcome) error {
+			localPath := filepath.Join(model.AllocateForensicScenario(run.GetId(), scenario), model.GetEntityPathId(h))
+			factory, err := h.NewSshConfigFactory()
+			if err != nil {
+				return err
+			}
+			if err = libssh.RetrieveRemoteFiles(factory, localPath, self.paths...); err != nil {
+				return errors.Wrapf(err, "unable to capture files from [%s]", h.PublicIp)
+			}
+			return nil
+		})
+	})
+}
+
+type capture struct {
+	hostSpec string
+	paths    []string
+}
@@ -30,14 +30,19 @@ func Exec(h *model.Host, cmds ...string) model.Action {
 	}
 }
 
-func (exec *exec) Execute(model.Run) error {
-	sshConfigFactory := exec.h.NewSshConfigFactory()
-
-	if o, err := libssh.RemoteExecAll(sshConfigFactory, exec.cmds...); err != nil {
-		logrus.Errorf("output [%s]", o)
-		return fmt.Errorf("error executing process on [%s] (%s)", exec.h.PublicIp, err)
-	}
-	return nil
+func (exec *exec) Execute(run model.Run) error {
+	return model.RecordOutcome(run, exec.h, func(*model.Outcome) error {
+		sshConfigFactory, err := exec.h.NewSshConfigFactory()
+		if err != nil {
+			return err
+		}
+
+		if o, err := libssh.RemoteExecAll(sshConfigFactory, exec.cmds...); err != nil {
+			logrus.Errorf("output [%s]", o)
+			return fmt.Errorf("error executing process on [%s] (%s)", exec.h.PublicIp, err)
+		}
+		return nil
+	})
 }
 
 type exec struct {
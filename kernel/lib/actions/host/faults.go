@@ -0,0 +1,94 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package host
+
+import (
+	"time"
+
+	"github.com/openziti/fablab/kernel/model"
+)
+
+// The actions in this file inject faults which are reverted on the host once their duration has passed, so
+// they return as soon as the fault is in place. See model.HostFault for details.
+
+// CpuBurn keeps the given number of cores busy on the selected hosts for the duration
+func CpuBurn(hostSpec string, cores int, duration time.Duration) model.Action {
+	return &hostFault{
+		hostSpec: hostSpec,
+		apply: func(h *model.Host) error {
+			return h.BurnCpu(cores, duration)
+		},
+	}
+}
+
+// MemoryPressure allocates the given number of megabytes on the selected hosts for the duration
+func MemoryPressure(hostSpec string, megabytes int, duration time.Duration) model.Action {
+	return &hostFault{
+		hostSpec: hostSpec,
+		apply: func(h *model.Host) error {
+			return h.ApplyMemoryPressure(megabytes, duration)
+		},
+	}
+}
+
+// DiskFill fills the file system containing path on the selected hosts to the given percentage for the duration
+func DiskFill(hostSpec string, path string, percent int, duration time.Duration) model.Action {
+	return &hostFault{
+		hostSpec: hostSpec,
+		apply: func(h *model.Host) error {
+			return h.FillDisk(path, percent, duration)
+		},
+	}
+}
+
+// ClockOffset shifts the clock of the selected hosts by the offset for the duration
+func ClockOffset(hostSpec string, offset time.Duration, duration time.Duration) model.Action {
+	return &hostFault{
+		hostSpec: hostSpec,
+		apply: func(h *model.Host) error {
+			return h.OffsetClock(offset, duration)
+		},
+	}
+}
+
+// RevertFaults reverts active faults of the given kinds on the selected hosts before their duration has passed
+func RevertFaults(hostSpec string, kinds ...model.HostFault) model.Action {
+	return &hostFault{
+		hostSpec: hostSpec,
+		apply: func(h *model.Host) error {
+			for _, kind := range kinds {
+				if err := h.RevertFaults(kind); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func (self *hostFault) Execute(run model.Run) error {
+	return run.GetModel().ForEachHost(self.hostSpec, 10, func(h *model.Host) error {
+		return model.RecordOutcome(run, h, func(*model.Outcome) error {
+			return self.apply(h)
+		})
+	})
+}
+
+type hostFault struct {
+	hostSpec string
+	apply    func(h *model.Host) error
+}
@@ -33,13 +33,18 @@ func GroupExec(hostSpec string, concurrency int, cmds ...string) model.Action {
 
 func (groupExec *groupExec) Execute(run model.Run) error {
 	return run.GetModel().ForEachHost(groupExec.hostSpec, groupExec.concurrency, func(h *model.Host) error {
-		sshConfigFactory := h.NewSshConfigFactory()
-
-		if o, err := libssh.RemoteExecAll(sshConfigFactory, groupExec.cmds...); err != nil {
-			logrus.Errorf("output [%s]", o)
-			return fmt.Errorf("error executing process on [%s] (%s)", h.PublicIp, err)
-		}
-		return nil
+		return model.RecordOutcome(run, h, func(*model.Outcome) error {
+			sshConfigFactory, err := h.NewSshConfigFactory()
+			if err != nil {
+				return err
+			}
+
+			if o, err := libssh.RemoteExecAll(sshConfigFactory, groupExec.cmds...); err != nil {
+				logrus.Errorf("output [%s]", o)
+				return fmt.Errorf("error executing process on [%s] (%s)", h.PublicIp, err)
+			}
+			return nil
+		})
 	})
 }
 
@@ -31,10 +31,18 @@ func GroupKill(hostSpec, match string) model.Action {
 
 func (groupKill *groupKill) Execute(run model.Run) error {
 	for _, h := range run.GetModel().SelectHosts(groupKill.hostSpec) {
-
-		sshConfigFactory := h.NewSshConfigFactory()
-		if err := libssh.RemoteKill(sshConfigFactory, groupKill.match); err != nil {
-			return fmt.Errorf("error killing [%s] on [%s] (%s)", groupKill.match, h.PublicIp, err)
+		err := model.RecordOutcome(run, h, func(*model.Outcome) error {
+			sshConfigFactory, err := h.NewSshConfigFactory()
+			if err != nil {
+				return err
+			}
+			if err := libssh.RemoteKill(sshConfigFactory, groupKill.match); err != nil {
+				return fmt.Errorf("error killing [%s] on [%s] (%s)", groupKill.match, h.PublicIp, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 	}
 	return nil
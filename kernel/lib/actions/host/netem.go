@@ -0,0 +1,94 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package host
+
+import (
+	"github.com/openziti/fablab/kernel/model"
+)
+
+// Netem applies the netem config to outgoing traffic matching the target on the selected hosts
+func Netem(hostSpec string, target model.NetemTarget, config *model.NetemConfig) model.Action {
+	return &netem{
+		hostSpec: hostSpec,
+		config:   config,
+		targets: func(*model.Host) []model.NetemTarget {
+			return []model.NetemTarget{target}
+		},
+	}
+}
+
+// NetemBetween applies the netem config to traffic from the selected hosts to the peer hosts. This can be
+// used to emulate a WAN link, for example by selecting the hosts of two regions. Traffic to both the public
+// and private addresses of the peers is affected.
+func NetemBetween(hostSpec, peerSpec string, config *model.NetemConfig) model.Action {
+	return &netem{
+		hostSpec: hostSpec,
+		config:   config,
+		targets: func(h *model.Host) []model.NetemTarget {
+			var result []model.NetemTarget
+			for _, peer := range h.GetModel().SelectHosts(peerSpec) {
+				if peer == h {
+					continue
+				}
+				for _, ip := range []string{peer.PublicIp, peer.PrivateIp} {
+					if ip != "" {
+						result = append(result, model.NetemToIp(ip))
+					}
+				}
+			}
+			return result
+		},
+	}
+}
+
+func (self *netem) Execute(run model.Run) error {
+	return run.GetModel().ForEachHost(self.hostSpec, 10, func(h *model.Host) error {
+		return model.RecordOutcome(run, h, func(*model.Outcome) error {
+			for _, target := range self.targets(h) {
+				if err := h.AddNetem(target, self.config); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+type netem struct {
+	hostSpec string
+	config   *model.NetemConfig
+	targets  func(h *model.Host) []model.NetemTarget
+}
+
+// ClearNetem removes all netem rules from the selected hosts
+func ClearNetem(hostSpec string) model.Action {
+	return &clearNetem{
+		hostSpec: hostSpec,
+	}
+}
+
+func (self *clearNetem) Execute(run model.Run) error {
+	return run.GetModel().ForEachHost(self.hostSpec, 10, func(h *model.Host) error {
+		return model.RecordOutcome(run, h, func(*model.Outcome) error {
+			return h.ClearAllNetem()
+		})
+	})
+}
+
+type clearNetem struct {
+	hostSpec string
+}
@@ -0,0 +1,187 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package host
+
+import (
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/pkg/errors"
+)
+
+// Partition blocks all traffic between hosts in different groups, using both the public and private
+// addresses of the hosts. Each group is a host selector. If a single group is given, it is partitioned
+// from all other hosts in the model. Traffic within a group is unaffected.
+func Partition(groups ...string) model.Action {
+	return &partition{groups: groups}
+}
+
+// PartitionOneWay blocks connections from the hosts selected by fromSpec to the hosts selected by toSpec,
+// creating an asymmetric partition. Existing connections in that direction stop passing traffic. Connections
+// from toSpec to fromSpec are unaffected, including their replies, as the rules only match packets sent by the
+// side which opened the connection.
+func PartitionOneWay(fromSpec, toSpec string) model.Action {
+	return &partition{groups: []string{fromSpec, toSpec}, oneWay: true}
+}
+
+// HealPartition removes exactly the rules installed by Partition with the same groups
+func HealPartition(groups ...string) model.Action {
+	return &partition{groups: groups, heal: true}
+}
+
+// HealPartitionOneWay removes exactly the rules installed by PartitionOneWay with the same selectors
+func HealPartitionOneWay(fromSpec, toSpec string) model.Action {
+	return &partition{groups: []string{fromSpec, toSpec}, oneWay: true, heal: true}
+}
+
+type partition struct {
+	groups []string
+	oneWay bool
+	heal   bool
+}
+
+func (self *partition) Execute(run model.Run) error {
+	m := run.GetModel()
+
+	groups, err := selectPartitionGroups(m, self.groups)
+	if err != nil {
+		return err
+	}
+
+	blocks, err := planPartition(groups, self.oneWay)
+	if err != nil {
+		return err
+	}
+
+	rules := 0
+	for _, ips := range blocks {
+		rules += len(ips)
+	}
+
+	log := pfxlog.Logger().WithField("groups", self.groups)
+	if self.heal {
+		log.Infof("healing partition, removing %d rules from %d hosts", rules, len(blocks))
+	} else {
+		log.Infof("partitioning, adding %d rules to %d hosts", rules, len(blocks))
+	}
+
+	return m.ForEachHost("*", 25, func(h *model.Host) error {
+		ips, found := blocks[h]
+		if !found {
+			return nil
+		}
+		return model.RecordOutcome(run, h, func(outcome *model.Outcome) error {
+			outcome.SetValue("rules", len(ips))
+			rules := partitionRules(ips, self.oneWay)
+			if self.heal {
+				return h.UnblockRules(rules...)
+			}
+			return h.BlockRules(rules...)
+		})
+	})
+}
+
+// partitionRules returns the rules blocking outgoing traffic to each of the addresses. One-way rules only match
+// connections opened by the host, so replies to connections opened by the peers still pass.
+func partitionRules(ips []string, oneWay bool) []model.NetworkRule {
+	var result []model.NetworkRule
+	for _, ip := range ips {
+		rule := model.OutgoingRule(model.ProtocolAll, ip, model.PortRange{})
+		rule.OriginalOnly = oneWay
+		result = append(result, rule)
+	}
+	return result
+}
+
+func selectPartitionGroups(m *model.Model, specs []string) ([][]*model.Host, error) {
+	if len(specs) == 0 {
+		return nil, errors.New("partition requires at least one group")
+	}
+
+	var groups [][]*model.Host
+	for _, spec := range specs {
+		hosts := m.SelectHosts(spec)
+		if len(hosts) == 0 {
+			return nil, errors.Errorf("partition group [%s] doesn't match any hosts", spec)
+		}
+		groups = append(groups, hosts)
+	}
+
+	if len(groups) == 1 {
+		selected := map[*model.Host]struct{}{}
+		for _, h := range groups[0] {
+			selected[h] = struct{}{}
+		}
+		var rest []*model.Host
+		for _, h := range m.SelectHosts("*") {
+			if _, found := selected[h]; !found {
+				rest = append(rest, h)
+			}
+		}
+		if len(rest) == 0 {
+			return nil, errors.Errorf("partition group [%s] contains all hosts", specs[0])
+		}
+		groups = append(groups, rest)
+	}
+
+	return groups, nil
+}
+
+// planPartition returns the addresses each host must block outgoing traffic to. For a two-way partition,
+// every host blocks every host in the other groups. For a one-way partition, only hosts in the first group
+// block hosts in the second.
+func planPartition(groups [][]*model.Host, oneWay bool) (map[*model.Host][]string, error) {
+	if len(groups) < 2 {
+		return nil, errors.New("partition requires at least two groups")
+	}
+	if oneWay && len(groups) != 2 {
+		return nil, errors.New("one-way partition requires exactly two groups")
+	}
+
+	groupOf := map[*model.Host]int{}
+	for idx, group := range groups {
+		for _, h := range group {
+			if prev, found := groupOf[h]; found && prev != idx {
+				return nil, errors.Errorf("host [%s] is in more than one partition group", h.GetId())
+			}
+			groupOf[h] = idx
+		}
+	}
+
+	result := map[*model.Host][]string{}
+	for idx, group := range groups {
+		if oneWay && idx > 0 {
+			break
+		}
+		for _, h := range group {
+			seen := map[string]struct{}{}
+			for peerIdx, peers := range groups {
+				if peerIdx == idx || (oneWay && peerIdx != 1) {
+					continue
+				}
+				for _, peer := range peers {
+					for _, ip := range []string{peer.PublicIp, peer.PrivateIp} {
+						if _, found := seen[ip]; ip != "" && !found {
+							seen[ip] = struct{}{}
+							result[h] = append(result[h], ip)
+						}
+					}
+				}
+			}
+		}
+	}
+	return result, nil
+}
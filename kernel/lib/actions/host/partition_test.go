@@ -0,0 +1,80 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package host
+
+import (
+	"testing"
+
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanPartition(t *testing.T) {
+	req := require.New(t)
+
+	eu1 := &model.Host{Id: "eu1", PublicIp: "1.1.1.1", PrivateIp: "10.0.0.1"}
+	eu2 := &model.Host{Id: "eu2", PublicIp: "1.1.1.2", PrivateIp: "10.0.0.2"}
+	us1 := &model.Host{Id: "us1", PublicIp: "2.2.2.1", PrivateIp: "10.1.0.1"}
+	ap1 := &model.Host{Id: "ap1", PublicIp: "3.3.3.1"}
+
+	blocks, err := planPartition([][]*model.Host{{eu1, eu2}, {us1}, {ap1}}, false)
+	req.NoError(err)
+	req.Len(blocks, 4)
+	req.Equal([]string{"2.2.2.1", "10.1.0.1", "3.3.3.1"}, blocks[eu1])
+	req.Equal([]string{"2.2.2.1", "10.1.0.1", "3.3.3.1"}, blocks[eu2])
+	req.Equal([]string{"1.1.1.1", "10.0.0.1", "1.1.1.2", "10.0.0.2", "3.3.3.1"}, blocks[us1])
+	req.Equal([]string{"1.1.1.1", "10.0.0.1", "1.1.1.2", "10.0.0.2", "2.2.2.1", "10.1.0.1"}, blocks[ap1])
+
+	blocks, err = planPartition([][]*model.Host{{eu1, eu2}, {us1}}, true)
+	req.NoError(err)
+	req.Len(blocks, 2)
+	req.Equal([]string{"2.2.2.1", "10.1.0.1"}, blocks[eu1])
+	req.NotContains(blocks, us1)
+}
+
+func TestPlanPartitionValidation(t *testing.T) {
+	req := require.New(t)
+
+	eu1 := &model.Host{Id: "eu1", PublicIp: "1.1.1.1"}
+	us1 := &model.Host{Id: "us1", PublicIp: "2.2.2.1"}
+	ap1 := &model.Host{Id: "ap1", PublicIp: "3.3.3.1"}
+
+	_, err := planPartition([][]*model.Host{{eu1}}, false)
+	req.Error(err)
+
+	_, err = planPartition([][]*model.Host{{eu1}, {us1}, {ap1}}, true)
+	req.ErrorContains(err, "exactly two groups")
+
+	_, err = planPartition([][]*model.Host{{eu1, us1}, {us1}}, false)
+	req.ErrorContains(err, "more than one partition group")
+}
+
+func TestPartitionRules(t *testing.T) {
+	req := require.New(t)
+
+	rules := partitionRules([]string{"10.0.0.1", "10.0.0.2"}, false)
+	req.Equal([]model.NetworkRule{
+		model.OutgoingRule(model.ProtocolAll, "10.0.0.1", model.PortRange{}),
+		model.OutgoingRule(model.ProtocolAll, "10.0.0.2", model.PortRange{}),
+	}, rules)
+
+	// one-way rules only block connections opened by the host, so the peer can still connect to it
+	for _, rule := range partitionRules([]string{"10.0.0.1"}, true) {
+		req.True(rule.OriginalOnly)
+		req.Equal("10.0.0.1", rule.Ip)
+	}
+}
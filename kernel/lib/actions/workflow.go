@@ -17,29 +17,64 @@
 package actions
 
 import (
+	"errors"
 	"fmt"
+
 	"github.com/openziti/fablab/kernel/model"
 )
 
+// Workflow returns an action which executes the given actions sequentially, stopping at the first failure.
+// Actions registered with Finally are always executed once the sequential steps are complete, whether or
+// not one of the steps failed.
 func Workflow(actions ...model.Action) *workflow {
-	return &workflow{
-		actions: actions,
+	result := &workflow{}
+	for _, action := range actions {
+		result.AddAction(action)
 	}
+	return result
 }
 
 func (workflow *workflow) AddAction(action model.Action) {
-	workflow.actions = append(workflow.actions, action)
+	workflow.steps = append(workflow.steps, action)
+}
+
+// AddNamedAction adds a step to the workflow with the given name, which will be used in error reporting
+func (workflow *workflow) AddNamedAction(name string, action model.Action) {
+	workflow.AddAction(Named(name, action))
+}
+
+// Finally adds cleanup actions which will always be executed after the workflow steps, even if a step fails
+func (workflow *workflow) Finally(actions ...model.Action) *workflow {
+	workflow.finally = append(workflow.finally, actions...)
+	return workflow
 }
 
 func (workflow *workflow) Execute(run model.Run) error {
-	for _, action := range workflow.actions {
-		if err := action.Execute(run); err != nil {
-			return fmt.Errorf("error executing action (%s)", err)
+	var err error
+	for idx, action := range workflow.steps {
+		if stepErr := action.Execute(run); stepErr != nil {
+			err = fmt.Errorf("error executing workflow step %d [%s] (%w)", idx+1, ActionName(action), stepErr)
+			break
 		}
 	}
-	return nil
+	return runFinally(run, err, workflow.finally)
 }
 
 type workflow struct {
-	actions []model.Action
+	steps   []model.Action
+	finally []model.Action
+}
+
+// runFinally executes all the given cleanup actions, combining any errors with the original error
+func runFinally(run model.Run, err error, finally []model.Action) error {
+	var errs []error
+	for idx, action := range finally {
+		if finallyErr := action.Execute(run); finallyErr != nil {
+			errs = append(errs, fmt.Errorf("error executing finally step %d [%s] (%w)", idx+1, ActionName(action), finallyErr))
+		}
+	}
+	if len(errs) == 0 {
+		return err
+	}
+	return errors.Join(append([]error{err}, errs...)...)
 }
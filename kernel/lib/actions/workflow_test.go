@@ -0,0 +1,103 @@
+package actions
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/stretchr/testify/require"
+)
+
+var errTest = errors.New("test failure")
+
+func countingAction(counter *atomic.Int32, failUntil int32) model.Action {
+	return model.ActionFunc(func(model.Run) error {
+		if counter.Add(1) <= failUntil {
+			return errTest
+		}
+		return nil
+	})
+}
+
+func Test_Workflow_WrapsStepError(t *testing.T) {
+	req := require.New(t)
+
+	var first, second atomic.Int32
+	w := Workflow(countingAction(&first, 0))
+	w.AddNamedAction("failing", countingAction(&second, 1))
+
+	err := w.Execute(nil)
+	req.Error(err)
+	req.ErrorIs(err, errTest)
+	req.Contains(err.Error(), "step 2 [failing]")
+}
+
+func Test_Workflow_FinallyAlwaysRuns(t *testing.T) {
+	req := require.New(t)
+
+	var step, cleanup atomic.Int32
+	err := Workflow(countingAction(&step, 1)).Finally(countingAction(&cleanup, 0)).Execute(nil)
+	req.ErrorIs(err, errTest)
+	req.Equal(int32(1), cleanup.Load())
+
+	err = Workflow(countingAction(&step, 0)).Finally(countingAction(&cleanup, 0)).Execute(nil)
+	req.NoError(err)
+	req.Equal(int32(2), cleanup.Load())
+}
+
+func Test_Parallel_ReportsAllErrors(t *testing.T) {
+	req := require.New(t)
+
+	var a, b, c atomic.Int32
+	err := Parallel(countingAction(&a, 1), countingAction(&b, 0), countingAction(&c, 1)).Execute(nil)
+	req.ErrorIs(err, errTest)
+	req.Equal(int32(1), a.Load())
+	req.Equal(int32(1), b.Load())
+	req.Equal(int32(1), c.Load())
+}
+
+func Test_Retry(t *testing.T) {
+	req := require.New(t)
+
+	var counter atomic.Int32
+	req.NoError(RetryN(3, time.Millisecond, countingAction(&counter, 2)).Execute(nil))
+	req.Equal(int32(3), counter.Load())
+
+	counter.Store(0)
+	err := RetryN(2, time.Millisecond, countingAction(&counter, 5)).Execute(nil)
+	req.ErrorIs(err, errTest)
+	req.Equal(int32(2), counter.Load())
+
+	counter.Store(0)
+	policy := RetryPolicy{MaxAttempts: 5, RetryIf: func(err error) bool { return false }}
+	req.ErrorIs(Retry(policy, countingAction(&counter, 5)).Execute(nil), errTest)
+	req.Equal(int32(1), counter.Load())
+}
+
+func Test_Timeout(t *testing.T) {
+	req := require.New(t)
+
+	slow := model.ActionFunc(func(model.Run) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	req.ErrorIs(Timeout(10*time.Millisecond, slow).Execute(nil), ErrTimedOut)
+
+	var counter atomic.Int32
+	req.NoError(Timeout(time.Second, countingAction(&counter, 0)).Execute(nil))
+}
+
+func Test_IfElse(t *testing.T) {
+	req := require.New(t)
+
+	yes := func(model.Run) bool { return true }
+
+	var ifCount, elseCount atomic.Int32
+	req.NoError(IfElse(yes, countingAction(&ifCount, 0), countingAction(&elseCount, 0)).Execute(nil))
+	req.NoError(IfElse(Not(yes), countingAction(&ifCount, 0), countingAction(&elseCount, 0)).Execute(nil))
+	req.NoError(If(Not(yes), countingAction(&ifCount, 0)).Execute(nil))
+	req.Equal(int32(1), ifCount.Load())
+	req.Equal(int32(1), elseCount.Load())
+}
@@ -0,0 +1,323 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package execstats records the duration and result of each action executed by exec-loop, writing every
+// sample to a file as it happens, and summarizing the samples per action.
+package execstats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/pkg/errors"
+)
+
+// IterationAction is the action name used for samples covering a whole iteration
+const IterationAction = "iteration"
+
+type Format string
+
+const (
+	FormatCsv  Format = "csv"
+	FormatJson Format = "json"
+)
+
+func ParseFormat(v string) (Format, error) {
+	switch Format(v) {
+	case FormatCsv, FormatJson:
+		return Format(v), nil
+	}
+	return "", errors.Errorf("invalid metrics format [%s], must be csv or json", v)
+}
+
+// A Sample is the result of executing one action in one iteration. Worker is set when exec-loop runs
+// concurrent workers, each with its own iterations.
+type Sample struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Worker    string        `json:"worker,omitempty"`
+	Iteration int           `json:"iteration"`
+	Action    string        `json:"action"`
+	Duration  time.Duration `json:"-"`
+	Err       error         `json:"-"`
+}
+
+func (self *Sample) Failed() bool {
+	return self.Err != nil
+}
+
+// key identifies the samples which are summarized together
+func (self *Sample) key() string {
+	if self.Worker == "" {
+		return self.Action
+	}
+	return self.Worker + "/" + self.Action
+}
+
+// Summary describes the samples recorded for one action. For concurrent workers, the action is prefixed with
+// the worker name, as in worker/action.
+type Summary struct {
+	Action   string
+	Count    int
+	Failures int
+	Min      time.Duration
+	Mean     time.Duration
+	P50      time.Duration
+	P95      time.Duration
+	Max      time.Duration
+}
+
+// Recorder writes samples to a file, one line per sample, and keeps the durations for the summary. Samples are
+// written as CSV or as JSON lines. The file is synced after each sample, so it's complete if the process dies.
+type Recorder struct {
+	lock      sync.Mutex
+	path      string
+	format    Format
+	file      *os.File
+	csv       *csv.Writer
+	order     []string
+	durations map[string][]time.Duration
+	failures  map[string]int
+}
+
+// NewRecorder creates the file at path, creating parent directories as needed
+func NewRecorder(path string, format Format) (*Recorder, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, errors.Wrapf(err, "unable to create metrics directory for [%s]", path)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create metrics file [%s]", path)
+	}
+
+	result := &Recorder{
+		path:      path,
+		format:    format,
+		file:      file,
+		durations: map[string][]time.Duration{},
+		failures:  map[string]int{},
+	}
+
+	if format == FormatCsv {
+		result.csv = csv.NewWriter(file)
+		if err = result.csv.Write([]string{"timestamp", "worker", "iteration", "action", "duration_ms", "result", "error"}); err != nil {
+			_ = file.Close()
+			return nil, errors.Wrapf(err, "unable to write metrics file [%s]", path)
+		}
+		result.csv.Flush()
+	}
+	return result, nil
+}
+
+func (self *Recorder) GetPath() string {
+	return self.path
+}
+
+// Record adds the sample to the summary and writes it to the file
+func (self *Recorder) Record(sample *Sample) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	key := sample.key()
+	if _, found := self.durations[key]; !found {
+		self.order = append(self.order, key)
+	}
+	self.durations[key] = append(self.durations[key], sample.Duration)
+	if sample.Failed() {
+		self.failures[key]++
+	}
+
+	if self.file == nil {
+		return nil
+	}
+
+	if err := self.write(sample); err != nil {
+		return errors.Wrapf(err, "unable to write metrics file [%s]", self.path)
+	}
+	return self.file.Sync()
+}
+
+func (self *Recorder) write(sample *Sample) error {
+	result, errMsg := "ok", ""
+	if sample.Failed() {
+		result, errMsg = "failed", sample.Err.Error()
+	}
+
+	if self.format == FormatJson {
+		data, err := json.Marshal(struct {
+			*Sample
+			DurationMs float64 `json:"durationMs"`
+			Result     string  `json:"result"`
+			Error      string  `json:"error,omitempty"`
+		}{sample, durationMs(sample.Duration), result, errMsg})
+		if err != nil {
+			return err
+		}
+		_, err = self.file.Write(append(data, '\n'))
+		return err
+	}
+
+	if err := self.csv.Write([]string{
+		sample.Timestamp.Format(time.RFC3339Nano),
+		sample.Worker,
+		strconv.Itoa(sample.Iteration),
+		sample.Action,
+		strconv.FormatFloat(durationMs(sample.Duration), 'f', 3, 64),
+		result,
+		errMsg,
+	}); err != nil {
+		return err
+	}
+	self.csv.Flush()
+	return self.csv.Error()
+}
+
+// Close closes the file. The summary remains available.
+func (self *Recorder) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.file == nil {
+		return nil
+	}
+	err := self.file.Close()
+	self.file = nil
+	return err
+}
+
+// Summarize returns a summary for each action, in the order the actions were first recorded
+func (self *Recorder) Summarize() []*Summary {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var result []*Summary
+	for _, action := range self.order {
+		summary := summarize(self.durations[action])
+		summary.Action = action
+		summary.Failures = self.failures[action]
+		result = append(result, summary)
+	}
+	return result
+}
+
+// WriteSummary writes the summary as a table
+func (self *Recorder) WriteSummary(w io.Writer) error {
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.AppendHeader(table.Row{"Action", "Count", "Failures", "Min", "Mean", "P50", "P95", "Max"})
+	for _, s := range self.Summarize() {
+		t.AppendRow(table.Row{s.Action, s.Count, s.Failures, fmtDuration(s.Min), fmtDuration(s.Mean),
+			fmtDuration(s.P50), fmtDuration(s.P95), fmtDuration(s.Max)})
+	}
+	_, err := fmt.Fprintln(w, t.Render())
+	return err
+}
+
+func summarize(durations []time.Duration) *Summary {
+	result := &Summary{Count: len(durations)}
+	if len(durations) == 0 {
+		return result
+	}
+
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+
+	result.Min = sorted[0]
+	result.Max = sorted[len(sorted)-1]
+	result.Mean = total / time.Duration(len(sorted))
+	result.P50 = percentile(sorted, 50)
+	result.P95 = percentile(sorted, 95)
+	return result
+}
+
+// percentile uses the nearest rank method on sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func fmtDuration(d time.Duration) string {
+	if d >= time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+// A FailureLimit is the number of failed iterations tolerated by exec-loop, either as a count, or as a
+// percentage of the iterations run so far
+type FailureLimit struct {
+	count     int
+	percent   float64
+	isPercent bool
+}
+
+// ParseFailureLimit parses a failure count, such as 10, or a percentage, such as 5%
+func ParseFailureLimit(v string) (*FailureLimit, error) {
+	if pct, found := strings.CutSuffix(v, "%"); found {
+		percent, err := strconv.ParseFloat(pct, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, errors.Errorf("invalid failure percentage [%s], must be between 0%% and 100%%", v)
+		}
+		return &FailureLimit{percent: percent, isPercent: true}, nil
+	}
+	count, err := strconv.Atoi(v)
+	if err != nil || count < 0 {
+		return nil, errors.Errorf("invalid failure limit [%s], must be a count or a percentage", v)
+	}
+	return &FailureLimit{count: count}, nil
+}
+
+// Exceeded returns true if the failures are more than the limit allows. A non-zero percentage always allows at
+// least one failure, so a failure in the first few iterations doesn't end the loop by itself.
+func (self *FailureLimit) Exceeded(failures, iterations int) bool {
+	if !self.isPercent {
+		return failures > self.count
+	}
+	allowed := int(self.percent / 100 * float64(iterations))
+	if self.percent > 0 {
+		allowed = max(allowed, 1)
+	}
+	return failures > allowed
+}
+
+func (self *FailureLimit) String() string {
+	if self.isPercent {
+		return strconv.FormatFloat(self.percent, 'f', -1, 64) + "%"
+	}
+	return strconv.Itoa(self.count)
+}
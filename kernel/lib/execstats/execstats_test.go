@@ -0,0 +1,129 @@
+package execstats
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarize(t *testing.T) {
+	req := require.New(t)
+
+	var durations []time.Duration
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+
+	s := summarize(durations)
+	req.Equal(100, s.Count)
+	req.Equal(time.Millisecond, s.Min)
+	req.Equal(100*time.Millisecond, s.Max)
+	req.Equal(50500*time.Microsecond, s.Mean)
+	req.Equal(50*time.Millisecond, s.P50)
+	req.Equal(95*time.Millisecond, s.P95)
+
+	s = summarize([]time.Duration{time.Second})
+	req.Equal(time.Second, s.P50)
+	req.Equal(time.Second, s.P95)
+
+	req.Equal(0, summarize(nil).Count)
+}
+
+func TestRecorderCsv(t *testing.T) {
+	req := require.New(t)
+	path := filepath.Join(t.TempDir(), "exec-loop", "run.csv")
+
+	recorder, err := NewRecorder(path, FormatCsv)
+	req.NoError(err)
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	req.NoError(recorder.Record(&Sample{Timestamp: now, Iteration: 1, Action: "validate", Duration: 1500 * time.Microsecond}))
+	req.NoError(recorder.Record(&Sample{Timestamp: now, Iteration: 2, Action: "validate", Duration: time.Second, Err: errors.New("timed out")}))
+	req.NoError(recorder.Record(&Sample{Timestamp: now, Iteration: 2, Action: IterationAction, Duration: 2 * time.Second}))
+	req.NoError(recorder.Close())
+
+	data, err := os.ReadFile(path)
+	req.NoError(err)
+	req.Equal("timestamp,worker,iteration,action,duration_ms,result,error\n"+
+		"2024-01-02T03:04:05Z,,1,validate,1.500,ok,\n"+
+		"2024-01-02T03:04:05Z,,2,validate,1000.000,failed,timed out\n"+
+		"2024-01-02T03:04:05Z,,2,iteration,2000.000,ok,\n", string(data))
+
+	summaries := recorder.Summarize()
+	req.Len(summaries, 2)
+	req.Equal("validate", summaries[0].Action)
+	req.Equal(2, summaries[0].Count)
+	req.Equal(1, summaries[0].Failures)
+	req.Equal(IterationAction, summaries[1].Action)
+	req.Equal(0, summaries[1].Failures)
+
+	out := &strings.Builder{}
+	req.NoError(recorder.WriteSummary(out))
+	req.Contains(out.String(), "validate")
+	req.Contains(out.String(), "1.5ms")
+}
+
+func TestRecorderJson(t *testing.T) {
+	req := require.New(t)
+	path := filepath.Join(t.TempDir(), "run.json")
+
+	recorder, err := NewRecorder(path, FormatJson)
+	req.NoError(err)
+	req.NoError(recorder.Record(&Sample{Timestamp: time.Now(), Worker: "chaos", Iteration: 3, Action: "kill", Duration: 250 * time.Millisecond, Err: errors.New("boom")}))
+	req.NoError(recorder.Close())
+	req.Equal("chaos/kill", recorder.Summarize()[0].Action)
+
+	file, err := os.Open(path)
+	req.NoError(err)
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	req.True(scanner.Scan())
+	var line map[string]any
+	req.NoError(json.Unmarshal(scanner.Bytes(), &line))
+	req.Equal(float64(3), line["iteration"])
+	req.Equal("chaos", line["worker"])
+	req.Equal("kill", line["action"])
+	req.Equal(float64(250), line["durationMs"])
+	req.Equal("failed", line["result"])
+	req.Equal("boom", line["error"])
+	req.False(scanner.Scan())
+}
+
+func TestFailureLimit(t *testing.T) {
+	req := require.New(t)
+
+	limit, err := ParseFailureLimit("0")
+	req.NoError(err)
+	req.True(limit.Exceeded(1, 1))
+
+	limit, err = ParseFailureLimit("3")
+	req.NoError(err)
+	req.False(limit.Exceeded(3, 3))
+	req.True(limit.Exceeded(4, 100))
+	req.Equal("3", limit.String())
+
+	limit, err = ParseFailureLimit("10%")
+	req.NoError(err)
+	req.False(limit.Exceeded(1, 1))
+	req.True(limit.Exceeded(2, 5))
+	req.False(limit.Exceeded(10, 100))
+	req.True(limit.Exceeded(11, 100))
+	req.Equal("10%", limit.String())
+
+	limit, err = ParseFailureLimit("0%")
+	req.NoError(err)
+	req.True(limit.Exceeded(1, 100))
+
+	for _, invalid := range []string{"", "-1", "abc", "101%", "x%"} {
+		_, err = ParseFailureLimit(invalid)
+		req.Error(err, invalid)
+	}
+}
@@ -38,10 +38,13 @@ func (restartStage *restartStage) Execute(run model.Run) error {
 	logrus.Infof("starting restart checks")
 	for _, r := range m.Regions {
 		for _, h := range r.Hosts {
+			sshConfigFactory, err := h.NewSshConfigFactory()
+			if err != nil {
+				return err
+			}
+
 			success := false
 			for tries := 0; tries < 5; tries++ {
-				sshConfigFactory := h.NewSshConfigFactory()
-
 				if output, err := libssh.RemoteExec(sshConfigFactory, "uptime"); err != nil {
 					logrus.Warnf("host not restarted [%s] (%v)", h.PublicIp, err)
 					time.Sleep(10 * time.Second)
@@ -38,8 +38,12 @@ func DistributeSshKey(hostSpec string) model.Stage {
 func (self *distSshKey) Execute(run model.Run) error {
 	return run.GetModel().ForEachHost(self.hostSpec, 25, func(host *model.Host) error {
 		return retryOnHost(host, func() error {
-			keyPath := fmt.Sprintf("/home/%v/.ssh/id_rsa", host.GetSshUser())
-			sshKeyPath := host.NewSshConfigFactory().KeyPath()
+			keyPath := host.GetHomeDir() + "/.ssh/id_rsa"
+			sshConfigFactory, err := host.NewSshConfigFactory()
+			if err != nil {
+				return err
+			}
+			sshKeyPath := sshConfigFactory.KeyPath()
 
 			if _, err := host.ExecLogged(fmt.Sprintf("rm -f %v", keyPath)); err != nil {
 				return fmt.Errorf("error removing old PK on host [%s] (%w)", host.PublicIp, err)
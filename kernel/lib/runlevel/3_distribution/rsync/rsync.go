@@ -123,7 +123,7 @@ func (self *rsyncContext) init(m *model.Model) {
 
 func (self *rsyncContext) getDestPath(h *model.Host) string {
 	if !strings.HasPrefix(self.dst, "/") {
-		return fmt.Sprintf("/home/%s/%s", h.GetSshUser(), self.dst)
+		return h.GetHomeDir() + "/" + self.dst
 	}
 	return self.dst
 }
@@ -183,8 +183,11 @@ func (self *localRsyncer) run() error {
 			return nil
 		}
 		logrus.Infof("syncing local -> %v. Left: %v, current: %v", host.PublicIp, left, current)
-		config := NewConfig(host)
-		if err := synchronizeHost(self.rsyncContext, config); err != nil {
+		config, err := NewConfig(host)
+		if err != nil {
+			return err
+		}
+		if err = synchronizeHost(self.rsyncContext, config); err != nil {
 			return errors.Wrapf(err, "error synchronizing host [%s/%s]", host.GetRegion().GetId(), host.GetId())
 		}
 		left, current = self.markDone()
@@ -224,9 +227,15 @@ func (self *remoteRsyncer) run() error {
 		if host.Region.Id != self.host.Region.Id {
 			didAltRegion = true
 		}
-		srcConfig := NewConfig(self.host)
-		dstConfig := NewConfig(host)
-		if err := synchronizeHostToHost(self.rsyncContext, srcConfig, dstConfig); err != nil {
+		srcConfig, err := NewConfig(self.host)
+		if err != nil {
+			return err
+		}
+		dstConfig, err := NewConfig(host)
+		if err != nil {
+			return err
+		}
+		if err = synchronizeHostToHost(self.rsyncContext, srcConfig, dstConfig); err != nil {
 			return errors.Wrapf(err, "error synchronizing host [%s/%s]", host.GetRegion().GetId(), host.GetId())
 		}
 		left, current = self.markDone()
@@ -308,8 +317,8 @@ func synchronizeHostToHostOnce(ctx *rsyncContext, srcConfig, dstConfig *Config)
 
 	destination := fmt.Sprintf("%s:%s", dstConfig.loginPrefix(), ctx.getDestPath(dstConfig.host))
 
-	cmd := fmt.Sprintf("rsync -avz --delete -e 'ssh -o StrictHostKeyChecking=no' %s %s",
-		ctx.getDestPath(srcConfig.host), destination)
+	cmd := fmt.Sprintf("rsync -avz --delete -e '%s' %s %s",
+		dstConfig.remoteSshCommand(), ctx.getDestPath(srcConfig.host), destination)
 	output, err := libssh.RemoteExec(srcConfig.sshConfigFactory, cmd)
 	if err == nil && output != "" {
 		logrus.Infof("output [%s]", strings.Trim(output, " \t\r\n"))
@@ -324,31 +333,79 @@ type Config struct {
 	rsyncBin         string
 }
 
-func NewConfig(h *model.Host) *Config {
+func NewConfig(h *model.Host) (*Config, error) {
+	sshConfigFactory, err := h.NewSshConfigFactory()
+	if err != nil {
+		return nil, err
+	}
+
 	config := &Config{
 		host:             h,
 		sshBin:           h.GetStringVariableOr("distribution.ssh_bin", "ssh"),
-		sshConfigFactory: h.NewSshConfigFactory(),
+		sshConfigFactory: sshConfigFactory,
 		rsyncBin:         h.GetStringVariableOr("distribution.rsync_bin", "rsync"),
 	}
 
-	return config
+	return config, nil
 }
 
 func (config *Config) sshIdentityFlag() string {
-	if config.sshConfigFactory.KeyPath() != "" {
-		return "-i " + config.sshConfigFactory.KeyPath()
+	if keyPath := libssh.IdentityFile(config.sshConfigFactory); keyPath != "" {
+		return "-i " + keyPath
 	}
 
 	return ""
 }
 
+// usesPassword returns true if the host authenticates with a password, which the ssh client spawned by rsync can't
+// be given
+func (config *Config) usesPassword() bool {
+	f, ok := config.sshConfigFactory.(interface{ Password() string })
+	return ok && f.Password() != ""
+}
+
+// rsyncError describes a failed rsync, pointing at the password when the host relies on one
+func (config *Config) rsyncError(err error) error {
+	if config.usesPassword() {
+		return fmt.Errorf("rsync failed, host [%s] uses password authentication, which rsync's ssh can't use, "+
+			"configure a key with credentials.ssh.key_path or add one to the ssh agent (%w)", config.host.GetPath(), err)
+	}
+	return fmt.Errorf("rsync failed (%w)", err)
+}
+
 func (config *Config) loginPrefix() string {
 	return config.sshConfigFactory.User() + "@" + config.sshConfigFactory.Hostname()
 }
 
+// SshCommand returns the ssh command used by rsync, which verifies host keys against the instance known hosts and
+// tunnels through the host's jump hosts. Options are double quoted as needed, as rsync splits the command itself.
+// When the host uses a password, ssh runs in batch mode, failing if the key or agent isn't accepted rather than
+// waiting at a password prompt.
 func (config *Config) SshCommand() string {
-	return config.sshBin + " " + config.sshIdentityFlag()
+	result := config.sshBin + " " + config.sshIdentityFlag()
+	if port := config.sshConfigFactory.Port(); port != 22 {
+		result += fmt.Sprintf(" -p %d", port)
+	}
+	if config.usesPassword() {
+		result += " -o BatchMode=yes"
+	}
+	for _, option := range libssh.SshOptions(config.sshConfigFactory) {
+		if strings.ContainsAny(option, ` '"`) {
+			option = `"` + strings.ReplaceAll(option, `"`, `""`) + `"`
+		}
+		result += " " + option
+	}
+	return result
+}
+
+// remoteSshCommand returns the ssh command used by rsync on another host to reach this host, which authenticates
+// with the key of the other host
+func (config *Config) remoteSshCommand() string {
+	result := "ssh -o StrictHostKeyChecking=no -o BatchMode=yes"
+	if port := config.sshConfigFactory.Port(); port != 22 {
+		result += fmt.Sprintf(" -p %d", port)
+	}
+	return result
 }
 
 func NewRsyncHost(hostSpec, src, dest string) model.Stage {
@@ -367,8 +424,11 @@ type rsyncHostStage struct {
 
 func (self *rsyncHostStage) Execute(run model.Run) error {
 	return run.GetModel().ForEachHost(self.hostSpec, 1, func(host *model.Host) error {
-		cfg := NewConfig(host)
+		cfg, err := NewConfig(host)
+		if err != nil {
+			return err
+		}
 		dest := cfg.sshConfigFactory.User() + "@" + cfg.sshConfigFactory.Hostname() + ":" + self.dest
-		return RunRsync(NewConfig(host), self.src, dest)
+		return RunRsync(cfg, self.src, dest)
 	})
 }
@@ -17,15 +17,14 @@
 package rsync
 
 import (
-	"fmt"
 	"github.com/openziti/fablab/kernel/lib"
 )
 
 func RunRsync(config *Config, sourcePath, targetPath string) error {
-	rsync := lib.NewProcess(config.rsyncBin, "-avz", "-e", config.SshCommand()+" -o StrictHostKeyChecking=no", "--delete", sourcePath, targetPath)
+	rsync := lib.NewProcess(config.rsyncBin, "-avz", "-e", config.SshCommand(), "--delete", sourcePath, targetPath)
 	rsync.WithTail(lib.StdoutTail)
 	if err := rsync.Run(); err != nil {
-		return fmt.Errorf("rsync failed (%w)", err)
+		return config.rsyncError(err)
 	}
 	return nil
 }
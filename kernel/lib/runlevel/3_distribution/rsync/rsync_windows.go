@@ -17,7 +17,6 @@
 package rsync
 
 import (
-	"fmt"
 	"github.com/openziti/fablab/kernel/lib"
 	"github.com/sirupsen/logrus"
 	"strings"
@@ -37,12 +36,12 @@ func RunRsync(config *Config, sourcePath, targetPath string) error {
 	//rsync at version 3.1.2 on Windows has a 'bug' where if drive letter colons (i.e. the : in C:\) trigger
 	//rsync to think that the path is a remote machine. It assumes anything with a colon is a remote machine + path.
 	//To work around this, sourcePath should be a directory and we swap into it and use "." or "./" to refer to it
-	rsync := lib.NewProcess(config.rsyncBin, "-avz", "-e", config.SshCommand()+` -o StrictHostKeyChecking=no`, "--delete", ".", targetPath)
+	rsync := lib.NewProcess(config.rsyncBin, "-avz", "-e", config.SshCommand(), "--delete", ".", targetPath)
 	rsync.Cmd.Dir = sourcePath
 
 	rsync.WithTail(lib.StdoutTail)
 	if err := rsync.Run(); err != nil {
-		return fmt.Errorf("rsync failed (%w)", err)
+		return config.rsyncError(err)
 	}
 	return nil
 }
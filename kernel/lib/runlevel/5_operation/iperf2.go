@@ -40,7 +40,10 @@ func (self *iperfClient) Execute(run model.Run) error {
 		return errors.Errorf("expected [1] iperf client host, found [%d]", len(hosts))
 	}
 
-	ssh := hosts[0].NewSshConfigFactory()
+	ssh, err := hosts[0].NewSshConfigFactory()
+	if err != nil {
+		return err
+	}
 
 	cmd := fmt.Sprintf("iperf3 -c %s -p %d", self.address, self.port)
 	if err := libssh.RemoteConsole(ssh, cmd); err != nil {
@@ -44,8 +44,14 @@ func (i *iperfUdp) Execute(run model.Run) error {
 		serverHost := serverHosts[0]
 		clientHost := clientHosts[0]
 
-		sshClientFactory := clientHost.NewSshConfigFactory()
-		sshServerFactory := serverHost.NewSshConfigFactory()
+		sshClientFactory, err := clientHost.NewSshConfigFactory()
+		if err != nil {
+			return err
+		}
+		sshServerFactory, err := serverHost.NewSshConfigFactory()
+		if err != nil {
+			return err
+		}
 
 		go i.runServer(sshServerFactory)
 
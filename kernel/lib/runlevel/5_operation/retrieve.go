@@ -38,7 +38,10 @@ func (self *retrieve) Execute(run model.Run) error {
 	hosts := m.SelectHosts(self.host)
 	if len(hosts) == 1 {
 		host := hosts[0]
-		ssh := host.NewSshConfigFactory()
+		ssh, err := host.NewSshConfigFactory()
+		if err != nil {
+			return err
+		}
 
 		if files, err := libssh.RemoteFileList(ssh, self.path); err == nil {
 			paths := make([]string, 0)
@@ -42,13 +42,19 @@ func SarCloser(host *model.Host) model.Stage {
 }
 
 func (s *sar) Execute(model.Run) error {
-	ssh := s.host.NewSshConfigFactory()
+	ssh, err := s.host.NewSshConfigFactory()
+	if err != nil {
+		return err
+	}
 	go s.runSar(ssh)
 	return nil
 }
 
 func (s *sarCloser) Execute(model.Run) error {
-	ssh := s.host.NewSshConfigFactory()
+	ssh, err := s.host.NewSshConfigFactory()
+	if err != nil {
+		return err
+	}
 	if err := libssh.RemoteKill(ssh, "sar"); err != nil {
 		return fmt.Errorf("error closing sar (%w)", err)
 	}
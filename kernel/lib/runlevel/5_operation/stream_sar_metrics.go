@@ -56,7 +56,10 @@ type streamSarMetrics struct {
 
 func (s *streamSarMetrics) Execute(model.Run) error {
 	go s.waitForClose()
-	ssh := s.host.NewSshConfigFactory()
+	ssh, err := s.host.NewSshConfigFactory()
+	if err != nil {
+		return err
+	}
 	go s.runSar(ssh)
 	return nil
 }
@@ -64,8 +67,12 @@ func (s *streamSarMetrics) Execute(model.Run) error {
 func (s *streamSarMetrics) waitForClose() {
 	<-s.closer
 	if s.closed.CompareAndSwap(false, true) {
-		ssh := s.host.NewSshConfigFactory()
-		if err := libssh.RemoteKill(ssh, "sar"); err != nil {
+		ssh, err := s.host.NewSshConfigFactory()
+		if err != nil {
+			logrus.WithError(err).Warn("did not close sar")
+			return
+		}
+		if err = libssh.RemoteKill(ssh, "sar"); err != nil {
 			logrus.Warnf("did not close sar, it may have already stopped normally (%v)", err)
 		}
 	}
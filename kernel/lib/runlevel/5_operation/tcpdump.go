@@ -41,7 +41,10 @@ func (t *tcpdump) Execute(run model.Run) error {
 		return err
 	}
 
-	ssh := host.NewSshConfigFactory()
+	ssh, err := host.NewSshConfigFactory()
+	if err != nil {
+		return err
+	}
 
 	if err := libssh.RemoteKill(ssh, "tcpdump"); err != nil {
 		return fmt.Errorf("error killing tcpdump instances")
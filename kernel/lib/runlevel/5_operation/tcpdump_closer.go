@@ -36,7 +36,10 @@ func (t *tcpdumpCloser) Execute(run model.Run) error {
 		return err
 	}
 
-	ssh := host.NewSshConfigFactory()
+	ssh, err := host.NewSshConfigFactory()
+	if err != nil {
+		return err
+	}
 
 	if err := libssh.RemoteKillFilter(ssh, "tcpdump", "sudo"); err != nil {
 		return fmt.Errorf("error closing tcpdump (%w)", err)
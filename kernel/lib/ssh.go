@@ -21,6 +21,6 @@ import (
 	"github.com/openziti/fablab/kernel/model"
 )
 
-func NewSshConfigFactory(host *model.Host) *libssh.SshConfigFactoryImpl {
+func NewSshConfigFactory(host *model.Host) (*libssh.SshConfigFactoryImpl, error) {
 	return host.NewSshConfigFactory()
 }
@@ -0,0 +1,562 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package tui
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/openziti/fablab/kernel/model"
+	"github.com/sirupsen/logrus"
+)
+
+type consoleTab int
+
+const (
+	tabActions consoleTab = iota
+	tabComponents
+	tabHosts
+)
+
+var consoleTabs = []string{"Actions", "Components", "Hosts"}
+
+var (
+	dimStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("245"))
+	selectedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("15")).Background(lipgloss.Color("63"))
+)
+
+// Messages
+
+type actionDoneMsg struct {
+	action *consoleAction
+	result *model.ActionResult
+}
+
+// consoleAction is a model action, or a component action wrapped as a model action for a single component
+type consoleAction struct {
+	name   string
+	action model.Action
+}
+
+func (self *consoleAction) params() []*model.ActionParam {
+	return model.GetActionParams(self.action)
+}
+
+// consoleItem is an entry in a list. Entering an item either opens a nested list or runs an action.
+type consoleItem struct {
+	label  string
+	detail string
+	open   func() *consoleList
+	action *consoleAction
+}
+
+type consoleList struct {
+	title  string
+	items  []*consoleItem
+	cursor int
+	offset int
+}
+
+// consoleModel is the interactive action launcher. The left column lists model actions, components or hosts,
+// and the right column shows the log output of the actions run from the console.
+type consoleModel struct {
+	model     *model.Model
+	tab       consoleTab
+	selectors map[consoleTab]string
+	stack     []*consoleList
+	pane      *tuiPane
+	focusPane bool
+
+	confirm     *consoleAction
+	selecting   bool
+	input       textinput.Model
+	lastArgs    map[string]string // the args last used with each action
+	running     *consoleAction
+	runStart    time.Time
+	last        *consoleAction
+	lastResult  *model.ActionResult
+	quitPending bool
+
+	width  int
+	height int
+	paneKeys
+}
+
+func newConsoleModel(m *model.Model, saveDir string) *consoleModel {
+	result := &consoleModel{
+		model:     m,
+		selectors: map[consoleTab]string{tabComponents: "*", tabHosts: "*"},
+		pane:      &tuiPane{name: PaneActions, title: "Output"},
+		lastArgs:  map[string]string{},
+		paneKeys:  paneKeys{saveDir: saveDir},
+	}
+	result.selectTab(tabActions)
+	return result
+}
+
+func (m *consoleModel) selectTab(tab consoleTab) {
+	m.tab = tab
+	switch tab {
+	case tabActions:
+		m.stack = []*consoleList{m.actionsList()}
+	case tabComponents:
+		m.stack = []*consoleList{m.componentsList(m.model.SelectComponents(m.selectors[tab]), "components matching "+m.selectors[tab])}
+	case tabHosts:
+		m.stack = []*consoleList{m.hostsList()}
+	}
+}
+
+func (m *consoleModel) actionsList() *consoleList {
+	result := &consoleList{title: "model actions"}
+	for _, name := range m.model.GetActions() {
+		action, _ := m.model.GetAction(name)
+		var params []string
+		for _, param := range model.GetActionParams(action) {
+			params = append(params, param.Name)
+		}
+		result.items = append(result.items, &consoleItem{
+			label:  name,
+			detail: strings.Join(params, " "),
+			action: &consoleAction{name: name, action: action},
+		})
+	}
+	return result
+}
+
+func (m *consoleModel) hostsList() *consoleList {
+	result := &consoleList{title: "hosts matching " + m.selectors[tabHosts]}
+	for _, host := range m.model.SelectHosts(m.selectors[tabHosts]) {
+		result.items = append(result.items, &consoleItem{
+			label:  host.Id,
+			detail: host.PublicIp,
+			open: func() *consoleList {
+				var components []*model.Component
+				host.RangeSortedComponents(func(_ string, c *model.Component) {
+					components = append(components, c)
+				})
+				return m.componentsList(components, "components on "+host.Id)
+			},
+		})
+	}
+	return result
+}
+
+func (m *consoleModel) componentsList(components []*model.Component, title string) *consoleList {
+	result := &consoleList{title: title}
+	for _, c := range components {
+		result.items = append(result.items, &consoleItem{
+			label:  c.Id,
+			detail: c.Host.Id,
+			open: func() *consoleList {
+				return componentActionsList(c)
+			},
+		})
+	}
+	return result
+}
+
+func componentActionsList(c *model.Component) *consoleList {
+	result := &consoleList{title: "actions of " + c.Id}
+	actions := c.GetActions()
+	var names []string
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		componentAction := actions[name]
+		result.items = append(result.items, &consoleItem{
+			label: name,
+			action: &consoleAction{
+				name: name + " on " + c.GetPathId(),
+				action: model.ActionFunc(func(run model.Run) error {
+					return model.RecordOutcome(run, c, func(*model.Outcome) error {
+						return componentAction.Execute(run, c)
+					})
+				}),
+			},
+		})
+	}
+	return result
+}
+
+func (m *consoleModel) current() *consoleList {
+	return m.stack[len(m.stack)-1]
+}
+
+func (m *consoleModel) Init() tea.Cmd {
+	return tickCmd()
+}
+
+func (m *consoleModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		return m, m.updateKey(msg)
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.pane.vp.Width = m.paneWidth()
+		m.pane.vp.Height = max(m.height-3, 1)
+		m.pane.refresh(true)
+
+	case logLineMsg:
+		m.pane.add(newLogLine(msg.text, msg.level, msg.host))
+
+	case actionDoneMsg:
+		m.running = nil
+		m.lastResult = msg.result
+		if msg.result.Success {
+			m.log(logrus.InfoLevel, "✔ %s succeeded in %s", msg.action.name, msg.result.Duration.Round(time.Millisecond))
+		} else {
+			m.log(logrus.ErrorLevel, "✘ %s failed after %s: %s", msg.action.name, msg.result.Duration.Round(time.Millisecond), msg.result.Error)
+		}
+
+	case tickMsg:
+		return m, tickCmd()
+	}
+	return m, nil
+}
+
+// log adds a line to the output pane directly. Logging through logrus here would deadlock, as the log hook sends
+// to the program which is busy running this update.
+func (m *consoleModel) log(level logrus.Level, format string, args ...any) {
+	style := doneSuccessStyle
+	if level <= logrus.ErrorLevel {
+		style = doneErrorStyle
+	}
+	m.pane.add(newLogLine(style.Render(fmt.Sprintf(format, args...)), level, ""))
+}
+
+func (m *consoleModel) updateKey(msg tea.KeyMsg) tea.Cmd {
+	m.notice = ""
+	if m.confirm != nil {
+		return m.updateConfirm(msg)
+	}
+	if m.selecting {
+		return m.updateSelector(msg)
+	}
+
+	if msg.String() != "q" {
+		m.quitPending = false
+	}
+
+	if m.focusPane {
+		if handled, cmd := m.handleKey(m.pane, msg); handled {
+			return cmd
+		}
+	}
+
+	switch msg.String() {
+	case "ctrl+c":
+		return tea.Quit
+	case "q":
+		if m.running != nil && !m.quitPending {
+			m.quitPending = true
+			m.notice = fmt.Sprintf("%s is still running, press q again to quit", m.running.name)
+			return nil
+		}
+		return tea.Quit
+	case "tab":
+		m.focusPane = !m.focusPane
+		return nil
+	case "r":
+		if m.last != nil {
+			return m.startConfirm(m.last)
+		}
+		return nil
+	}
+
+	if m.focusPane {
+		var cmd tea.Cmd
+		m.pane.vp, cmd = m.pane.vp.Update(msg)
+		return cmd
+	}
+	return m.updateList(msg)
+}
+
+func (m *consoleModel) updateList(msg tea.KeyMsg) tea.Cmd {
+	list := m.current()
+	switch msg.String() {
+	case "1", "2", "3":
+		m.selectTab(consoleTab(msg.String()[0] - '1'))
+	case "up", "k":
+		list.cursor = max(list.cursor-1, 0)
+	case "down", "j":
+		list.cursor = min(list.cursor+1, max(len(list.items)-1, 0))
+	case "pgup":
+		list.cursor = max(list.cursor-m.listHeight(), 0)
+	case "pgdown":
+		list.cursor = min(list.cursor+m.listHeight(), max(len(list.items)-1, 0))
+	case "esc", "backspace", "left", "h":
+		if len(m.stack) > 1 {
+			m.stack = m.stack[:len(m.stack)-1]
+		}
+	case "f":
+		if m.tab != tabActions {
+			m.selecting = true
+			m.input = textinput.New()
+			m.input.Prompt = "selector: "
+			m.input.SetValue(m.selectors[m.tab])
+			m.input.CursorEnd()
+			return m.input.Focus()
+		}
+	case "enter", "right", "l":
+		if len(list.items) == 0 {
+			return nil
+		}
+		item := list.items[list.cursor]
+		if item.open != nil {
+			m.stack = append(m.stack, item.open())
+		} else if item.action != nil {
+			return m.startConfirm(item.action)
+		}
+	}
+	return nil
+}
+
+func (m *consoleModel) updateSelector(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return tea.Quit
+	case tea.KeyEsc:
+		m.selecting = false
+		return nil
+	case tea.KeyEnter:
+		m.selecting = false
+		if selector := strings.TrimSpace(m.input.Value()); selector != "" {
+			m.selectors[m.tab] = selector
+		}
+		m.selectTab(m.tab)
+		return nil
+	}
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return cmd
+}
+
+// startConfirm asks for confirmation before running the action. Arguments can be given for actions with
+// parameters, starting with the arguments last used with the action.
+func (m *consoleModel) startConfirm(action *consoleAction) tea.Cmd {
+	if m.running != nil {
+		m.notice = fmt.Sprintf("%s is still running", m.running.name)
+		return nil
+	}
+	m.confirm = action
+	m.input = textinput.New()
+	m.input.Prompt = "args: "
+	m.input.Placeholder = "name=value ..."
+	m.input.SetValue(m.lastArgs[action.name])
+	m.input.CursorEnd()
+	return m.input.Focus()
+}
+
+func (m *consoleModel) updateConfirm(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return tea.Quit
+	case tea.KeyEsc:
+		m.confirm = nil
+		return nil
+	case tea.KeyEnter:
+		action := m.confirm
+		rawArgs, err := model.ParseArgs(strings.Fields(m.input.Value()))
+		if err == nil {
+			_, err = model.ResolveArgs(action.params(), rawArgs)
+		}
+		if err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		m.confirm = nil
+		m.lastArgs[action.name] = m.input.Value()
+		return m.run(action, rawArgs)
+	}
+	if len(m.confirm.params()) == 0 {
+		return nil
+	}
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return cmd
+}
+
+// run executes the action in the background, with a new run, so one time operations are repeated when the action
+// is run again
+func (m *consoleModel) run(action *consoleAction, rawArgs map[string]string) tea.Cmd {
+	m.running = action
+	m.last = action
+	m.runStart = time.Now()
+	m.log(logrus.InfoLevel, "▶ running %s %s", action.name, m.lastArgs[action.name])
+	return func() tea.Msg {
+		run, err := model.NewRun()
+		if err != nil {
+			result := model.NewActionResult(action.name)
+			result.Complete(err)
+			return actionDoneMsg{action: action, result: result}
+		}
+		result, _ := model.ExecuteWithResult(run, action.name, action.action, rawArgs)
+		return actionDoneMsg{action: action, result: result}
+	}
+}
+
+func (m *consoleModel) listWidth() int {
+	return min(max(m.width/3, 24), 48)
+}
+
+func (m *consoleModel) paneWidth() int {
+	return max(m.width-m.listWidth()-1, 1)
+}
+
+// listHeight is the number of list items shown, below the tabs and the list title
+func (m *consoleModel) listHeight() int {
+	return max(m.height-4, 1)
+}
+
+func (m *consoleModel) View() string {
+	if m.width == 0 || m.height == 0 {
+		return "Initializing..."
+	}
+
+	var b strings.Builder
+	b.WriteString(statusBarStyle.Width(m.width).MaxHeight(1).Render(m.statusLine()))
+	b.WriteString("\n")
+
+	separator := strings.TrimSuffix(strings.Repeat("│\n", m.height-2), "\n")
+	right := m.renderTitle(m.pane, m.focusPane, m.paneWidth()) + "\n" + m.pane.vp.View()
+	b.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, m.renderList(), dimStyle.Render(separator), right))
+	b.WriteString("\n")
+	b.WriteString(lipgloss.NewStyle().Width(m.width).MaxHeight(1).Render(m.bottomLine()))
+	return b.String()
+}
+
+func (m *consoleModel) statusLine() string {
+	result := " fablab console"
+	if m.running != nil {
+		result += fmt.Sprintf("  |  running %s: %s", m.running.name, formatDuration(time.Since(m.runStart)))
+	} else if m.lastResult != nil {
+		status := "succeeded"
+		if !m.lastResult.Success {
+			status = "failed"
+		}
+		result += fmt.Sprintf("  |  last: %s %s in %s", m.lastResult.Action, status, m.lastResult.Duration.Round(time.Millisecond))
+	}
+	if m.notice != "" {
+		result += "  |  " + m.notice
+	}
+	return result
+}
+
+func (m *consoleModel) bottomLine() string {
+	if m.confirm != nil {
+		prompt := fmt.Sprintf(" run %s? enter to run, esc to cancel", m.confirm.name)
+		if params := m.confirm.params(); len(params) > 0 {
+			var names []string
+			for _, param := range params {
+				names = append(names, param.String())
+			}
+			prompt += "  " + m.input.View() + "  " + dimStyle.Render(strings.Join(names, "; "))
+		}
+		return prompt
+	}
+	if m.selecting {
+		return " " + m.input.View()
+	}
+	return dimStyle.Render(" 1-3 tabs  ↑/↓ move  enter open/run  esc back  f selector  r re-run  tab focus output  q quit")
+}
+
+// renderList renders the tabs, the title of the current list and its items, padded to the list width
+func (m *consoleModel) renderList() string {
+	width := m.listWidth()
+	list := m.current()
+
+	var tabs []string
+	for idx, name := range consoleTabs {
+		label := fmt.Sprintf("%d %s", idx+1, name)
+		if consoleTab(idx) == m.tab {
+			tabs = append(tabs, selectedStyle.Render(label))
+		} else {
+			tabs = append(tabs, dimStyle.Render(label))
+		}
+	}
+
+	lines := []string{" " + strings.Join(tabs, " "), dimStyle.Render(truncate(" "+list.title, width))}
+
+	height := m.listHeight()
+	list.offset = min(list.offset, list.cursor)
+	if list.cursor >= list.offset+height {
+		list.offset = list.cursor - height + 1
+	}
+	if len(list.items) == 0 {
+		lines = append(lines, dimStyle.Render(" (none)"))
+	}
+	for idx := list.offset; idx < len(list.items) && idx < list.offset+height; idx++ {
+		item := list.items[idx]
+		label := " " + item.label
+		if item.open != nil {
+			label += " ›"
+		}
+		if idx == list.cursor && !m.focusPane {
+			lines = append(lines, selectedStyle.Width(width).Render(truncate(label, width)))
+			continue
+		}
+		line := truncate(label, width)
+		if item.detail != "" && len([]rune(line))+2 < width {
+			line += "  " + dimStyle.Render(truncate(item.detail, width-len([]rune(line))-2))
+		}
+		lines = append(lines, line)
+	}
+
+	return lipgloss.NewStyle().Width(width).Height(m.height - 2).MaxHeight(m.height - 2).Render(strings.Join(lines, "\n"))
+}
+
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	if width < 1 {
+		return ""
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// RunConsole runs the interactive action launcher until the user quits. Model actions, and the actions of
+// components selected directly or through their hosts, can be run repeatedly without bootstrapping again.
+// Log output is shown in the output pane, which can be searched, filtered and saved like the exec-loop panes.
+func RunConsole(m *model.Model) error {
+	active.Store(true)
+
+	p := tea.NewProgram(newConsoleModel(m, filepath.Join(model.BuildPath(), "console")), tea.WithAltScreen())
+	logrus.AddHook(newLogHook(p))
+
+	origOut := logrus.StandardLogger().Out
+	logrus.SetOutput(io.Discard)
+	defer func() {
+		active.Store(false)
+		logrus.SetOutput(origOut)
+	}()
+
+	_, err := p.Run()
+	return err
+}
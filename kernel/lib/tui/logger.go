@@ -39,17 +39,20 @@ func IsActive() bool {
 // ActionsLogger returns a logger whose output routes to the actions pane when TUI is active.
 // When TUI is not active, returns a plain logger without the routing field.
 func ActionsLogger() *logrus.Entry {
-	if active.Load() {
-		return pfxlog.Logger().WithField(PaneField, PaneActions)
-	}
-	return pfxlog.Logger().WithFields(nil)
+	return PaneLogger(PaneActions)
 }
 
 // ValidationLogger returns a logger whose output routes to the validation pane when TUI is active.
 // When TUI is not active, returns a plain logger without the routing field.
 func ValidationLogger() *logrus.Entry {
+	return PaneLogger(PaneValidation)
+}
+
+// PaneLogger returns a logger whose output routes to the named pane when TUI is active.
+// When TUI is not active, returns a plain logger without the routing field.
+func PaneLogger(pane string) *logrus.Entry {
 	if active.Load() {
-		return pfxlog.Logger().WithField(PaneField, PaneValidation)
+		return pfxlog.Logger().WithField(PaneField, pane)
 	}
 	return pfxlog.Logger().WithFields(nil)
 }
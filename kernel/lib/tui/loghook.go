@@ -17,6 +17,7 @@
 package tui
 
 import (
+	"fmt"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/sirupsen/logrus"
 	"strings"
@@ -58,6 +59,19 @@ func (h *tuiLogHook) Fire(entry *logrus.Entry) error {
 	}
 
 	text := strings.TrimRight(string(formatted), "\n")
-	h.program.Send(logLineMsg{pane: pane, text: text})
+	h.program.Send(logLineMsg{pane: pane, text: text, level: entry.Level, host: entryHost(entry)})
 	return nil
 }
+
+// hostFields are the fields identifying the host a log entry relates to, in order of preference
+var hostFields = []string{"hostId", "host", "addr"}
+
+// entryHost returns the host the entry relates to, used to filter panes by host
+func entryHost(entry *logrus.Entry) string {
+	for _, field := range hostFields {
+		if v, ok := entry.Data[field]; ok {
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
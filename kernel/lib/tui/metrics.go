@@ -0,0 +1,240 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/openziti/fablab/kernel/model"
+)
+
+// metricsHistory is the number of samples kept for each host metric
+const metricsHistory = 120
+
+type hostMetric int
+
+const (
+	metricCpu hostMetric = iota
+	metricMemory
+	metricLoad
+)
+
+var hostMetrics = []hostMetric{metricCpu, metricMemory, metricLoad}
+
+func (self hostMetric) String() string {
+	switch self {
+	case metricCpu:
+		return "cpu"
+	case metricMemory:
+		return "memory"
+	}
+	return "load"
+}
+
+func (self hostMetric) label() string {
+	switch self {
+	case metricCpu:
+		return "CPU"
+	case metricMemory:
+		return "MEM"
+	}
+	return "LOAD"
+}
+
+var sparkRunes = []rune("▁▂▃▄▅▆▇█")
+
+var warnStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+
+// Messages
+
+type hostMetricsMsg struct {
+	host   string
+	metric hostMetric
+	value  float64
+}
+
+// metricsHandler forwards the host metrics reported by sar to the host metrics section
+type metricsHandler struct {
+	program *tea.Program
+}
+
+func (self *metricsHandler) AcceptHostMetrics(host *model.Host, event *model.MetricsEvent) {
+	if idle, ok := event.Metrics.GetFloat64Metric("percent_idle"); ok {
+		self.program.Send(hostMetricsMsg{host: host.Id, metric: metricCpu, value: 100 - idle})
+	}
+	if used, ok := event.Metrics.GetFloat64Metric("used_percent"); ok {
+		self.program.Send(hostMetricsMsg{host: host.Id, metric: metricMemory, value: used})
+	}
+	if load, ok := event.Metrics.GetFloat64Metric("load_average_1m"); ok {
+		self.program.Send(hostMetricsMsg{host: host.Id, metric: metricLoad, value: load})
+	}
+}
+
+// EnableMetrics adds a metrics handler to the model which shows the cpu, memory and load of each host in the TUI,
+// as sparklines followed by the latest value. The section is shown once the first metrics are reported, for
+// example by operation.StreamSarMetrics.
+func EnableMetrics(p *tea.Program, run model.Run) {
+	m := run.GetModel()
+	m.MetricsHandlers = append(m.MetricsHandlers, &metricsHandler{program: p})
+}
+
+// hostSeries holds the recent values of each metric for a host, oldest first
+type hostSeries struct {
+	host   string
+	values map[hostMetric][]float64
+}
+
+func (self *hostSeries) latest(metric hostMetric) float64 {
+	if values := self.values[metric]; len(values) > 0 {
+		return values[len(values)-1]
+	}
+	return -1
+}
+
+// metricsView is the section showing host metrics. Hosts are sorted by the latest value of the selected metric,
+// hottest first, or by host id.
+type metricsView struct {
+	sectionLayout
+	hosts   map[string]*hostSeries
+	orderBy int // index into hostMetrics, or len(hostMetrics) to order by host
+}
+
+func newMetricsView() *metricsView {
+	return &metricsView{hosts: map[string]*hostSeries{}}
+}
+
+func (self *metricsView) layout() *sectionLayout {
+	return &self.sectionLayout
+}
+
+func (self *metricsView) update(msg hostMetricsMsg) {
+	series, found := self.hosts[msg.host]
+	if !found {
+		series = &hostSeries{host: msg.host, values: map[hostMetric][]float64{}}
+		self.hosts[msg.host] = series
+	}
+	values := append(series.values[msg.metric], msg.value)
+	if len(values) > metricsHistory {
+		values = values[len(values)-metricsHistory:]
+	}
+	series.values[msg.metric] = values
+}
+
+func (self *metricsView) nextOrder() {
+	self.orderBy = (self.orderBy + 1) % (len(hostMetrics) + 1)
+}
+
+func (self *metricsView) orderName() string {
+	if self.orderBy < len(hostMetrics) {
+		return hostMetrics[self.orderBy].String()
+	}
+	return "host"
+}
+
+func (self *metricsView) title() string {
+	return fmt.Sprintf(" Host Metrics (sorted by %s, o to change order, m to hide) ", self.orderName())
+}
+
+func (self *metricsView) sorted() []*hostSeries {
+	var result []*hostSeries
+	for _, series := range self.hosts {
+		result = append(result, series)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if self.orderBy < len(hostMetrics) {
+			metric := hostMetrics[self.orderBy]
+			if a, b := result[i].latest(metric), result[j].latest(metric); a != b {
+				return a > b
+			}
+		}
+		return result[i].host < result[j].host
+	})
+	return result
+}
+
+// lines renders a line per host, giving each metric an equal share of the width for its sparkline
+func (self *metricsView) lines(width int) []string {
+	hostWidth := 0
+	for host := range self.hosts {
+		hostWidth = max(hostWidth, len(host))
+	}
+
+	// per metric: a 5 character label, the sparkline, a 7 character value and 2 spaces
+	sparkWidth := (width - hostWidth - 2 - len(hostMetrics)*14) / len(hostMetrics)
+	sparkWidth = min(max(sparkWidth, 5), metricsHistory)
+
+	var result []string
+	for _, series := range self.sorted() {
+		line := series.host + strings.Repeat(" ", hostWidth-len(series.host)) + "  "
+		for _, metric := range hostMetrics {
+			values := series.values[metric]
+			line += fmt.Sprintf("%-5s", metric.label()) + sparkline(values, sparkWidth, metricScale(metric, values))
+			line += formatMetric(metric, series.latest(metric)) + "  "
+		}
+		result = append(result, line)
+	}
+	return result
+}
+
+// metricScale returns the value shown as a full bar. Percentages are scaled to 100, load to the highest value
+// seen, or 1, whichever is greater.
+func metricScale(metric hostMetric, values []float64) float64 {
+	if metric != metricLoad {
+		return 100
+	}
+	result := 1.0
+	for _, v := range values {
+		result = max(result, v)
+	}
+	return result
+}
+
+// sparkline renders the last width values, padding on the left if there are fewer values
+func sparkline(values []float64, width int, scale float64) string {
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	var b strings.Builder
+	b.WriteString(strings.Repeat(" ", width-len(values)))
+	for _, v := range values {
+		idx := int(v / scale * float64(len(sparkRunes)-1))
+		b.WriteRune(sparkRunes[min(max(idx, 0), len(sparkRunes)-1)])
+	}
+	return b.String()
+}
+
+// formatMetric renders the latest value in 7 characters, highlighting high cpu and memory usage
+func formatMetric(metric hostMetric, value float64) string {
+	if value < 0 {
+		return fmt.Sprintf("%7s", "-")
+	}
+	if metric == metricLoad {
+		return fmt.Sprintf("%7.2f", value)
+	}
+	result := fmt.Sprintf("%6.1f%%", value)
+	if value >= 90 {
+		return doneErrorStyle.Render(result)
+	}
+	if value >= 70 {
+		return warnStyle.Render(result)
+	}
+	return result
+}
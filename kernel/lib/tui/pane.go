@@ -0,0 +1,361 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package tui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/sirupsen/logrus"
+)
+
+// levelFilters are the minimum levels cycled through by the level filter, starting with no filter
+var levelFilters = []logrus.Level{logrus.TraceLevel, logrus.InfoLevel, logrus.WarnLevel, logrus.ErrorLevel}
+
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+var (
+	matchStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("0")).
+			Background(lipgloss.Color("11"))
+
+	currentMatchStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("0")).
+				Background(lipgloss.Color("208"))
+)
+
+// logLine is a formatted log entry, with the fields used to filter it
+type logLine struct {
+	text  string
+	plain string // text without ANSI colors, used for searching and saving
+	level logrus.Level
+	host  string
+}
+
+func newLogLine(text string, level logrus.Level, host string) logLine {
+	return logLine{text: text, plain: ansiPattern.ReplaceAllString(text, ""), level: level, host: host}
+}
+
+// tuiPane is a scrollable log pane. Log lines are routed to panes by name, lines for unknown panes go to the first pane.
+//
+// Every line is kept, and the pane shows the lines which pass its level and host filters. Lines matching the
+// search are highlighted. While the pane is paused, new lines are kept but not shown, so the view doesn't move.
+type tuiPane struct {
+	name  string
+	title string
+	vp    viewport.Model
+	lines []logLine
+
+	shown   []int    // indices of the lines passing the filters
+	content []string // rendered shown lines
+	matches []int    // positions in shown of the lines matching the search
+	match   int      // position in matches of the current match
+
+	level   int // index into levelFilters
+	host    string
+	search  string
+	paused  bool
+	pending int // lines added while paused
+}
+
+func (self *tuiPane) minLevel() logrus.Level {
+	return levelFilters[self.level]
+}
+
+func (self *tuiPane) accepts(line *logLine) bool {
+	if line.level > self.minLevel() {
+		return false
+	}
+	return self.host == "" || strings.Contains(strings.ToLower(line.host), strings.ToLower(self.host))
+}
+
+func (self *tuiPane) matchesSearch(line *logLine) bool {
+	return self.search != "" && strings.Contains(strings.ToLower(line.plain), strings.ToLower(self.search))
+}
+
+func (self *tuiPane) add(line logLine) {
+	self.lines = append(self.lines, line)
+	if !self.accepts(&line) {
+		return
+	}
+	self.shown = append(self.shown, len(self.lines)-1)
+	if self.matchesSearch(&line) {
+		self.matches = append(self.matches, len(self.shown)-1)
+	}
+	self.content = append(self.content, self.renderLine(len(self.shown)-1))
+
+	if self.paused {
+		self.pending++
+		return
+	}
+	self.refresh(false)
+}
+
+// refilter applies changed filters or search to all lines
+func (self *tuiPane) refilter() {
+	self.shown, self.content, self.matches = nil, nil, nil
+	for idx := range self.lines {
+		line := &self.lines[idx]
+		if !self.accepts(line) {
+			continue
+		}
+		self.shown = append(self.shown, idx)
+		if self.matchesSearch(line) {
+			self.matches = append(self.matches, len(self.shown)-1)
+		}
+	}
+	self.match = max(len(self.matches)-1, 0)
+	self.pending = 0
+	for pos := range self.shown {
+		self.content = append(self.content, self.renderLine(pos))
+	}
+	self.refresh(true)
+	if len(self.matches) > 0 {
+		self.gotoMatch()
+	}
+}
+
+// refresh updates the viewport content. If the pane was at the bottom, or goToBottom is set, the pane follows
+// new lines.
+func (self *tuiPane) refresh(goToBottom bool) {
+	wasAtBottom := self.vp.AtBottom()
+	self.vp.SetContent(strings.Join(self.content, "\n"))
+	if wasAtBottom || goToBottom {
+		self.vp.GotoBottom()
+	}
+}
+
+// renderLine renders the shown line at pos, highlighting search matches. Highlighted lines lose their colors.
+func (self *tuiPane) renderLine(pos int) string {
+	line := &self.lines[self.shown[pos]]
+	if !self.matchesSearch(line) {
+		return line.text
+	}
+
+	style := matchStyle
+	if len(self.matches) > 0 && self.matches[self.match] == pos {
+		style = currentMatchStyle
+	}
+
+	lower := strings.ToLower(line.plain)
+	needle := strings.ToLower(self.search)
+	if len(lower) != len(line.plain) {
+		// lower casing changed the byte offsets, so highlight the whole line
+		return style.Render(line.plain)
+	}
+
+	var b strings.Builder
+	start := 0
+	for {
+		idx := strings.Index(lower[start:], needle)
+		if idx < 0 {
+			break
+		}
+		b.WriteString(line.plain[start : start+idx])
+		b.WriteString(style.Render(line.plain[start+idx : start+idx+len(needle)]))
+		start += idx + len(needle)
+	}
+	b.WriteString(line.plain[start:])
+	return b.String()
+}
+
+func (self *tuiPane) setSearch(search string) {
+	self.search = search
+	self.refilter()
+}
+
+func (self *tuiPane) setHost(host string) {
+	self.host = host
+	self.refilter()
+}
+
+func (self *tuiPane) nextLevel() {
+	self.level = (self.level + 1) % len(levelFilters)
+	self.refilter()
+}
+
+// nextMatch moves to the next match, or the previous one if delta is negative, wrapping around at either end
+func (self *tuiPane) nextMatch(delta int) {
+	if len(self.matches) == 0 {
+		return
+	}
+	previous := self.matches[self.match]
+	self.match = (self.match + delta + len(self.matches)) % len(self.matches)
+	self.content[previous] = self.renderLine(previous)
+	self.content[self.matches[self.match]] = self.renderLine(self.matches[self.match])
+	self.vp.SetContent(strings.Join(self.content, "\n"))
+	self.gotoMatch()
+}
+
+// gotoMatch scrolls the current match to the middle of the pane
+func (self *tuiPane) gotoMatch() {
+	self.vp.SetYOffset(self.matches[self.match] - self.vp.Height/2)
+}
+
+func (self *tuiPane) togglePause() {
+	self.paused = !self.paused
+	if !self.paused {
+		self.pending = 0
+		self.refresh(true)
+	}
+}
+
+// save writes the shown lines, without colors, to a file in dir named after the pane
+func (self *tuiPane) save(dir string) (string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.log", self.name, time.Now().Format("20060102-150405")))
+	var b strings.Builder
+	for _, idx := range self.shown {
+		b.WriteString(self.lines[idx].plain)
+		b.WriteString("\n")
+	}
+	return path, os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// describe returns the pane title, with the active filters, search and pause state
+func (self *tuiPane) describe() string {
+	result := " " + self.title
+	if self.level > 0 {
+		result += fmt.Sprintf("  [level>=%s]", self.minLevel())
+	}
+	if self.host != "" {
+		result += fmt.Sprintf("  [host~%s]", self.host)
+	}
+	if self.search != "" {
+		current := 0
+		if len(self.matches) > 0 {
+			current = self.match + 1
+		}
+		result += fmt.Sprintf("  [/%s %d/%d]", self.search, current, len(self.matches))
+	}
+	if self.paused {
+		result += fmt.Sprintf("  [PAUSED +%d]", self.pending)
+	}
+	return result + " "
+}
+
+// promptKind identifies what the text typed into the prompt is used for
+type promptKind int
+
+const (
+	promptNone promptKind = iota
+	promptSearch
+	promptHost
+)
+
+// paneKeys handles the keys which search, filter, pause and save the focused pane, including the prompt used to
+// type a search or host filter. Notices, such as where a pane was saved, are kept until the next key.
+type paneKeys struct {
+	prompt  promptKind
+	input   textinput.Model
+	saveDir string
+	notice  string
+}
+
+// handleKey returns true if the key was handled, either by the prompt or as a pane key
+func (self *paneKeys) handleKey(pane *tuiPane, msg tea.KeyMsg) (bool, tea.Cmd) {
+	self.notice = ""
+	if self.prompt != promptNone {
+		return true, self.updatePrompt(pane, msg)
+	}
+
+	switch msg.String() {
+	case "/":
+		return true, self.startPrompt(promptSearch, "/", pane.search)
+	case "H":
+		return true, self.startPrompt(promptHost, "host: ", pane.host)
+	case "n":
+		pane.nextMatch(1)
+	case "N":
+		pane.nextMatch(-1)
+	case "esc":
+		if pane.search == "" {
+			return false, nil
+		}
+		pane.setSearch("")
+	case "L":
+		pane.nextLevel()
+	case "p":
+		pane.togglePause()
+	case "w":
+		if path, err := pane.save(self.saveDir); err != nil {
+			self.notice = fmt.Sprintf("failed to save %s pane: %v", pane.name, err)
+		} else {
+			self.notice = "saved to " + path
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// startPrompt starts reading a search or host filter, starting with its current value
+func (self *paneKeys) startPrompt(kind promptKind, prompt, value string) tea.Cmd {
+	self.prompt = kind
+	self.input = textinput.New()
+	self.input.Prompt = prompt
+	self.input.SetValue(value)
+	self.input.CursorEnd()
+	return self.input.Focus()
+}
+
+// updatePrompt applies the prompt to the pane on enter, and discards it on escape
+func (self *paneKeys) updatePrompt(pane *tuiPane, msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return tea.Quit
+	case tea.KeyEsc:
+		self.prompt = promptNone
+		return nil
+	case tea.KeyEnter:
+		if self.prompt == promptSearch {
+			pane.setSearch(self.input.Value())
+		} else {
+			pane.setHost(strings.TrimSpace(self.input.Value()))
+		}
+		self.prompt = promptNone
+		return nil
+	}
+
+	var cmd tea.Cmd
+	self.input, cmd = self.input.Update(msg)
+	return cmd
+}
+
+// renderTitle renders the pane title, with the prompt or the pane keys if the pane is focused, cut off at the width
+func (self *paneKeys) renderTitle(pane *tuiPane, focused bool, width int) string {
+	title := pane.describe()
+	if !focused {
+		return paneTitleStyle.Width(width).MaxHeight(1).Render(title)
+	}
+	if self.prompt != promptNone {
+		title += " " + self.input.View()
+	} else {
+		title += " (/ search, n/N next/prev, L level, H host, p pause, w save)"
+	}
+	return focusedPaneTitleStyle.Width(width).MaxHeight(1).Render(title)
+}
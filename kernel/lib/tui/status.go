@@ -0,0 +1,304 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/openziti/fablab/kernel/model"
+)
+
+type componentState int
+
+const (
+	stateUnknown componentState = iota
+	stateRunning
+	stateStopped
+	stateError
+)
+
+var (
+	regionStyle  = lipgloss.NewStyle().Bold(true)
+	runningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("10"))
+	stoppedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	unknownStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+	errorStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("13"))
+)
+
+// Messages
+
+// statusMsg carries the result of polling every component in the model, which started at started
+type statusMsg struct {
+	regions []*statusRegion
+	started time.Time
+	polled  time.Time
+}
+
+// componentStateMsg carries a state change reported by an action starting or stopping a component
+type componentStateMsg struct {
+	pathId  string
+	running bool
+	at      time.Time
+}
+
+type statusRegion struct {
+	id    string
+	hosts []*statusHost
+}
+
+type statusHost struct {
+	id         string
+	ip         string
+	components []*statusComponent
+}
+
+type statusComponent struct {
+	id        string
+	pathId    string
+	state     componentState
+	err       error
+	changed   time.Time
+	component *model.Component
+}
+
+func (self *statusComponent) render() string {
+	switch self.state {
+	case stateRunning:
+		return runningStyle.Render("● " + self.id)
+	case stateStopped:
+		return stoppedStyle.Render("○ " + self.id)
+	case stateError:
+		return errorStyle.Render("✗ " + self.id)
+	}
+	return unknownStyle.Render("? " + self.id)
+}
+
+// statusView is the section showing the running state of each component, grouped by region and host
+type statusView struct {
+	sectionLayout
+	regions []*statusRegion
+	polled  time.Time
+}
+
+func (self *statusView) layout() *sectionLayout {
+	return &self.sectionLayout
+}
+
+// update shows the result of a poll. States reported by actions after the poll started are kept, as the poll
+// may have seen the component before the action changed it.
+func (self *statusView) update(msg statusMsg) {
+	current := map[string]*statusComponent{}
+	self.rangeComponents(func(c *statusComponent) {
+		current[c.pathId] = c
+	})
+
+	self.regions = msg.regions
+	self.polled = msg.polled
+	self.rangeComponents(func(c *statusComponent) {
+		if prev, found := current[c.pathId]; found && prev.changed.After(msg.started) {
+			c.state = prev.state
+			c.err = nil
+			c.changed = prev.changed
+		}
+	})
+}
+
+func (self *statusView) setState(pathId string, running bool, at time.Time) {
+	self.rangeComponents(func(c *statusComponent) {
+		if c.pathId == pathId {
+			c.state = stateStopped
+			if running {
+				c.state = stateRunning
+			}
+			c.err = nil
+			c.changed = at
+		}
+	})
+}
+
+func (self *statusView) rangeComponents(f func(c *statusComponent)) {
+	for _, region := range self.regions {
+		for _, host := range region.hosts {
+			for _, c := range host.components {
+				f(c)
+			}
+		}
+	}
+}
+
+func (self *statusView) title() string {
+	polled := "polling..."
+	if !self.polled.IsZero() {
+		polled = "polled " + self.polled.Format("15:04:05")
+	}
+	failed := 0
+	self.rangeComponents(func(c *statusComponent) {
+		if c.state == stateError {
+			failed++
+		}
+	})
+	if failed > 0 {
+		polled += fmt.Sprintf(", %d checks failed", failed)
+	}
+	return fmt.Sprintf(" Components (%s, s to hide) ", polled)
+}
+
+// lines renders the grid, wrapping the components of a host onto further lines when they don't fit the width.
+// Components whose state couldn't be checked are followed by the error.
+func (self *statusView) lines(width int) []string {
+	hostWidth := 0
+	for _, region := range self.regions {
+		for _, host := range region.hosts {
+			hostWidth = max(hostWidth, len(host.id)+len(host.ip)+3)
+		}
+	}
+
+	var result []string
+	for _, region := range self.regions {
+		result = append(result, regionStyle.Render(region.id))
+		for _, host := range region.hosts {
+			label := host.id
+			if host.ip != "" {
+				label += " (" + host.ip + ")"
+			}
+			prefix := "  " + label + strings.Repeat(" ", max(hostWidth-len(label), 0)) + "  "
+			line := prefix
+			lineWidth := len(prefix)
+			if len(host.components) == 0 {
+				line += unknownStyle.Render("no components")
+			}
+			for idx, c := range host.components {
+				chipWidth := len(c.id) + 4
+				if idx > 0 && lineWidth+chipWidth > width {
+					result = append(result, line)
+					line = strings.Repeat(" ", len(prefix))
+					lineWidth = len(prefix)
+				}
+				line += c.render() + "  "
+				lineWidth += chipWidth
+			}
+			result = append(result, line)
+
+			// errors don't fit in the chips, so they're shown below the host
+			for _, c := range host.components {
+				if c.state == stateError {
+					msg := fmt.Sprintf("%s%s: %v", strings.Repeat(" ", len(prefix)), c.id, c.err)
+					msg = strings.ReplaceAll(msg, "\n", " ")
+					result = append(result, errorStyle.Render(truncate(msg, width)))
+				}
+			}
+		}
+	}
+	return result
+}
+
+// stateHandler forwards component state changes from actions to the status pane
+type stateHandler struct {
+	program *tea.Program
+}
+
+func (self *stateHandler) AcceptComponentState(c *model.Component, running bool) {
+	self.program.Send(componentStateMsg{pathId: c.GetPathId(), running: running, at: time.Now()})
+}
+
+// statusPollConcurrency is the number of hosts polled at once
+const statusPollConcurrency = 25
+
+// EnableStatus shows the component status section in the TUI. Components are polled every interval using
+// Component.IsRunning, and state changes are shown as actions start and stop components. Up to
+// statusPollConcurrency hosts are polled at once. A component whose state can't be determined is shown as
+// failed, with the error below the host. Polling stops, and state changes are no longer forwarded, when
+// the TUI exits.
+func EnableStatus(p *tea.Program, run model.Run, interval time.Duration) {
+	m := run.GetModel()
+	removeHandler := m.AddStateHandler(&stateHandler{program: p})
+
+	p.Send(statusMsg{regions: statusSkeleton(m)})
+
+	exited := make(chan struct{})
+	go func() {
+		p.Wait()
+		removeHandler()
+		close(exited)
+	}()
+
+	// each poll fills in a new skeleton, as the one sent previously belongs to the TUI
+	go func() {
+		for {
+			started := time.Now()
+			regions := statusSkeleton(m)
+			pollStatus(run, regions)
+			p.Send(statusMsg{regions: regions, started: started, polled: time.Now()})
+
+			select {
+			case <-exited:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+// statusSkeleton returns the regions, hosts and components of the model, sorted by id, in the unknown state
+func statusSkeleton(m *model.Model) []*statusRegion {
+	var result []*statusRegion
+	m.RangeSortedRegions(func(id string, r *model.Region) {
+		region := &statusRegion{id: id}
+		r.RangeSortedHosts(func(id string, h *model.Host) {
+			host := &statusHost{id: id, ip: h.PublicIp}
+			h.RangeSortedComponents(func(id string, c *model.Component) {
+				host.components = append(host.components, &statusComponent{id: id, pathId: c.GetPathId(), component: c})
+			})
+			region.hosts = append(region.hosts, host)
+		})
+		result = append(result, region)
+	})
+	return result
+}
+
+func pollStatus(run model.Run, regions []*statusRegion) {
+	slots := make(chan struct{}, statusPollConcurrency)
+	var wg sync.WaitGroup
+	for _, region := range regions {
+		for _, host := range region.hosts {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				for _, c := range host.components {
+					if running, err := c.component.IsRunning(run); err != nil {
+						c.state = stateError
+						c.err = err
+					} else if running {
+						c.state = stateRunning
+					} else {
+						c.state = stateStopped
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
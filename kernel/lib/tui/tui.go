@@ -22,7 +22,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/sirupsen/logrus"
@@ -31,18 +30,25 @@ import (
 // Messages
 
 type logLineMsg struct {
-	pane string
-	text string
+	pane  string
+	text  string
+	level logrus.Level
+	host  string
 }
 
 type iterationMsg struct {
-	num int
+	worker string
+	num    int
 }
 
 type execDoneMsg struct {
 	err error
 }
 
+type saveDirMsg struct {
+	dir string
+}
+
 type tickMsg time.Time
 
 // Styles
@@ -72,37 +78,52 @@ var (
 			Foreground(lipgloss.Color("9"))
 )
 
-const (
-	focusActions    = 0
-	focusValidation = 1
-)
-
 // Model
 
+// workerStatus tracks the iteration of a concurrent exec-loop worker, shown in the status bar
+type workerStatus struct {
+	name      string
+	iteration int
+}
+
 type tuiModel struct {
-	iteration    int
-	totalStart   time.Time
-	iterStart    time.Time
-	actionsVP    viewport.Model
-	validationVP viewport.Model
-	actionsLines []string
-	validLines   []string
-	width        int
-	height       int
-	focus        int
-	done         bool
-	doneAt       time.Time
-	err          error
+	iteration  int
+	totalStart time.Time
+	iterStart  time.Time
+	panes      []*tuiPane
+	workers    []*workerStatus
+	status     *statusView
+	metrics    *metricsView
+	width      int
+	height     int
+	focus      int
+	done       bool
+	doneAt     time.Time
+	err        error
+	paneKeys
 }
 
-func newTuiModel() *tuiModel {
+func newTuiModel(panes []*tuiPane, workers []*workerStatus) *tuiModel {
 	return &tuiModel{
 		totalStart: time.Now(),
 		iterStart:  time.Now(),
-		focus:      focusActions,
+		panes:      panes,
+		workers:    workers,
+		status:     &statusView{},
+		metrics:    newMetricsView(),
+		paneKeys:   paneKeys{saveDir: "."},
 	}
 }
 
+func (m *tuiModel) getPane(name string) *tuiPane {
+	for _, pane := range m.panes {
+		if pane.name == name {
+			return pane
+		}
+	}
+	return m.panes[0]
+}
+
 func (m *tuiModel) Init() tea.Cmd {
 	return tickCmd()
 }
@@ -118,33 +139,41 @@ func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		focused := m.panes[m.focus]
+		if handled, cmd := m.handleKey(focused, msg); handled {
+			return m, cmd
+		}
+
 		switch msg.String() {
 		case "q", "ctrl+c":
 			return m, tea.Quit
 		case "tab":
-			m.focus = (m.focus + 1) % 2
+			m.focus = (m.focus + 1) % len(m.panes)
+			return m, nil
+		case "s":
+			m.toggleSection(m.status)
+			return m, nil
+		case "m":
+			m.toggleSection(m.metrics)
+			return m, nil
+		case "o":
+			m.metrics.nextOrder()
 			return m, nil
 		}
 
 		// Forward key events to the focused viewport.
-		if m.focus == focusActions {
-			var cmd tea.Cmd
-			m.actionsVP, cmd = m.actionsVP.Update(msg)
-			cmds = append(cmds, cmd)
-		} else {
-			var cmd tea.Cmd
-			m.validationVP, cmd = m.validationVP.Update(msg)
-			cmds = append(cmds, cmd)
-		}
+		var cmd tea.Cmd
+		focused.vp, cmd = focused.vp.Update(msg)
+		cmds = append(cmds, cmd)
 
 	case tea.MouseMsg:
-		// Forward mouse events to both viewports; each viewport checks
+		// Forward mouse events to all viewports; each viewport checks
 		// whether the event falls within its bounds using YPosition.
-		var cmd tea.Cmd
-		m.actionsVP, cmd = m.actionsVP.Update(msg)
-		cmds = append(cmds, cmd)
-		m.validationVP, cmd = m.validationVP.Update(msg)
-		cmds = append(cmds, cmd)
+		for _, pane := range m.panes {
+			var cmd tea.Cmd
+			pane.vp, cmd = pane.vp.Update(msg)
+			cmds = append(cmds, cmd)
+		}
 
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
@@ -152,25 +181,32 @@ func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.recalcLayout()
 
 	case logLineMsg:
-		if msg.pane == PaneValidation {
-			m.validLines = append(m.validLines, msg.text)
-			wasAtBottom := m.validationVP.AtBottom()
-			m.validationVP.SetContent(strings.Join(m.validLines, "\n"))
-			if wasAtBottom {
-				m.validationVP.GotoBottom()
-			}
-		} else {
-			m.actionsLines = append(m.actionsLines, msg.text)
-			wasAtBottom := m.actionsVP.AtBottom()
-			m.actionsVP.SetContent(strings.Join(m.actionsLines, "\n"))
-			if wasAtBottom {
-				m.actionsVP.GotoBottom()
+		m.getPane(msg.pane).add(newLogLine(msg.text, msg.level, msg.host))
+
+	case saveDirMsg:
+		m.saveDir = msg.dir
+
+	case iterationMsg:
+		if msg.worker == "" {
+			m.iteration = msg.num
+			m.iterStart = time.Now()
+		}
+		for _, worker := range m.workers {
+			if worker.name == msg.worker {
+				worker.iteration = msg.num
 			}
 		}
 
-	case iterationMsg:
-		m.iteration = msg.num
-		m.iterStart = time.Now()
+	case statusMsg:
+		m.status.update(msg)
+		m.showSection(m.status)
+
+	case hostMetricsMsg:
+		m.metrics.update(msg)
+		m.showSection(m.metrics)
+
+	case componentStateMsg:
+		m.status.setState(msg.pathId, msg.running, msg.at)
 
 	case execDoneMsg:
 		m.done = true
@@ -184,26 +220,100 @@ func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// A tuiSection is a panel shown between the status bar and the log panes, which can be toggled with a key.
+// Sections aren't scrollable, so they aren't part of the focus cycle.
+type tuiSection interface {
+	title() string
+	lines(width int) []string
+	layout() *sectionLayout
+}
+
+type sectionLayout struct {
+	enabled bool // set once the section has content to show
+	visible bool
+	height  int
+}
+
+func (m *tuiModel) sections() []tuiSection {
+	return []tuiSection{m.status, m.metrics}
+}
+
+func (m *tuiModel) toggleSection(section tuiSection) {
+	if layout := section.layout(); layout.enabled {
+		layout.visible = !layout.visible
+		m.recalcLayout()
+	}
+}
+
+// showSection makes the section visible when it first has content, and recalculates the layout if any section
+// now needs a different height
+func (m *tuiModel) showSection(section tuiSection) {
+	if layout := section.layout(); !layout.enabled {
+		layout.enabled = true
+		layout.visible = true
+	}
+	for _, s := range m.sections() {
+		if m.sectionHeight(s) != s.layout().height {
+			m.recalcLayout()
+			return
+		}
+	}
+}
+
+// sectionHeight returns the number of lines used by the body of a section. Together, visible sections take at
+// most half of the screen.
+func (m *tuiModel) sectionHeight(section tuiSection) int {
+	if !section.layout().visible {
+		return 0
+	}
+	visible := 0
+	for _, s := range m.sections() {
+		if s.layout().visible {
+			visible++
+		}
+	}
+	return min(len(section.lines(m.width)), max(m.height/(2*visible+1), 2))
+}
+
+// renderSection renders the body of a section into its height, replacing rows which don't fit with a count of
+// the hidden rows
+func (m *tuiModel) renderSection(section tuiSection) string {
+	lines := section.lines(m.width)
+	if height := section.layout().height; len(lines) > height && height > 0 {
+		hidden := len(lines) - height + 1
+		lines = append(lines[:height-1], unknownStyle.Render(fmt.Sprintf("… %d more rows", hidden)))
+	}
+	return strings.Join(lines, "\n")
+}
+
 func (m *tuiModel) recalcLayout() {
-	// Layout: 1 line status bar + 1 line actions title + actions viewport + 1 line validation title + validation viewport
-	// Total overhead = 3 lines (status bar + 2 pane titles)
-	overhead := 3
-	remaining := max(m.height-overhead, 2)
-	actionsHeight := remaining / 2
-	validationHeight := remaining - actionsHeight
-
-	m.actionsVP.Width = m.width
-	m.actionsVP.Height = actionsHeight
-	m.actionsVP.YPosition = 2 // after status bar + actions title
-	m.validationVP.Width = m.width
-	m.validationVP.Height = validationHeight
-	m.validationVP.YPosition = 2 + actionsHeight + 1 // after actions viewport + validation title
-
-	// Re-set content to recalculate line wrapping.
-	m.actionsVP.SetContent(strings.Join(m.actionsLines, "\n"))
-	m.actionsVP.GotoBottom()
-	m.validationVP.SetContent(strings.Join(m.validLines, "\n"))
-	m.validationVP.GotoBottom()
+	// Layout: 1 line status bar, then each visible section with a 1 line title, then for each pane a 1 line title
+	// followed by the pane viewport. The panes share the remaining height, with any remainder going to the last pane.
+	overhead := 1 + len(m.panes)
+	for _, section := range m.sections() {
+		layout := section.layout()
+		layout.height = m.sectionHeight(section)
+		if layout.visible {
+			overhead += 1 + layout.height
+		}
+	}
+	remaining := max(m.height-overhead, len(m.panes))
+	paneHeight := remaining / len(m.panes)
+
+	y := overhead - len(m.panes) // after status bar and sections
+	for idx, pane := range m.panes {
+		height := paneHeight
+		if idx == len(m.panes)-1 {
+			height = remaining - paneHeight*(len(m.panes)-1)
+		}
+		pane.vp.Width = m.width
+		pane.vp.Height = height
+		pane.vp.YPosition = y + 1 // after pane title
+		y += height + 1
+
+		// Re-set content to recalculate line wrapping.
+		pane.refresh(true)
+	}
 }
 
 func (m *tuiModel) View() string {
@@ -218,10 +328,19 @@ func (m *tuiModel) View() string {
 	if m.done {
 		now = m.doneAt
 	}
-	iterStr := fmt.Sprintf(" Iteration: %03d", m.iteration)
 	totalStr := fmt.Sprintf("Total: %s", formatDuration(now.Sub(m.totalStart)))
-	iterTimeStr := fmt.Sprintf("Iter: %s", formatDuration(now.Sub(m.iterStart)))
-	leftContent := fmt.Sprintf("%s  |  %s  |  %s", iterStr, totalStr, iterTimeStr)
+	var leftContent string
+	if len(m.workers) > 0 {
+		var workerStrs []string
+		for _, worker := range m.workers {
+			workerStrs = append(workerStrs, fmt.Sprintf("%s: %03d", worker.name, worker.iteration))
+		}
+		leftContent = fmt.Sprintf(" %s  |  %s", strings.Join(workerStrs, "  "), totalStr)
+	} else {
+		iterStr := fmt.Sprintf(" Iteration: %03d", m.iteration)
+		iterTimeStr := fmt.Sprintf("Iter: %s", formatDuration(now.Sub(m.iterStart)))
+		leftContent = fmt.Sprintf("%s  |  %s  |  %s", iterStr, totalStr, iterTimeStr)
+	}
 
 	var statusRight string
 	if m.done {
@@ -239,6 +358,12 @@ func (m *tuiModel) View() string {
 		} else {
 			statusRight = doneSuccessStyle.Render("  COMPLETED — press q to exit ")
 		}
+	} else if m.notice != "" {
+		notice := "  " + m.notice
+		if avail := m.width - lipgloss.Width(leftContent); len(notice) > avail {
+			notice = notice[:max(avail-1, 0)] + "…"
+		}
+		statusRight = notice
 	}
 
 	statusContent := leftContent + statusRight
@@ -246,30 +371,27 @@ func (m *tuiModel) View() string {
 	b.WriteString(statusBar)
 	b.WriteString("\n")
 
-	// Actions pane title
-	actionsTitle := " Actions "
-	if m.focus == focusActions {
-		b.WriteString(focusedPaneTitleStyle.Width(m.width).Render(actionsTitle))
-	} else {
-		b.WriteString(paneTitleStyle.Width(m.width).Render(actionsTitle))
+	for _, section := range m.sections() {
+		if section.layout().visible {
+			b.WriteString(paneTitleStyle.Width(m.width).Render(section.title()))
+			b.WriteString("\n")
+			b.WriteString(m.renderSection(section))
+			b.WriteString("\n")
+		}
 	}
-	b.WriteString("\n")
 
-	// Actions viewport
-	b.WriteString(m.actionsVP.View())
-	b.WriteString("\n")
+	for idx, pane := range m.panes {
+		if idx > 0 {
+			b.WriteString("\n")
+		}
 
-	// Validation pane title
-	validTitle := " Validation "
-	if m.focus == focusValidation {
-		b.WriteString(focusedPaneTitleStyle.Width(m.width).Render(validTitle))
-	} else {
-		b.WriteString(paneTitleStyle.Width(m.width).Render(validTitle))
-	}
-	b.WriteString("\n")
+		// Pane title
+		b.WriteString(m.renderTitle(pane, m.focus == idx, m.width))
+		b.WriteString("\n")
 
-	// Validation viewport
-	b.WriteString(m.validationVP.View())
+		// Pane viewport
+		b.WriteString(pane.vp.View())
+	}
 
 	return b.String()
 }
@@ -291,9 +413,29 @@ func formatDuration(d time.Duration) string {
 // The logrus hook is installed automatically.
 // When the TUI exits, active is set to false and logrus output is restored.
 func RunTUI() (*tea.Program, error) {
+	panes := []*tuiPane{
+		{name: PaneActions, title: "Actions"},
+		{name: PaneValidation, title: "Validation"},
+	}
+	return runTUI(newTuiModel(panes, nil))
+}
+
+// RunWorkersTUI starts the TUI for concurrent exec-loop workers. Action output goes to the actions pane, and each
+// worker has its own pane, named after the worker, for its iteration progress and failures. The status bar shows
+// the current iteration of each worker.
+func RunWorkersTUI(workers ...string) (*tea.Program, error) {
+	panes := []*tuiPane{{name: PaneActions, title: "Actions"}}
+	var statuses []*workerStatus
+	for _, worker := range workers {
+		panes = append(panes, &tuiPane{name: worker, title: "Worker: " + worker})
+		statuses = append(statuses, &workerStatus{name: worker})
+	}
+	return runTUI(newTuiModel(panes, statuses))
+}
+
+func runTUI(model *tuiModel) (*tea.Program, error) {
 	active.Store(true)
 
-	model := newTuiModel()
 	p := tea.NewProgram(model, tea.WithAltScreen(), tea.WithMouseCellMotion())
 
 	hook := newLogHook(p)
@@ -325,6 +467,16 @@ func SendIteration(p *tea.Program, num int) {
 	p.Send(iterationMsg{num: num})
 }
 
+// SendWorkerIteration sends an iteration update for a worker started with RunWorkersTUI to the TUI program.
+func SendWorkerIteration(p *tea.Program, worker string, num int) {
+	p.Send(iterationMsg{worker: worker, num: num})
+}
+
+// SetSaveDir sets the directory panes are saved to. By default, panes are saved to the working directory.
+func SetSaveDir(p *tea.Program, dir string) {
+	p.Send(saveDirMsg{dir: dir})
+}
+
 // SendDone signals the TUI that the exec loop has finished.
 func SendDone(p *tea.Program, err error) {
 	p.Send(execDoneMsg{err: err})
@@ -21,3 +21,7 @@ func (e MultipleErrors) Error() string {
 	}
 	return buf.String()
 }
+
+func (e MultipleErrors) Unwrap() []error {
+	return e
+}
@@ -0,0 +1,225 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package libssh
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// KnownHosts verifies host keys against a known_hosts file, trusting a host's key on first use. The key presented
+// on the first successful connection to an address is recorded, and later connections fail with a
+// HostKeyMismatchError if the host presents a different key. The file uses the OpenSSH format, so it can also be
+// given to the ssh client with UserKnownHostsFile.
+//
+// The HostKeyCallback runs before authentication, so it doesn't record keys. Keys are recorded with Record once
+// the connection has been established, which the ClientPool does for factories with known hosts.
+type KnownHosts struct {
+	path string
+	lock sync.Mutex
+}
+
+func NewKnownHosts(path string) *KnownHosts {
+	return &KnownHosts{path: path}
+}
+
+func (self *KnownHosts) Path() string {
+	return self.path
+}
+
+// HostKeyCallback returns a callback which verifies host keys. Keys of unknown hosts are accepted, but not
+// recorded until Record is called.
+func (self *KnownHosts) HostKeyCallback() ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		_, err := self.check(hostname, remote, key)
+		return err
+	}
+}
+
+// Record records the key presented by an unknown host, once a connection to it has been established. Nothing is
+// recorded if the host's key is already known. A HostKeyMismatchError is returned if a different key was
+// recorded in the meantime.
+func (self *KnownHosts) Record(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	known, err := self.check(hostname, remote, key)
+	if err != nil || known {
+		return err
+	}
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	f, err := os.OpenFile(self.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "unable to open known hosts [%s]", self.path)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err = f.WriteString(line + "\n"); err != nil {
+		return errors.Wrapf(err, "unable to record host key in [%s]", self.path)
+	}
+	logrus.WithField("addr", hostname).Infof("recorded %s host key %s", key.Type(), ssh.FingerprintSHA256(key))
+	return nil
+}
+
+// SshOptions returns the options which make the ssh client use the known_hosts file in the same way
+func (self *KnownHosts) SshOptions() []string {
+	return []string{
+		"-o", "UserKnownHostsFile=" + self.path,
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", "HashKnownHosts=no",
+	}
+}
+
+// Algorithms returns the algorithms of the keys recorded for address, so that the host is asked for a key which
+// can be verified. Returns nil for unknown hosts.
+func (self *KnownHosts) Algorithms(address string) []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var result []string
+	_ = self.rangeLines(func(_ []byte, hosts []string, key ssh.PublicKey) {
+		if matchesHost(hosts, address) {
+			if key.Type() == ssh.KeyAlgoRSA {
+				result = append(result, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+			}
+			result = append(result, key.Type())
+		}
+	})
+	return result
+}
+
+// Remove removes the keys recorded for the addresses, returning the number of keys removed
+func (self *KnownHosts) Remove(addresses ...string) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	removed := 0
+	var kept bytes.Buffer
+	err := self.rangeLines(func(line []byte, hosts []string, _ ssh.PublicKey) {
+		for _, address := range addresses {
+			if matchesHost(hosts, address) {
+				removed++
+				return
+			}
+		}
+		kept.Write(line)
+		kept.WriteByte('\n')
+	})
+	if err != nil || removed == 0 {
+		return 0, err
+	}
+	if err = os.WriteFile(self.path, kept.Bytes(), 0600); err != nil {
+		return 0, errors.Wrapf(err, "unable to update known hosts [%s]", self.path)
+	}
+	return removed, nil
+}
+
+// check verifies the key against the recorded keys, returning whether the host is known. Must be called with
+// the lock held.
+func (self *KnownHosts) check(hostname string, remote net.Addr, key ssh.PublicKey) (bool, error) {
+	if err := self.ensureFile(); err != nil {
+		return false, err
+	}
+
+	callback, err := knownhosts.New(self.path)
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to read known hosts [%s]", self.path)
+	}
+
+	err = callback(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return err == nil, err
+	}
+	if len(keyErr.Want) > 0 {
+		return true, &HostKeyMismatchError{Address: hostname, Key: key, Want: keyErr.Want}
+	}
+	return false, nil
+}
+
+func (self *KnownHosts) ensureFile() error {
+	if _, err := os.Stat(self.path); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(self.path), 0700); err != nil {
+		return errors.Wrapf(err, "unable to create directory for known hosts [%s]", self.path)
+	}
+	return os.WriteFile(self.path, nil, 0600)
+}
+
+// rangeLines calls f with each line of the file, and its hosts and key. Hosts and key are nil for comments, markers
+// and lines which can't be parsed.
+func (self *KnownHosts) rangeLines(f func(line []byte, hosts []string, key ssh.PublicKey)) error {
+	content, err := os.ReadFile(self.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to read known hosts [%s]", self.path)
+	}
+
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		marker, hosts, key, _, _, err := ssh.ParseKnownHosts(line)
+		if err != nil || marker != "" {
+			hosts, key = nil, nil
+		}
+		f(line, hosts, key)
+	}
+	return nil
+}
+
+func matchesHost(hosts []string, address string) bool {
+	normalized := knownhosts.Normalize(address)
+	for _, host := range hosts {
+		if host == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+// HostKeyMismatchError is returned when a host presents a key other than the one recorded for it
+type HostKeyMismatchError struct {
+	Address string
+	Key     ssh.PublicKey
+	Want    []knownhosts.KnownKey
+}
+
+func (self *HostKeyMismatchError) Error() string {
+	var recorded []string
+	for _, want := range self.Want {
+		recorded = append(recorded, fmt.Sprintf("%s %s (%s:%d)", want.Key.Type(), ssh.FingerprintSHA256(want.Key), want.Filename, want.Line))
+	}
+	return fmt.Sprintf("host key mismatch for [%s]: host presented %s %s, but recorded key is %s. "+
+		"if the host was replaced, run 'fablab hostkeys reset' for it",
+		self.Address, self.Key.Type(), ssh.FingerprintSHA256(self.Key), strings.Join(recorded, ", "))
+}
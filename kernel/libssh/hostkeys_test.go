@@ -0,0 +1,94 @@
+package libssh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKnownHostsTrustOnFirstUse(t *testing.T) {
+	server := newTestServer(t)
+	pool := newTestPool(t)
+	knownHosts := NewKnownHosts(filepath.Join(t.TempDir(), "instance", "known_hosts"))
+	factory := &testFactory{address: server.listener.Addr().String(), knownHosts: knownHosts}
+
+	require.NoError(t, pool.Get(factory).Exec(&SyncBuffer{}, "first"))
+	content, err := os.ReadFile(knownHosts.Path())
+	require.NoError(t, err)
+	require.Contains(t, string(content), "["+factory.Hostname()+"]:")
+	require.Equal(t, []string{"ssh-ed25519"}, knownHosts.Algorithms(factory.address))
+
+	// the same key is accepted on later connections
+	pool.Close()
+	require.NoError(t, pool.Get(factory).Exec(&SyncBuffer{}, "second"))
+
+	// a replaced host is refused
+	server.rekey(t)
+	pool.Close()
+	err = pool.Get(factory).Exec(&SyncBuffer{}, "third")
+	var mismatch *HostKeyMismatchError
+	require.True(t, errors.As(err, &mismatch), "unexpected error: %v", err)
+	require.Contains(t, err.Error(), "fablab hostkeys reset")
+
+	// until its key is reset
+	removed, err := knownHosts.Remove(factory.address)
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	require.NoError(t, pool.Get(factory).Exec(&SyncBuffer{}, "fourth"))
+}
+
+func TestKnownHostsRecordOnlyAuthenticatedConnections(t *testing.T) {
+	server := newTestServer(t)
+	pool := newTestPool(t)
+	knownHosts := NewKnownHosts(filepath.Join(t.TempDir(), "known_hosts"))
+	factory := &testFactory{address: server.listener.Addr().String(), knownHosts: knownHosts}
+
+	server.setRefuseAuth(true)
+	require.Error(t, pool.Get(factory).Exec(&SyncBuffer{}, "refused"))
+	require.Nil(t, knownHosts.Algorithms(factory.address))
+
+	// a host replaced after a failed connection isn't refused, as its first key was never trusted
+	server.rekey(t)
+	server.setRefuseAuth(false)
+	require.NoError(t, pool.Get(factory).Exec(&SyncBuffer{}, "accepted"))
+	require.Equal(t, []string{"ssh-ed25519"}, knownHosts.Algorithms(factory.address))
+}
+
+func TestKnownHostsRemoveKeepsOtherHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	content := "# comment\n" +
+		"10.0.0.1 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIE9Ww8IYjSc+N4lnRZrsQ/4e6TpmOsnZJc+ugPJQMTfv\n" +
+		"10.0.0.2 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIE9Ww8IYjSc+N4lnRZrsQ/4e6TpmOsnZJc+ugPJQMTfv\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	knownHosts := NewKnownHosts(path)
+	removed, err := knownHosts.Remove("10.0.0.1:22")
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+
+	result, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "# comment\n10.0.0.2 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIE9Ww8IYjSc+N4lnRZrsQ/4e6TpmOsnZJc+ugPJQMTfv\n", string(result))
+	require.Nil(t, knownHosts.Algorithms("10.0.0.1:22"))
+}
+
+func TestKnownHostsRecordJumpedHostsUnderAlias(t *testing.T) {
+	target := newTestServer(t)
+	jump := newTestServer(t)
+	pool := newTestPool(t)
+	knownHosts := NewKnownHosts(filepath.Join(t.TempDir(), "known_hosts"))
+
+	jumpFactory := &testFactory{address: jump.listener.Addr().String(), knownHosts: knownHosts}
+	factory := &testFactory{address: target.listener.Addr().String(), jumpHost: jumpFactory, knownHosts: knownHosts}
+	require.NoError(t, pool.Get(factory).Exec(&SyncBuffer{}, "hello"))
+
+	content, err := os.ReadFile(knownHosts.Path())
+	require.NoError(t, err)
+	require.Contains(t, string(content), "\n"+HostKeyAlias(factory)+" ssh-ed25519 ")
+	require.Contains(t, string(content), "["+jumpFactory.Hostname()+"]:")
+	require.Nil(t, knownHosts.Algorithms(factory.address))
+	require.Equal(t, []string{"ssh-ed25519"}, knownHosts.Algorithms(HostKeyAddress(factory)))
+}
@@ -0,0 +1,459 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package libssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultPool is the pool used by the functions in this package and by model.Host
+var DefaultPool = NewClientPool()
+
+// GetClient returns the pooled client from the DefaultPool for the user and address of the factory
+func GetClient(factory SshConfigFactory) *PooledClient {
+	return DefaultPool.Get(factory)
+}
+
+// ClientPool keeps a single ssh connection per user, address and jump host, so that operations against a host share a
+// connection instead of dialing for each one. Large models would otherwise trip the MaxStartups limit of sshd.
+//
+// Connections are dialed on first use and checked with keepalives. A connection which fails a keepalive, or which
+// breaks while opening a session, is closed and dialed again on next use. Connections without sessions are closed
+// once they have been idle for the IdleTimeout. At most MaxSessions sessions are open on a connection at once,
+// further operations wait for a session to close, as sshd refuses sessions beyond its MaxSessions (10 by default).
+type ClientPool struct {
+	DialTimeout       time.Duration
+	KeepaliveInterval time.Duration
+	KeepaliveTimeout  time.Duration
+	IdleTimeout       time.Duration
+	MaxSessions       int
+
+	lock    sync.Mutex
+	clients map[string]*PooledClient
+}
+
+func NewClientPool() *ClientPool {
+	return &ClientPool{
+		DialTimeout:       10 * time.Second,
+		KeepaliveInterval: 30 * time.Second,
+		KeepaliveTimeout:  15 * time.Second,
+		IdleTimeout:       5 * time.Minute,
+		MaxSessions:       8,
+		clients:           map[string]*PooledClient{},
+	}
+}
+
+// Get returns the pooled client for the user and address of the factory, and the jump hosts it's reached through.
+// The first factory given for them is used to dial all connections for it.
+func (self *ClientPool) Get(factory SshConfigFactory) *PooledClient {
+	key := poolKey(factory)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	client, found := self.clients[key]
+	if !found {
+		client = &PooledClient{
+			pool:     self,
+			factory:  factory,
+			sessions: make(chan struct{}, max(self.MaxSessions, 1)),
+		}
+		self.clients[key] = client
+	}
+	return client
+}
+
+// Close closes every pooled connection. The pool can still be used afterward, connections are dialed again as
+// needed.
+func (self *ClientPool) Close() {
+	self.lock.Lock()
+	var clients []*PooledClient
+	for _, client := range self.clients {
+		clients = append(clients, client)
+	}
+	self.lock.Unlock()
+
+	for _, client := range clients {
+		client.Close()
+	}
+}
+
+// PooledClient is the pooled connection for a user, address and jump host
+type PooledClient struct {
+	pool     *ClientPool
+	factory  SshConfigFactory
+	sessions chan struct{}
+
+	lock     sync.Mutex
+	client   *ssh.Client
+	dialing  *pendingDial
+	active   int
+	lastUsed time.Time
+}
+
+func (self *PooledClient) Address() string {
+	return self.factory.Address()
+}
+
+// Exec runs cmd in a new session, writing stdout and stderr to out
+func (self *PooledClient) Exec(out io.Writer, cmd string) error {
+	return self.ExecContext(context.Background(), out, cmd)
+}
+
+// ExecContext runs cmd like Exec, giving up once ctx is done
+func (self *PooledClient) ExecContext(ctx context.Context, out io.Writer, cmd string) error {
+	return self.WithSessionContext(ctx, func(session *ssh.Session) error {
+		session.Stdout = out
+		session.Stderr = out
+		return session.Run(cmd)
+	})
+}
+
+// WithSession calls f with a new session, which is closed once f returns. This waits while the connection has
+// MaxSessions sessions open.
+func (self *PooledClient) WithSession(f func(session *ssh.Session) error) error {
+	return self.WithSessionContext(context.Background(), f)
+}
+
+// WithSessionContext calls f with a new session like WithSession, giving up once ctx is done. If ctx is done while
+// waiting for a session, the ctx error is returned. If it's done while f runs, the session is closed, so that f
+// fails instead of holding the session indefinitely, and the ctx error is returned.
+func (self *PooledClient) WithSessionContext(ctx context.Context, f func(session *ssh.Session) error) error {
+	if err := self.acquire(ctx); err != nil {
+		return fmt.Errorf("no ssh session available [%s] (%w)", self.Address(), err)
+	}
+	defer self.release()
+
+	var session *ssh.Session
+	err := self.open(func(client *ssh.Client) (err error) {
+		session, err = client.NewSession()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error creating ssh session [%s] (%w)", self.Address(), err)
+	}
+	defer func() { _ = session.Close() }()
+
+	stop := context.AfterFunc(ctx, func() { _ = session.Close() })
+	defer stop()
+
+	if err = f(session); err != nil && ctx.Err() != nil {
+		return fmt.Errorf("ssh session closed [%s] (%w)", self.Address(), ctx.Err())
+	}
+	return err
+}
+
+// WithSftp calls f with a new sftp client, which is closed once f returns. The sftp client uses a session, so
+// this waits while the connection has MaxSessions sessions open.
+func (self *PooledClient) WithSftp(f func(client *sftp.Client) error) error {
+	_ = self.acquire(context.Background())
+	defer self.release()
+
+	var sftpClient *sftp.Client
+	err := self.open(func(client *ssh.Client) (err error) {
+		sftpClient, err = sftp.NewClient(client)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error creating sftp client [%s] (%w)", self.Address(), err)
+	}
+	defer func() { _ = sftpClient.Close() }()
+
+	return f(sftpClient)
+}
+
+// Close closes the connection, if connected. Sessions open on it fail.
+func (self *PooledClient) Close() {
+	self.lock.Lock()
+	client := self.client
+	self.lock.Unlock()
+
+	if client != nil {
+		self.drop(client)
+	}
+}
+
+// acquire waits for one of the MaxSessions session slots, or until ctx is done
+func (self *PooledClient) acquire(ctx context.Context) error {
+	select {
+	case self.sessions <- struct{}{}:
+		self.busy(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (self *PooledClient) release() {
+	self.busy(-1)
+	<-self.sessions
+}
+
+// busy tracks sessions and tunnels using the connection, which keep it from expiring
+func (self *PooledClient) busy(delta int) {
+	self.lock.Lock()
+	self.active += delta
+	self.lastUsed = time.Now()
+	self.lock.Unlock()
+}
+
+// open calls f with the connection. If f fails because the connection has broken, the connection is dialed again
+// and f is retried once.
+func (self *PooledClient) open(f func(client *ssh.Client) error) error {
+	for attempt := 0; ; attempt++ {
+		client, err := self.connect()
+		if err != nil {
+			return err
+		}
+		if err = f(client); err == nil || attempt > 0 || self.alive(client) {
+			return err
+		}
+		logrus.WithField("addr", self.Address()).Warnf("ssh connection broken, reconnecting (%v)", err)
+		self.drop(client)
+	}
+}
+
+// connect returns the connection, dialing it if not connected. The dial happens outside the lock, so a host which
+// is slow to answer doesn't block operations which only need the lock, such as releasing sessions. Callers which
+// need the connection while it's being dialed share the result of that dial.
+func (self *PooledClient) connect() (*ssh.Client, error) {
+	self.lock.Lock()
+	if self.client != nil {
+		client := self.client
+		self.lock.Unlock()
+		return client, nil
+	}
+	if pending := self.dialing; pending != nil {
+		self.lock.Unlock()
+		<-pending.done
+		return pending.client, pending.err
+	}
+	pending := &pendingDial{done: make(chan struct{})}
+	self.dialing = pending
+	self.lock.Unlock()
+
+	config := self.factory.Config()
+	if config.Timeout == 0 {
+		config.Timeout = self.pool.DialTimeout
+	}
+	hostKey := &presentedKey{}
+	if verify := config.HostKeyCallback; verify != nil {
+		config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			*hostKey = presentedKey{hostname: hostname, remote: remote, key: key}
+			return verify(hostname, remote, key)
+		}
+	}
+	client, err := self.dial(config)
+	if err == nil {
+		err = self.recordHostKey(hostKey)
+		if err != nil {
+			_ = client.Close()
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("error dialing ssh server [%s] (%w)", self.Address(), err)
+	}
+
+	self.lock.Lock()
+	self.dialing = nil
+	if err == nil {
+		self.client = client
+		self.lastUsed = time.Now()
+	}
+	self.lock.Unlock()
+
+	pending.client, pending.err = client, err
+	close(pending.done)
+	if err != nil {
+		return nil, err
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		self.drop(client)
+		close(closed)
+	}()
+	go self.monitor(client, closed)
+
+	return client, nil
+}
+
+// presentedKey is the host key presented while dialing
+type presentedKey struct {
+	hostname string
+	remote   net.Addr
+	key      ssh.PublicKey
+}
+
+// recordHostKey records the key the host presented, if the factory verifies known hosts. This happens once the
+// connection has been established, so that keys aren't recorded for connections which fail to authenticate.
+func (self *PooledClient) recordHostKey(hostKey *presentedKey) error {
+	hosts := knownHosts(self.factory)
+	if hosts == nil || hostKey.key == nil {
+		return nil
+	}
+	return hosts.Record(hostKey.hostname, hostKey.remote, hostKey.key)
+}
+
+// pendingDial is the result of a dial in progress, which is available once done is closed
+type pendingDial struct {
+	done   chan struct{}
+	client *ssh.Client
+	err    error
+}
+
+// dial connects to the host, tunneling through the pooled connection to the jump host if the factory has one. Jump
+// hosts with jump hosts of their own are tunneled through in turn.
+func (self *PooledClient) dial(config *ssh.ClientConfig) (*ssh.Client, error) {
+	jumpFactory := jumpHost(self.factory)
+	if jumpFactory == nil {
+		conn, err := net.DialTimeout("tcp", self.Address(), config.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		return self.handshake(conn, config)
+	}
+
+	jump := self.pool.Get(jumpFactory)
+	jump.busy(1)
+
+	var conn net.Conn
+	err := jump.open(func(client *ssh.Client) (err error) {
+		conn, err = client.Dial("tcp", self.Address())
+		return err
+	})
+	if err != nil {
+		jump.busy(-1)
+		return nil, fmt.Errorf("unable to tunnel through jump host [%s] (%w)", jump.Address(), err)
+	}
+
+	client, err := self.handshake(conn, config)
+	if err != nil {
+		jump.busy(-1)
+		return nil, fmt.Errorf("unable to connect through jump host [%s] (%w)", jump.Address(), err)
+	}
+	go func() {
+		_ = client.Wait()
+		jump.busy(-1)
+	}()
+	return client, nil
+}
+
+// handshake establishes the ssh connection over conn, closing conn if the handshake doesn't complete within the
+// config timeout. The timeout of the config otherwise only limits connecting.
+func (self *PooledClient) handshake(conn net.Conn, config *ssh.ClientConfig) (*ssh.Client, error) {
+	type result struct {
+		conn     ssh.Conn
+		channels <-chan ssh.NewChannel
+		requests <-chan *ssh.Request
+		err      error
+	}
+	resultC := make(chan result, 1)
+	go func() {
+		var r result
+		r.conn, r.channels, r.requests, r.err = ssh.NewClientConn(conn, HostKeyAddress(self.factory), config)
+		resultC <- r
+	}()
+
+	timer := time.NewTimer(config.Timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-resultC:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return ssh.NewClient(r.conn, r.channels, r.requests), nil
+	case <-timer.C:
+		_ = conn.Close()
+		return nil, fmt.Errorf("ssh handshake timed out after %v", config.Timeout)
+	}
+}
+
+// drop closes the connection, and forgets it if it's still the current connection
+func (self *PooledClient) drop(client *ssh.Client) {
+	self.lock.Lock()
+	if self.client == client {
+		self.client = nil
+	}
+	self.lock.Unlock()
+	_ = client.Close()
+}
+
+// monitor sends keepalives on the connection and closes it once it fails a keepalive or expires
+func (self *PooledClient) monitor(client *ssh.Client, closed chan struct{}) {
+	ticker := time.NewTicker(self.pool.KeepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			if self.expire(client) {
+				logrus.WithField("addr", self.Address()).Debug("closed idle ssh connection")
+				return
+			}
+			if !self.alive(client) {
+				logrus.WithField("addr", self.Address()).Warn("ssh keepalive failed, closing connection")
+				self.drop(client)
+				return
+			}
+		}
+	}
+}
+
+// expire closes the connection if it has had no sessions for the IdleTimeout
+func (self *PooledClient) expire(client *ssh.Client) bool {
+	self.lock.Lock()
+	idle := self.client == client && self.active == 0 && time.Since(self.lastUsed) >= self.pool.IdleTimeout
+	if idle {
+		self.client = nil
+	}
+	self.lock.Unlock()
+
+	if idle {
+		_ = client.Close()
+	}
+	return idle
+}
+
+// alive sends a keepalive, returning false if it fails or isn't answered within the KeepaliveTimeout
+func (self *PooledClient) alive(client *ssh.Client) bool {
+	errC := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		errC <- err
+	}()
+
+	select {
+	case err := <-errC:
+		return err == nil
+	case <-time.After(self.pool.KeepaliveTimeout):
+		return false
+	}
+}
@@ -0,0 +1,477 @@
+package libssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is an ssh server which answers exec requests by echoing the command. The command "block" waits until
+// unblocked, "cat" echoes stdin, "stderr" writes to stdout and stderr, "kill" is killed by a signal and "exit <n>"
+// exits with the status n. Port forwards are tunneled to the local network.
+type testServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	dials    atomic.Int32
+	closed   atomic.Int32
+	sessions atomic.Int32
+	peak     atomic.Int32
+	tunnels  atomic.Int32
+	unblock  chan struct{}
+
+	lock       sync.Mutex
+	signer     ssh.Signer
+	refuseAuth bool
+	conns      []*ssh.ServerConn
+}
+
+func newTestServer(t *testing.T) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	server := &testServer{
+		listener: listener,
+		unblock:  make(chan struct{}),
+	}
+	server.rekey(t)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handle(conn)
+		}
+	}()
+
+	return server
+}
+
+// rekey gives the server a new host key, as if the host had been replaced
+func (self *testServer) rekey(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	self.lock.Lock()
+	self.signer = signer
+	self.configure()
+	self.lock.Unlock()
+}
+
+// setRefuseAuth makes the server refuse to authenticate clients, as if they gave the wrong credentials
+func (self *testServer) setRefuseAuth(refuse bool) {
+	self.lock.Lock()
+	self.refuseAuth = refuse
+	self.configure()
+	self.lock.Unlock()
+}
+
+func (self *testServer) configure() {
+	config := &ssh.ServerConfig{NoClientAuth: !self.refuseAuth}
+	config.AddHostKey(self.signer)
+	self.config = config
+}
+
+func (self *testServer) handle(conn net.Conn) {
+	self.lock.Lock()
+	config := self.config
+	self.lock.Unlock()
+
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	self.dials.Add(1)
+	self.lock.Lock()
+	self.conns = append(self.conns, serverConn)
+	self.lock.Unlock()
+
+	go self.globalRequests(serverConn, requests)
+	go func() {
+		_ = serverConn.Wait()
+		self.closed.Add(1)
+	}()
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go self.forward(newChannel)
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go self.session(channel, requests)
+	}
+}
+
+// forward tunnels a direct-tcpip channel, as used through jump hosts
+func (self *testServer) forward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	self.tunnels.Add(1)
+	go ssh.DiscardRequests(requests)
+	go func() {
+		_, _ = io.Copy(channel, conn)
+		_ = channel.CloseWrite()
+	}()
+	_, _ = io.Copy(conn, channel)
+	_ = conn.Close()
+}
+
+// globalRequests handles tcpip-forward requests, as used by remote forwards, discarding other requests
+func (self *testServer) globalRequests(conn *ssh.ServerConn, requests <-chan *ssh.Request) {
+	for req := range requests {
+		if req.Type != "tcpip-forward" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		var bind struct {
+			Host string
+			Port uint32
+		}
+		if err := ssh.Unmarshal(req.Payload, &bind); err != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		listener, err := net.Listen("tcp", net.JoinHostPort(bind.Host, strconv.Itoa(int(bind.Port))))
+		if err != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		_ = req.Reply(true, ssh.Marshal(struct{ Port uint32 }{uint32(port)}))
+		go func() {
+			_ = conn.Wait()
+			_ = listener.Close()
+		}()
+		go func() {
+			for {
+				accepted, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go self.forwardBack(conn, bind.Host, uint32(port), accepted)
+			}
+		}()
+	}
+}
+
+// forwardBack opens a forwarded-tcpip channel to the client for a connection accepted on a remote forward
+func (self *testServer) forwardBack(conn *ssh.ServerConn, host string, port uint32, accepted net.Conn) {
+	origin := accepted.RemoteAddr().(*net.TCPAddr)
+	channel, requests, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}{host, port, origin.IP.String(), uint32(origin.Port)}))
+	if err != nil {
+		_ = accepted.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		_, _ = io.Copy(channel, accepted)
+		_ = channel.CloseWrite()
+	}()
+	_, _ = io.Copy(accepted, channel)
+	_ = accepted.Close()
+}
+
+func (self *testServer) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer func() { _ = channel.Close() }()
+
+	current := self.sessions.Add(1)
+	defer self.sessions.Add(-1)
+	for {
+		peak := self.peak.Load()
+		if current <= peak || self.peak.CompareAndSwap(peak, current) {
+			break
+		}
+	}
+
+	for req := range requests {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		_ = req.Reply(true, nil)
+		cmd := string(req.Payload[4:])
+		status := uint32(0)
+		switch {
+		case cmd == "block":
+			<-self.unblock
+			_, _ = channel.Write([]byte(cmd))
+		case cmd == "cat":
+			_, _ = io.Copy(channel, channel)
+		case cmd == "stderr":
+			_, _ = channel.Write([]byte("out 1\nout"))
+			_, _ = channel.Stderr().Write([]byte("err 1\r\n"))
+			_, _ = channel.Write([]byte(" 2\n"))
+		case cmd == "kill":
+			_, _ = channel.SendRequest("exit-signal", false, ssh.Marshal(struct {
+				Signal     string
+				CoreDumped bool
+				Error      string
+				Lang       string
+			}{"KILL", false, "killed", ""}))
+			return
+		case strings.HasPrefix(cmd, "exit "):
+			code, _ := strconv.Atoi(strings.TrimPrefix(cmd, "exit "))
+			status = uint32(code)
+		default:
+			_, _ = channel.Write([]byte(cmd))
+		}
+		_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
+
+func (self *testServer) disconnectAll() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, conn := range self.conns {
+		_ = conn.Close()
+	}
+}
+
+type testFactory struct {
+	address    string
+	knownHosts *KnownHosts
+	jumpHost   SshConfigFactory
+}
+
+func (self *testFactory) Address() string {
+	return self.address
+}
+
+func (self *testFactory) Hostname() string {
+	host, _, _ := net.SplitHostPort(self.address)
+	return host
+}
+
+func (self *testFactory) Port() int {
+	_, port, _ := net.SplitHostPort(self.address)
+	result, _ := strconv.Atoi(port)
+	return result
+}
+
+func (self *testFactory) User() string {
+	return "test"
+}
+
+func (self *testFactory) Config() *ssh.ClientConfig {
+	config := &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	if self.knownHosts != nil {
+		config.HostKeyCallback = self.knownHosts.HostKeyCallback()
+		config.HostKeyAlgorithms = self.knownHosts.Algorithms(self.address)
+	}
+	return config
+}
+
+func (self *testFactory) KeyPath() string {
+	return ""
+}
+
+func (self *testFactory) JumpHost() SshConfigFactory {
+	return self.jumpHost
+}
+
+func (self *testFactory) KnownHosts() *KnownHosts {
+	return self.knownHosts
+}
+
+func newTestPool(t *testing.T) *ClientPool {
+	pool := NewClientPool()
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestPoolSharesConnection(t *testing.T) {
+	server := newTestServer(t)
+	pool := newTestPool(t)
+	factory := &testFactory{address: server.listener.Addr().String()}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out := &SyncBuffer{}
+			assert.NoError(t, pool.Get(factory).Exec(out, "hello"))
+			assert.Equal(t, "hello", out.String())
+		}()
+	}
+	wg.Wait()
+
+	require.Same(t, pool.Get(factory), pool.Get(&testFactory{address: factory.address}))
+	require.Equal(t, int32(1), server.dials.Load())
+}
+
+func TestPoolLimitsSessions(t *testing.T) {
+	server := newTestServer(t)
+	pool := newTestPool(t)
+	pool.MaxSessions = 2
+	client := pool.Get(&testFactory{address: server.listener.Addr().String()})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, client.Exec(&SyncBuffer{}, "block"))
+		}()
+	}
+
+	require.Eventually(t, func() bool { return server.sessions.Load() == 2 }, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(server.unblock)
+	wg.Wait()
+
+	require.Equal(t, int32(2), server.peak.Load())
+}
+
+func TestPoolReconnects(t *testing.T) {
+	server := newTestServer(t)
+	pool := newTestPool(t)
+	client := pool.Get(&testFactory{address: server.listener.Addr().String()})
+
+	require.NoError(t, client.Exec(&SyncBuffer{}, "first"))
+	server.disconnectAll()
+
+	out := &SyncBuffer{}
+	require.NoError(t, client.Exec(out, "second"))
+	require.Equal(t, "second", out.String())
+	require.Equal(t, int32(2), server.dials.Load())
+}
+
+func TestPoolClosesIdleConnections(t *testing.T) {
+	server := newTestServer(t)
+	pool := newTestPool(t)
+	pool.KeepaliveInterval = 10 * time.Millisecond
+	pool.IdleTimeout = 50 * time.Millisecond
+	client := pool.Get(&testFactory{address: server.listener.Addr().String()})
+
+	require.NoError(t, client.Exec(&SyncBuffer{}, "hello"))
+	require.Eventually(t, func() bool { return server.closed.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, client.Exec(&SyncBuffer{}, "again"))
+	require.Equal(t, int32(2), server.dials.Load())
+}
+
+func TestPoolTunnelsThroughJumpHosts(t *testing.T) {
+	target := newTestServer(t)
+	jump := newTestServer(t)
+	edge := newTestServer(t)
+	pool := newTestPool(t)
+
+	edgeFactory := &testFactory{address: edge.listener.Addr().String()}
+	jumpFactory := &testFactory{address: jump.listener.Addr().String(), jumpHost: edgeFactory}
+	client := pool.Get(&testFactory{address: target.listener.Addr().String(), jumpHost: jumpFactory})
+
+	for i := 0; i < 3; i++ {
+		out := &SyncBuffer{}
+		require.NoError(t, client.Exec(out, "hello"))
+		require.Equal(t, "hello", out.String())
+	}
+
+	require.Equal(t, int32(1), target.dials.Load())
+	require.Equal(t, int32(1), jump.dials.Load())
+	require.Equal(t, int32(1), edge.dials.Load())
+	require.Equal(t, int32(1), jump.tunnels.Load())
+	require.Equal(t, int32(1), edge.tunnels.Load())
+
+	// the same address reached through another jump host is another host
+	require.NotSame(t, client, pool.Get(&testFactory{address: target.listener.Addr().String(), jumpHost: edgeFactory}))
+	require.Same(t, client, pool.Get(&testFactory{address: target.listener.Addr().String(), jumpHost: jumpFactory}))
+}
+
+func TestPoolTimeoutReleasesSession(t *testing.T) {
+	server := newTestServer(t)
+	defer close(server.unblock)
+	pool := newTestPool(t)
+	pool.MaxSessions = 1
+	client := pool.Get(&testFactory{address: server.listener.Addr().String()})
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := client.ExecContext(ctx, &SyncBuffer{}, "block")
+		cancel()
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+
+	out := &SyncBuffer{}
+	require.NoError(t, client.Exec(out, "hello"))
+	require.Equal(t, "hello", out.String())
+}
+
+func TestPoolTimesOutStalledHandshakes(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer func() { _ = conn.Close() }()
+		}
+	}()
+
+	pool := newTestPool(t)
+	pool.DialTimeout = 300 * time.Millisecond
+	client := pool.Get(&testFactory{address: listener.Addr().String()})
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- client.Exec(&SyncBuffer{}, "hello")
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	client.Close()
+	require.Less(t, time.Since(start), 100*time.Millisecond, "close blocked by the dial")
+
+	select {
+	case err = <-errC:
+		require.ErrorContains(t, err, "handshake timed out")
+	case <-time.After(2 * time.Second):
+		require.Fail(t, "dial didn't time out")
+	}
+}
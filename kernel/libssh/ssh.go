@@ -19,11 +19,14 @@ package libssh
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -37,14 +40,16 @@ import (
 	"golang.org/x/term"
 )
 
+// LaunchService starts a service from the fablab directory in the home directory of the ssh user. Paths are
+// relative, as commands run in the home directory.
 func LaunchService(factory SshConfigFactory, name, cfg string, sudo bool) error {
 	sudoCmd := ""
 	if sudo {
 		sudoCmd = " sudo "
 	}
 	logName := strings.ReplaceAll(name, " ", "-")
-	serviceCmd := fmt.Sprintf("nohup%v /home/%s/fablab/bin/%s run --log-formatter pfxlog /home/%s/fablab/cfg/%s > logs/%s.log 2>&1 &",
-		sudoCmd, factory.User(), name, factory.User(), cfg, logName)
+	serviceCmd := fmt.Sprintf("nohup%v fablab/bin/%s run --log-formatter pfxlog fablab/cfg/%s > logs/%s.log 2>&1 &",
+		sudoCmd, name, cfg, logName)
 	if value, err := RemoteExec(factory, serviceCmd); err == nil {
 		if len(value) > 0 {
 			logrus.Infof("output [%s]", strings.Trim(value, " \t\r\n"))
@@ -56,84 +61,51 @@ func LaunchService(factory SshConfigFactory, name, cfg string, sudo bool) error
 }
 
 func KillService(factory SshConfigFactory, name string) error {
-	return RemoteKill(factory, fmt.Sprintf("/home/%s/fablab/bin/%s", factory.User(), name))
+	return RemoteKill(factory, "fablab/bin/"+name)
 }
 
 func RemoteShell(factory SshConfigFactory) error {
-	config := factory.Config()
-
 	logrus.Infof("shell for [%s]", factory.Address())
 
-	client, err := ssh.Dial("tcp", factory.Address(), config)
-	if err != nil {
-		return err
-	}
+	return GetClient(factory).WithSession(func(session *ssh.Session) error {
+		fd := int(os.Stdout.Fd())
 
-	session, err := client.NewSession()
-	if err != nil {
-		return err
-	}
-
-	fd := int(os.Stdout.Fd())
-
-	oldState, err := term.MakeRaw(fd)
-	if err != nil {
-		panic(err)
-	}
-	defer func() {
-		_ = session.Close()
-		_ = term.Restore(fd, oldState)
-	}()
-
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
-	session.Stdin = os.Stdin
+		oldState, err := term.MakeRaw(fd)
+		if err != nil {
+			panic(err)
+		}
+		defer func() { _ = term.Restore(fd, oldState) }()
 
-	termWidth, termHeight, err := term.GetSize(fd)
-	if err != nil {
-		panic(err)
-	}
+		session.Stdout = os.Stdout
+		session.Stderr = os.Stderr
+		session.Stdin = os.Stdin
 
-	if err := session.RequestPty("xterm", termHeight, termWidth, ssh.TerminalModes{ssh.ECHO: 1}); err != nil {
-		return err
-	}
+		termWidth, termHeight, err := term.GetSize(fd)
+		if err != nil {
+			panic(err)
+		}
 
-	err = session.Run("/bin/bash")
-	if err != nil {
-		return err
-	}
+		if err := session.RequestPty("xterm", termHeight, termWidth, ssh.TerminalModes{ssh.ECHO: 1}); err != nil {
+			return err
+		}
 
-	return nil
+		return session.Run("/bin/bash")
+	})
 }
 
 func RemoteConsole(factory SshConfigFactory, cmd string) error {
-	config := factory.Config()
 	logrus.Infof("console for [%s]: '%s'", factory.Address(), cmd)
 
-	client, err := ssh.Dial("tcp", factory.Address(), config)
-	if err != nil {
-		return err
-	}
-
-	session, err := client.NewSession()
-	if err != nil {
-		return err
-	}
-	defer func() { _ = session.Close() }()
-
-	if err := session.RequestPty("xterm", 40, 80, ssh.TerminalModes{ssh.ECHO: 0}); err != nil {
-		return err
-	}
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
-	session.Stdin = os.Stdin
-
-	err = session.Run(cmd)
-	if err != nil {
-		return err
-	}
+	return GetClient(factory).WithSession(func(session *ssh.Session) error {
+		if err := session.RequestPty("xterm", 40, 80, ssh.TerminalModes{ssh.ECHO: 0}); err != nil {
+			return err
+		}
+		session.Stdout = os.Stdout
+		session.Stderr = os.Stderr
+		session.Stdin = os.Stdin
 
-	return nil
+		return session.Run(cmd)
+	})
 }
 
 func RemoteExec(sshConfig SshConfigFactory, cmd string) (string, error) {
@@ -147,59 +119,31 @@ func RemoteExecAll(sshConfig SshConfigFactory, cmds ...string) (string, error) {
 }
 
 func RemoteExecAllWithTimeout(sshConfig SshConfigFactory, timeout time.Duration, cmds ...string) (string, error) {
-	resultCh := make(chan struct {
-		output string
-		err    error
-	}, 1)
-
-	go func() {
-		result, err := RemoteExecAll(sshConfig, cmds...)
-		resultCh <- struct {
-			output string
-			err    error
-		}{
-			output: result,
-			err:    err,
-		}
-	}()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	select {
-	case result := <-resultCh:
-		return result.output, result.err
-	case <-time.After(timeout):
-		return "", errors.Errorf("timed out after %v", timeout)
+	b := &SyncBuffer{}
+	if err := remoteExecAllTo(ctx, sshConfig, b, cmds...); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", errors.Errorf("timed out after %v", timeout)
+		}
+		return b.String(), err
 	}
+	return b.String(), nil
 }
 
 func RemoteExecAllTo(sshConfig SshConfigFactory, out io.Writer, cmds ...string) error {
+	return remoteExecAllTo(context.Background(), sshConfig, out, cmds...)
+}
+
+func remoteExecAllTo(ctx context.Context, sshConfig SshConfigFactory, out io.Writer, cmds ...string) error {
 	if len(cmds) == 0 {
 		return nil
 	}
-	config := sshConfig.Config()
-	config.Timeout = 10 * time.Second
-
-	logrus.Infof("executing [%s]: '%s'", sshConfig.Address(), cmds[0])
-
-	client, err := ssh.Dial("tcp", sshConfig.Address(), config)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = client.Close() }()
-
-	for idx, cmd := range cmds {
-		session, err := client.NewSession()
-		if err != nil {
-			return err
-		}
-		session.Stdout = out
-		session.Stderr = out
-		if idx > 0 {
-			logrus.Infof("executing [%s]: '%s'", sshConfig.Address(), cmd)
-		}
-		err = session.Run(cmd)
-		_ = session.Close()
-
-		if err != nil {
+	client := GetClient(sshConfig)
+	for _, cmd := range cmds {
+		logrus.Infof("executing [%s]: '%s'", sshConfig.Address(), cmd)
+		if err := client.ExecContext(ctx, out, cmd); err != nil {
 			return err
 		}
 	}
@@ -278,92 +222,51 @@ func killMatch(s, search, anti string) bool {
 }
 
 func RemoteFileList(factory SshConfigFactory, path string) ([]os.FileInfo, error) {
-	config := factory.Config()
-
-	conn, err := ssh.Dial("tcp", factory.Address(), config)
-	if err != nil {
-		return nil, fmt.Errorf("error dialing ssh server (%w)", err)
-	}
-	defer func() { _ = conn.Close() }()
-
-	client, err := sftp.NewClient(conn)
-	if err != nil {
-		return nil, fmt.Errorf("error creating sftp client (%w)", err)
-	}
-	defer func() { _ = client.Close() }()
-
-	files, err := client.ReadDir(path)
-	if err != nil {
-		return nil, fmt.Errorf("error retrieving directory [%s] (%w)", path, err)
-	}
-
-	return files, nil
+	var files []os.FileInfo
+	err := GetClient(factory).WithSftp(func(client *sftp.Client) error {
+		var err error
+		if files, err = client.ReadDir(path); err != nil {
+			return fmt.Errorf("error retrieving directory [%s] (%w)", path, err)
+		}
+		return nil
+	})
+	return files, err
 }
 
 func Chmod(factory SshConfigFactory, remotePath string, mode os.FileMode) error {
-	config := factory.Config()
+	return GetClient(factory).WithSftp(func(client *sftp.Client) error {
+		rmtFile, err := client.OpenFile(remotePath, os.O_WRONLY)
 
-	conn, err := ssh.Dial("tcp", factory.Address(), config)
-	if err != nil {
-		return errors.Wrap(err, "error dialing ssh server")
-	}
-	defer func() { _ = conn.Close() }()
-
-	client, err := sftp.NewClient(conn)
-	if err != nil {
-		return errors.Wrap(err, "error creating sftp client")
-	}
-	defer func() { _ = client.Close() }()
-
-	rmtFile, err := client.OpenFile(remotePath, os.O_WRONLY)
-
-	if err != nil {
-		return errors.Wrapf(err, "unable to open remote file %v", remotePath)
-	}
-	defer func() { _ = rmtFile.Close() }()
+		if err != nil {
+			return errors.Wrapf(err, "unable to open remote file %v", remotePath)
+		}
+		defer func() { _ = rmtFile.Close() }()
 
-	if err := rmtFile.Chmod(mode); err != nil {
-		return errors.Wrapf(err, "unable to chmod remote file %v", remotePath)
-	}
+		if err := rmtFile.Chmod(mode); err != nil {
+			return errors.Wrapf(err, "unable to chmod remote file %v", remotePath)
+		}
 
-	return nil
+		return nil
+	})
 }
 
 func SendData(factory SshConfigFactory, data []byte, remotePath string) error {
-	config := factory.Config()
-
-	conn, err := ssh.Dial("tcp", factory.Address(), config)
-	if err != nil {
-		return errors.Wrap(err, "error dialing ssh server")
-	}
-	defer func() { _ = conn.Close() }()
-
-	client, err := sftp.NewClient(conn)
-	if err != nil {
-		return errors.Wrap(err, "error creating sftp client")
-	}
-	defer func() { _ = client.Close() }()
-
-	path.Dir(remotePath)
-	logrus.Infof("Creating paths %s", path.Dir(remotePath))
-	if err := client.MkdirAll(path.Dir(remotePath)); err != nil {
-		return errors.Wrapf(err, "unable to create directories for %v", remotePath)
-	}
+	return GetClient(factory).WithSftp(func(client *sftp.Client) error {
+		logrus.Infof("Creating paths %s", path.Dir(remotePath))
+		if err := client.MkdirAll(path.Dir(remotePath)); err != nil {
+			return errors.Wrapf(err, "unable to create directories for %v", remotePath)
+		}
 
-	rmtFile, err := client.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		rmtFile, err := client.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
 
-	if err != nil {
-		return errors.Wrapf(err, "unable to open remote file %v", remotePath)
-	}
-	defer func() { _ = rmtFile.Close() }()
-
-	_, err = rmtFile.Write(data)
+		if err != nil {
+			return errors.Wrapf(err, "unable to open remote file %v", remotePath)
+		}
+		defer func() { _ = rmtFile.Close() }()
 
-	if err != nil {
+		_, err = rmtFile.Write(data)
 		return err
-	}
-
-	return nil
+	})
 }
 
 func SendFile(factory SshConfigFactory, localPath string, remotePath string) error {
@@ -385,34 +288,22 @@ func RetrieveRemoteFiles(factory SshConfigFactory, localPath string, paths ...st
 		return fmt.Errorf("error creating local path")
 	}
 
-	config := factory.Config()
-
-	conn, err := ssh.Dial("tcp", factory.Address(), config)
-	if err != nil {
-		return fmt.Errorf("error dialing ssh server (%w)", err)
-	}
-	defer func() { _ = conn.Close() }()
-
-	client, err := sftp.NewClient(conn)
-	if err != nil {
-		return fmt.Errorf("error creating sftp client (%w)", err)
-	}
-	defer func() { _ = client.Close() }()
-
-	for _, nextPath := range paths {
-		fileInfo, err := client.Stat(nextPath)
-		if err != nil {
-			return err
-		}
-		if fileInfo.IsDir() {
-			if err = retrieveRemoteDir(client, nextPath, localPath); err != nil {
+	return GetClient(factory).WithSftp(func(client *sftp.Client) error {
+		for _, nextPath := range paths {
+			fileInfo, err := client.Stat(nextPath)
+			if err != nil {
+				return err
+			}
+			if fileInfo.IsDir() {
+				if err = retrieveRemoteDir(client, nextPath, localPath); err != nil {
+					return err
+				}
+			} else if err = retrieveRemoteFile(client, nextPath, localPath); err != nil {
 				return err
 			}
-		} else if err = retrieveRemoteFile(client, nextPath, localPath); err != nil {
-			return err
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 func retrieveRemoteDir(client *sftp.Client, path string, localPath string) error {
@@ -464,28 +355,15 @@ func retrieveRemoteFile(client *sftp.Client, path string, localPath string) erro
 }
 
 func DeleteRemoteFiles(factory SshConfigFactory, paths ...string) error {
-	config := factory.Config()
-
-	conn, err := ssh.Dial("tcp", factory.Address(), config)
-	if err != nil {
-		return fmt.Errorf("error dialing ssh server (%w)", err)
-	}
-	defer func() { _ = conn.Close() }()
-
-	client, err := sftp.NewClient(conn)
-	if err != nil {
-		return fmt.Errorf("error creating sftp client (%w)", err)
-	}
-	defer func() { _ = client.Close() }()
-
-	for _, nextPath := range paths {
-		if err := client.Remove(nextPath); err != nil {
-			return fmt.Errorf("error removing path [%s] (%w)", nextPath, err)
+	return GetClient(factory).WithSftp(func(client *sftp.Client) error {
+		for _, nextPath := range paths {
+			if err := client.Remove(nextPath); err != nil {
+				return fmt.Errorf("error removing path [%s] (%w)", nextPath, err)
+			}
+			logrus.Infof("%s removed", nextPath)
 		}
-		logrus.Infof("%s removed", nextPath)
-	}
-
-	return nil
+		return nil
+	})
 }
 
 type SshConfigFactory interface {
@@ -502,10 +380,16 @@ type SshConfigFactoryImpl struct {
 	host            string
 	port            int
 	keyPath         string
+	password        string
+	agentOnly       bool
+	knownHosts      *KnownHosts
+	jumpHost        SshConfigFactory
 	resolveAuthOnce sync.Once
 	authMethods     []ssh.AuthMethod
 }
 
+// NewSshConfigFactory returns a factory which connects to port 22 of the host, authenticating with the key file and
+// the ssh agent. Use the setters to change the port and authentication.
 func NewSshConfigFactory(user, keyPath, host string) *SshConfigFactoryImpl {
 	factory := &SshConfigFactoryImpl{
 		user:    user,
@@ -532,6 +416,109 @@ func (factory *SshConfigFactoryImpl) KeyPath() string {
 	return factory.keyPath
 }
 
+// KnownHosts returns the known hosts used to verify the host key, or nil if the host key isn't verified
+func (factory *SshConfigFactoryImpl) KnownHosts() *KnownHosts {
+	return factory.knownHosts
+}
+
+func (factory *SshConfigFactoryImpl) SetKnownHosts(knownHosts *KnownHosts) {
+	factory.knownHosts = knownHosts
+}
+
+func (factory *SshConfigFactoryImpl) SetPort(port int) {
+	factory.port = port
+}
+
+func (factory *SshConfigFactoryImpl) Password() string {
+	return factory.password
+}
+
+// SetPassword enables password authentication, which is tried after the key file and the ssh agent
+func (factory *SshConfigFactoryImpl) SetPassword(password string) {
+	factory.password = password
+}
+
+func (factory *SshConfigFactoryImpl) AgentOnly() bool {
+	return factory.agentOnly
+}
+
+// SetAgentOnly makes the factory authenticate with the keys from the ssh agent, ignoring the key file
+func (factory *SshConfigFactoryImpl) SetAgentOnly(agentOnly bool) {
+	factory.agentOnly = agentOnly
+}
+
+// IdentityFile returns the key file used to authenticate, or an empty string if the factory doesn't use one
+func IdentityFile(factory SshConfigFactory) string {
+	if f, ok := factory.(interface{ AgentOnly() bool }); ok && f.AgentOnly() {
+		return ""
+	}
+	return factory.KeyPath()
+}
+
+// JumpHost returns the factory for the host which connections are tunneled through, or nil if the host is
+// connected to directly
+func (factory *SshConfigFactoryImpl) JumpHost() SshConfigFactory {
+	return factory.jumpHost
+}
+
+func (factory *SshConfigFactoryImpl) SetJumpHost(jumpHost SshConfigFactory) {
+	factory.jumpHost = jumpHost
+}
+
+func jumpHost(factory SshConfigFactory) SshConfigFactory {
+	if f, ok := factory.(interface{ JumpHost() SshConfigFactory }); ok {
+		return f.JumpHost()
+	}
+	return nil
+}
+
+func knownHosts(factory SshConfigFactory) *KnownHosts {
+	if f, ok := factory.(interface{ KnownHosts() *KnownHosts }); ok {
+		return f.KnownHosts()
+	}
+	return nil
+}
+
+// HostKeyAlias returns the name the host's key is recorded under in known hosts, or an empty string if it's recorded
+// under the address of the host. Hosts reached through a jump host are recorded under a name which includes the
+// jump host, as hosts in other networks, reached through other jump hosts, may have the same private address.
+func HostKeyAlias(factory SshConfigFactory) string {
+	if jumpHost(factory) == nil {
+		return ""
+	}
+	return hostKeyName(factory)
+}
+
+func hostKeyName(factory SshConfigFactory) string {
+	name := factory.Hostname()
+	if factory.Port() != 22 {
+		name += "_" + strconv.Itoa(factory.Port())
+	}
+	if jump := jumpHost(factory); jump != nil {
+		name += "-via-" + hostKeyName(jump)
+	}
+	return name
+}
+
+// HostKeyAddress returns the address the host's key is verified and recorded for, which is the address of the
+// host, unless it has a HostKeyAlias
+func HostKeyAddress(factory SshConfigFactory) string {
+	if alias := HostKeyAlias(factory); alias != "" {
+		return net.JoinHostPort(alias, "22")
+	}
+	return factory.Address()
+}
+
+// poolKey identifies the connection for the factory, which is the user and address of the host, along with those of
+// the jump hosts it's reached through
+func poolKey(factory SshConfigFactory) string {
+	key := factory.User() + "@" + factory.Address()
+	if jump := jumpHost(factory); jump != nil {
+		key += " via " + poolKey(jump)
+	}
+	return key
+}
+
 func (factory *SshConfigFactoryImpl) Address() string {
 	return factory.host + ":" + strconv.Itoa(factory.port)
 }
@@ -540,24 +527,78 @@ func (factory *SshConfigFactoryImpl) Config() *ssh.ClientConfig {
 	factory.resolveAuthOnce.Do(func() {
 		var methods []ssh.AuthMethod
 
-		if fileMethod, err := sshAuthMethodFromFile(factory.keyPath); err == nil {
-			methods = append(methods, fileMethod)
-		} else {
-			logrus.Error(err)
+		if keyPath := IdentityFile(factory); keyPath != "" {
+			if fileMethod, err := sshAuthMethodFromFile(keyPath); err == nil {
+				methods = append(methods, fileMethod)
+			} else {
+				logrus.Error(err)
+			}
 		}
 
 		if agentMethod := sshAuthMethodAgent(); agentMethod != nil {
 			methods = append(methods, sshAuthMethodAgent())
 		}
 
+		if factory.password != "" {
+			methods = append(methods, ssh.Password(factory.password))
+		}
+
 		factory.authMethods = methods
 	})
 
-	return &ssh.ClientConfig{
+	config := &ssh.ClientConfig{
 		User:            factory.user,
 		Auth:            factory.authMethods,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
+	if factory.knownHosts != nil {
+		config.HostKeyCallback = factory.knownHosts.HostKeyCallback()
+		config.HostKeyAlgorithms = factory.knownHosts.Algorithms(HostKeyAddress(factory))
+	}
+	return config
+}
+
+// SshOptions returns the options which make the ssh client verify host keys in the same way as the factory, and
+// tunnel through the same jump hosts
+func SshOptions(factory SshConfigFactory) []string {
+	result := []string{"-o", "StrictHostKeyChecking=no"}
+	if hosts := knownHosts(factory); hosts != nil {
+		result = hosts.SshOptions()
+		if alias := HostKeyAlias(factory); alias != "" {
+			result = append(result, "-o", "HostKeyAlias="+alias)
+		}
+	}
+
+	if jump := jumpHost(factory); jump != nil {
+		proxy := []string{"ssh"}
+		if keyPath := IdentityFile(jump); keyPath != "" {
+			proxy = append(proxy, "-i", keyPath)
+		}
+		proxy = append(proxy, "-p", strconv.Itoa(jump.Port()))
+		proxy = append(proxy, SshOptions(jump)...)
+		proxy = append(proxy, "-W", "%h:%p", jump.User()+"@"+jump.Hostname())
+
+		// the proxy command is expanded by ssh, so escape the tokens meant for the proxy commands of further jumps
+		var quoted []string
+		for _, arg := range proxy {
+			if arg != "%h:%p" {
+				arg = strings.ReplaceAll(shellQuote(arg), "%", "%%")
+			}
+			quoted = append(quoted, arg)
+		}
+		result = append(result, "-o", "ProxyCommand="+strings.Join(quoted, " "))
+	}
+
+	return result
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`)
+
+func shellQuote(arg string) string {
+	if shellSafe.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
 }
 
 func sshAuthMethodFromFile(keyPath string) (ssh.AuthMethod, error) {
@@ -0,0 +1,55 @@
+package libssh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSshOptionsChainJumpHosts(t *testing.T) {
+	edge := NewSshConfigFactory("admin", "/keys/edge key", "1.2.3.4")
+	edge.SetPort(2222)
+
+	jump := NewSshConfigFactory("ubuntu", "/keys/id", "10.0.0.1")
+	jump.SetJumpHost(edge)
+
+	target := NewSshConfigFactory("ubuntu", "/keys/id", "10.0.1.1")
+	target.SetJumpHost(jump)
+
+	require.Equal(t, []string{"-o", "StrictHostKeyChecking=no"}, SshOptions(edge))
+	require.Equal(t, []string{
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "ProxyCommand=ssh -i '/keys/edge key' -p 2222 -o StrictHostKeyChecking=no -W %h:%p admin@1.2.3.4",
+	}, SshOptions(jump))
+	require.Equal(t, []string{
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "ProxyCommand=ssh -i /keys/id -p 22 -o StrictHostKeyChecking=no -o " +
+			"'ProxyCommand=ssh -i '\\''/keys/edge key'\\'' -p 2222 -o StrictHostKeyChecking=no -W %%h:%%p admin@1.2.3.4' " +
+			"-W %h:%p ubuntu@10.0.0.1",
+	}, SshOptions(target))
+}
+
+func TestHostKeyAliasIncludesJumpHosts(t *testing.T) {
+	edge := NewSshConfigFactory("admin", "", "1.2.3.4")
+	edge.SetPort(2222)
+	edge.SetKnownHosts(NewKnownHosts("/instance/known_hosts"))
+
+	target := NewSshConfigFactory("ubuntu", "", "10.0.1.1")
+	target.SetJumpHost(edge)
+	target.SetKnownHosts(edge.KnownHosts())
+
+	other := NewSshConfigFactory("ubuntu", "", "10.0.1.1")
+	other.SetJumpHost(NewSshConfigFactory("admin", "", "5.6.7.8"))
+
+	require.Equal(t, "", HostKeyAlias(edge))
+	require.Equal(t, "1.2.3.4:2222", HostKeyAddress(edge))
+	require.Equal(t, "10.0.1.1-via-1.2.3.4_2222", HostKeyAlias(target))
+	require.Equal(t, "10.0.1.1-via-1.2.3.4_2222:22", HostKeyAddress(target))
+	require.Equal(t, "10.0.1.1-via-5.6.7.8", HostKeyAlias(other))
+
+	require.Equal(t, "ubuntu@10.0.1.1:22 via admin@1.2.3.4:2222", poolKey(target))
+	require.NotEqual(t, poolKey(target), poolKey(other))
+
+	require.Contains(t, SshOptions(target), "HostKeyAlias=10.0.1.1-via-1.2.3.4_2222")
+	require.NotContains(t, SshOptions(edge), "HostKeyAlias=")
+}
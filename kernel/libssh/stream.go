@@ -0,0 +1,193 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package libssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// ExecOptions configures a command run with RemoteExecStream
+type ExecOptions struct {
+	// Stdout receives the command's stdout, a line per write with the Prefix. Output is discarded if nil.
+	Stdout io.Writer
+	// Stderr receives the command's stderr, a line per write with the Prefix. Output is discarded if nil.
+	Stderr io.Writer
+	// Prefix is added to each line, for example to tell apart the output of many hosts
+	Prefix string
+	// Stdin, if set, is sent to the command's stdin
+	Stdin io.Reader
+	// Env holds environment variables for the command. They're exported by the command line, as sshd only accepts
+	// the variables allowed by AcceptEnv.
+	Env map[string]string
+}
+
+// ExitError is returned when a remote command exits with a non-zero status, or is killed by a signal
+type ExitError struct {
+	Address string
+	Cmd     string
+	// Status is the exit status, or -1 if the command didn't report one, for example because it was killed
+	Status int
+	// Signal is the name of the signal which killed the command, without the SIG prefix, or empty
+	Signal string
+	// Message is the error message given with the signal, if any
+	Message string
+}
+
+func (self *ExitError) Error() string {
+	result := fmt.Sprintf("command '%s' on [%s] ", self.Cmd, self.Address)
+	if self.Signal != "" {
+		result += "killed by signal " + self.Signal
+	} else if self.Status < 0 {
+		result += "exited without reporting a status"
+	} else {
+		result += fmt.Sprintf("exited with status %d", self.Status)
+	}
+	if self.Message != "" {
+		result += " (" + self.Message + ")"
+	}
+	return result
+}
+
+// RemoteExecStream runs cmd, streaming stdout and stderr line by line as configured by the options. A command which
+// fails returns an *ExitError.
+func RemoteExecStream(factory SshConfigFactory, cmd string, options *ExecOptions) error {
+	return GetClient(factory).ExecStream(cmd, options)
+}
+
+// ExecStream runs cmd in a new session, streaming stdout and stderr line by line as configured by the options. A
+// command which fails returns an *ExitError.
+func (self *PooledClient) ExecStream(cmd string, options *ExecOptions) error {
+	if options == nil {
+		options = &ExecOptions{}
+	}
+	env, err := envPrefix(options.Env)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("executing [%s]: '%s'", self.Address(), cmd)
+
+	stdout := &LineWriter{Writer: options.Stdout, Prefix: options.Prefix}
+	stderr := &LineWriter{Writer: options.Stderr, Prefix: options.Prefix}
+
+	err = self.WithSession(func(session *ssh.Session) error {
+		session.Stdout = stdout
+		session.Stderr = stderr
+		session.Stdin = options.Stdin
+		return session.Run(env + cmd)
+	})
+	_ = stdout.Close()
+	_ = stderr.Close()
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExitError{
+			Address: self.Address(),
+			Cmd:     cmd,
+			Status:  exitErr.ExitStatus(),
+			Signal:  exitErr.Signal(),
+			Message: exitErr.Msg(),
+		}
+	}
+	var missingErr *ssh.ExitMissingError
+	if errors.As(err, &missingErr) {
+		return &ExitError{Address: self.Address(), Cmd: cmd, Status: -1}
+	}
+	return err
+}
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateEnvName returns an error if name isn't a valid environment variable name. Names are put on the command
+// line unquoted, so anything else could run arbitrary commands.
+func ValidateEnvName(name string) error {
+	if !envName.MatchString(name) {
+		return errors.Errorf("invalid environment variable name [%s], must match %s", name, envName)
+	}
+	return nil
+}
+
+// envPrefix returns the command line exporting the variables, sorted by name
+func envPrefix(env map[string]string) (string, error) {
+	if len(env) == 0 {
+		return "", nil
+	}
+	var names []string
+	for name := range env {
+		if err := ValidateEnvName(name); err != nil {
+			return "", err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var exports []string
+	for _, name := range names {
+		exports = append(exports, name+"="+shellQuote(env[name]))
+	}
+	return "export " + strings.Join(exports, " ") + "; ", nil
+}
+
+// LineWriter writes each complete line to the Writer with the Prefix, using a single write per line so that lines
+// from many LineWriters sharing a synchronized writer aren't interleaved. Close writes the last line if it wasn't
+// terminated. Lines are discarded if the Writer is nil.
+type LineWriter struct {
+	Writer io.Writer
+	Prefix string
+	buf    []byte
+}
+
+func (self *LineWriter) Write(data []byte) (int, error) {
+	self.buf = append(self.buf, data...)
+	for {
+		idx := bytes.IndexByte(self.buf, '\n')
+		if idx < 0 {
+			return len(data), nil
+		}
+		line := self.buf[:idx]
+		self.buf = self.buf[idx+1:]
+		if err := self.writeLine(line); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (self *LineWriter) Close() error {
+	if len(self.buf) == 0 {
+		return nil
+	}
+	line := self.buf
+	self.buf = nil
+	return self.writeLine(line)
+}
+
+func (self *LineWriter) writeLine(line []byte) error {
+	if self.Writer == nil {
+		return nil
+	}
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	_, err := self.Writer.Write([]byte(self.Prefix + string(line) + "\n"))
+	return err
+}
@@ -0,0 +1,59 @@
+package libssh
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecStreamSeparatesOutput(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestPool(t).Get(&testFactory{address: server.listener.Addr().String()})
+
+	stdout, stderr := &SyncBuffer{}, &SyncBuffer{}
+	err := client.ExecStream("stderr", &ExecOptions{Stdout: stdout, Stderr: stderr, Prefix: "host1 | "})
+	require.NoError(t, err)
+	require.Equal(t, "host1 | out 1\nhost1 | out 2\n", stdout.String())
+	require.Equal(t, "host1 | err 1\n", stderr.String())
+}
+
+func TestExecStreamStdinAndEnv(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestPool(t).Get(&testFactory{address: server.listener.Addr().String()})
+
+	out := &SyncBuffer{}
+	require.NoError(t, client.ExecStream("cat", &ExecOptions{Stdout: out, Stdin: strings.NewReader("a\nb")}))
+	require.Equal(t, "a\nb\n", out.String())
+
+	out = &SyncBuffer{}
+	env := map[string]string{"B": "x", "A": "it's"}
+	require.NoError(t, client.ExecStream("hello", &ExecOptions{Stdout: out, Env: env}))
+	require.Equal(t, `export A='it'\''s' B=x; hello`+"\n", out.String())
+
+	for _, name := range []string{"A;touch x", "1A", "A-B", "A B", ""} {
+		out = &SyncBuffer{}
+		err := client.ExecStream("hello", &ExecOptions{Stdout: out, Env: map[string]string{name: "x"}})
+		require.ErrorContains(t, err, "invalid environment variable name", name)
+		require.Empty(t, out.String())
+	}
+}
+
+func TestExecStreamExitErrors(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestPool(t).Get(&testFactory{address: server.listener.Addr().String()})
+
+	var exitErr *ExitError
+	err := client.ExecStream("exit 3", nil)
+	require.True(t, errors.As(err, &exitErr), "unexpected error: %v", err)
+	require.Equal(t, 3, exitErr.Status)
+	require.Equal(t, "", exitErr.Signal)
+	require.Contains(t, err.Error(), "exited with status 3")
+
+	err = client.ExecStream("kill", nil)
+	require.True(t, errors.As(err, &exitErr), "unexpected error: %v", err)
+	require.Equal(t, "KILL", exitErr.Signal)
+	require.Equal(t, "killed", exitErr.Message)
+	require.Contains(t, err.Error(), "killed by signal KILL")
+}
@@ -0,0 +1,324 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package libssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Forward describes a port forward in the form given to the ssh client with -L and -R:
+// [bind_address:]port:[host:]hostport. Connections to the bind address and port are forwarded to the host and
+// hostport. A bare port forwards the port to the same port on localhost.
+type Forward struct {
+	BindAddress string
+	Port        int
+	Host        string
+	HostPort    int
+}
+
+// ParseForward parses a forward given as [bind_address:]port:[host:]hostport, or as a bare port. The bind address
+// and host default to localhost. A port of 0 listens on any free port.
+func ParseForward(spec string) (*Forward, error) {
+	parts := strings.Split(spec, ":")
+	result := &Forward{BindAddress: "localhost", Host: "localhost"}
+
+	var port, hostPort string
+	switch len(parts) {
+	case 1:
+		port, hostPort = parts[0], parts[0]
+	case 2:
+		port, hostPort = parts[0], parts[1]
+	case 3:
+		port, result.Host, hostPort = parts[0], parts[1], parts[2]
+	case 4:
+		result.BindAddress, port, result.Host, hostPort = parts[0], parts[1], parts[2], parts[3]
+	default:
+		return nil, errors.Errorf("invalid forward [%s], expected [bind_address:]port:[host:]hostport", spec)
+	}
+	if result.BindAddress == "" || result.Host == "" {
+		return nil, errors.Errorf("invalid forward [%s], addresses may not be empty", spec)
+	}
+
+	var err error
+	if result.Port, err = strconv.Atoi(port); err != nil || result.Port < 0 || result.Port > 65535 {
+		return nil, errors.Errorf("invalid forward [%s], bad port [%s]", spec, port)
+	}
+	if result.HostPort, err = strconv.Atoi(hostPort); err != nil || result.HostPort < 1 || result.HostPort > 65535 {
+		return nil, errors.Errorf("invalid forward [%s], bad host port [%s]", spec, hostPort)
+	}
+	return result, nil
+}
+
+// BindAddr returns the address to listen on
+func (self *Forward) BindAddr() string {
+	return net.JoinHostPort(self.BindAddress, strconv.Itoa(self.Port))
+}
+
+// TargetAddr returns the address connections are forwarded to
+func (self *Forward) TargetAddr() string {
+	return net.JoinHostPort(self.Host, strconv.Itoa(self.HostPort))
+}
+
+func (self *Forward) String() string {
+	return self.BindAddr() + ":" + self.TargetAddr()
+}
+
+// Tunnel forwards the connections accepted on a listener, until closed. If the listener fails, for example because
+// the ssh connection it was opened on broke, it's opened again on the same address.
+type Tunnel struct {
+	client   *PooledClient
+	desc     string
+	bindAddr string
+	listen   func(bindAddr string) (net.Listener, error)
+	dial     func() (net.Conn, error)
+
+	lock     sync.Mutex
+	listener net.Listener
+	conns    map[io.Closer]struct{}
+	closed   chan struct{}
+}
+
+// LocalForward listens on the local bindAddr, forwarding connections over ssh to targetAddr. The target is
+// connected to by the host, so it may be a port on the host's loopback, or any address reachable from the host.
+func (self *PooledClient) LocalForward(bindAddr, targetAddr string) (*Tunnel, error) {
+	tunnel := self.newTunnel(bindAddr, fmt.Sprintf("local forward to [%s] via [%s]", targetAddr, self.Address()))
+	tunnel.listen = func(bindAddr string) (net.Listener, error) {
+		return net.Listen("tcp", bindAddr)
+	}
+	tunnel.dial = func() (conn net.Conn, err error) {
+		err = self.open(func(client *ssh.Client) error {
+			conn, err = client.Dial("tcp", targetAddr)
+			return err
+		})
+		return conn, err
+	}
+	if err := tunnel.start(); err != nil {
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+// RemoteForward listens on bindAddr on the host, forwarding connections back over ssh to the local targetAddr.
+// sshd binds remote forwards to the host's loopback, unless GatewayPorts is enabled. The ssh connection is kept
+// open while the tunnel is.
+func (self *PooledClient) RemoteForward(bindAddr, targetAddr string) (*Tunnel, error) {
+	tunnel := self.newTunnel(bindAddr, fmt.Sprintf("remote forward from [%s] to [%s]", self.Address(), targetAddr))
+	tunnel.listen = func(bindAddr string) (listener net.Listener, err error) {
+		err = self.open(func(client *ssh.Client) error {
+			listener, err = client.Listen("tcp", bindAddr)
+			return err
+		})
+		return listener, err
+	}
+	tunnel.dial = func() (net.Conn, error) {
+		return net.DialTimeout("tcp", targetAddr, self.pool.DialTimeout)
+	}
+
+	self.busy(1)
+	if err := tunnel.start(); err != nil {
+		self.busy(-1)
+		return nil, err
+	}
+	go func() {
+		<-tunnel.closed
+		self.busy(-1)
+	}()
+	return tunnel, nil
+}
+
+func (self *PooledClient) newTunnel(bindAddr, desc string) *Tunnel {
+	return &Tunnel{
+		client:   self,
+		desc:     desc,
+		bindAddr: bindAddr,
+		conns:    map[io.Closer]struct{}{},
+		closed:   make(chan struct{}),
+	}
+}
+
+// Addr returns the address the tunnel is listening on, which gives the port chosen when listening on port 0
+func (self *Tunnel) Addr() net.Addr {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.listener.Addr()
+}
+
+func (self *Tunnel) String() string {
+	return self.desc
+}
+
+// Close stops listening and closes the forwarded connections
+func (self *Tunnel) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	select {
+	case <-self.closed:
+		return nil
+	default:
+	}
+	close(self.closed)
+
+	for conn := range self.conns {
+		_ = conn.Close()
+	}
+	return self.listener.Close()
+}
+
+func (self *Tunnel) start() error {
+	listener, err := self.listen(self.bindAddr)
+	if err != nil {
+		return fmt.Errorf("unable to listen for %s (%w)", self.desc, err)
+	}
+	self.listener = listener
+
+	// listen again on the port first assigned, rather than a new one, if listening on port 0
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		if host, _, err := net.SplitHostPort(self.bindAddr); err == nil {
+			self.bindAddr = net.JoinHostPort(host, strconv.Itoa(addr.Port))
+		}
+	}
+	go self.serve(listener)
+	return nil
+}
+
+func (self *Tunnel) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err == nil {
+			go self.forward(conn)
+			continue
+		}
+		if listener = self.relisten(err); listener == nil {
+			return
+		}
+	}
+}
+
+// relisten opens the listener again after it failed, retrying until it succeeds or the tunnel is closed, in which
+// case it returns nil
+func (self *Tunnel) relisten(cause error) net.Listener {
+	log := logrus.WithField("addr", self.client.Address())
+	for {
+		select {
+		case <-self.closed:
+			return nil
+		default:
+		}
+		log.Warnf("listener for %s failed, listening again (%v)", self.desc, cause)
+
+		listener, err := self.listen(self.bindAddr)
+		if err == nil {
+			if !self.replaceListener(listener) {
+				_ = listener.Close()
+				return nil
+			}
+			log.Infof("listening on [%s] for %s", listener.Addr(), self.desc)
+			return listener
+		}
+		cause = err
+
+		select {
+		case <-self.closed:
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// replaceListener sets the listener, returning false if the tunnel is closed
+func (self *Tunnel) replaceListener(listener net.Listener) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	select {
+	case <-self.closed:
+		return false
+	default:
+		self.listener = listener
+		return true
+	}
+}
+
+func (self *Tunnel) forward(conn net.Conn) {
+	if !self.track(conn) {
+		_ = conn.Close()
+		return
+	}
+	defer self.untrack(conn)
+
+	target, err := self.dial()
+	if err != nil {
+		logrus.WithField("addr", self.client.Address()).WithError(err).Errorf("unable to connect %s", self.desc)
+		return
+	}
+	if !self.track(target) {
+		_ = target.Close()
+		return
+	}
+	defer self.untrack(target)
+
+	self.client.busy(1)
+	defer self.client.busy(-1)
+
+	done := make(chan struct{})
+	go func() {
+		pipe(target, conn)
+		close(done)
+	}()
+	pipe(conn, target)
+	<-done
+}
+
+// track records an open connection, so that it's closed with the tunnel. Returns false if the tunnel is closed.
+func (self *Tunnel) track(conn io.Closer) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	select {
+	case <-self.closed:
+		return false
+	default:
+		self.conns[conn] = struct{}{}
+		return true
+	}
+}
+
+func (self *Tunnel) untrack(conn io.Closer) {
+	self.lock.Lock()
+	delete(self.conns, conn)
+	self.lock.Unlock()
+	_ = conn.Close()
+}
+
+// pipe copies from src to dst, then closes dst for writing so the end of the stream reaches the other side
+func pipe(dst, src net.Conn) {
+	_, _ = io.Copy(dst, src)
+	if closer, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = closer.CloseWrite()
+	} else {
+		_ = dst.Close()
+	}
+}
@@ -0,0 +1,115 @@
+package libssh
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForward(t *testing.T) {
+	forward, err := ParseForward("6262")
+	require.NoError(t, err)
+	require.Equal(t, &Forward{BindAddress: "localhost", Port: 6262, Host: "localhost", HostPort: 6262}, forward)
+
+	forward, err = ParseForward("0:10.0.0.5:443")
+	require.NoError(t, err)
+	require.Equal(t, &Forward{BindAddress: "localhost", Port: 0, Host: "10.0.0.5", HostPort: 443}, forward)
+
+	forward, err = ParseForward("0.0.0.0:8080:db:5432")
+	require.NoError(t, err)
+	require.Equal(t, "0.0.0.0:8080", forward.BindAddr())
+	require.Equal(t, "db:5432", forward.TargetAddr())
+
+	for _, spec := range []string{"", "0", "http", "8080:0", "8080:99999", ":8080:db:5432", "a:1:b:2:3"} {
+		_, err = ParseForward(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+// newEchoServer returns the address of a server which echoes each line it receives
+func newEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					_, _ = conn.Write([]byte("echo " + scanner.Text() + "\n"))
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func requireEcho(t *testing.T, addr string) {
+	reply, err := echo(addr)
+	require.NoError(t, err)
+	require.Equal(t, "echo ping\n", reply)
+}
+
+func echo(addr string) (string, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err = conn.Write([]byte("ping\n")); err != nil {
+		return "", err
+	}
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestLocalForward(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestPool(t).Get(&testFactory{address: server.listener.Addr().String()})
+	target := newEchoServer(t)
+
+	tunnel, err := client.LocalForward("127.0.0.1:0", target)
+	require.NoError(t, err)
+	defer func() { _ = tunnel.Close() }()
+
+	requireEcho(t, tunnel.Addr().String())
+	server.disconnectAll()
+	requireEcho(t, tunnel.Addr().String())
+
+	require.Equal(t, int32(2), server.dials.Load())
+	require.Equal(t, int32(2), server.tunnels.Load())
+
+	require.NoError(t, tunnel.Close())
+	_, err = net.Dial("tcp", tunnel.Addr().String())
+	require.Error(t, err)
+}
+
+func TestRemoteForward(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestPool(t).Get(&testFactory{address: server.listener.Addr().String()})
+	target := newEchoServer(t)
+
+	tunnel, err := client.RemoteForward("127.0.0.1:0", target)
+	require.NoError(t, err)
+	defer func() { _ = tunnel.Close() }()
+
+	addr := tunnel.Addr().String()
+	requireEcho(t, addr)
+
+	server.disconnectAll()
+	require.Eventually(t, func() bool {
+		reply, err := echo(addr)
+		return err == nil && reply == "echo ping\n"
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, addr, tunnel.Addr().String())
+	require.Equal(t, int32(2), server.dials.Load())
+}
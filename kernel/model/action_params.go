@@ -0,0 +1,290 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type ParamType string
+
+const (
+	ParamTypeString   ParamType = "string"
+	ParamTypeInt      ParamType = "int"
+	ParamTypeFloat    ParamType = "float"
+	ParamTypeBool     ParamType = "bool"
+	ParamTypeDuration ParamType = "duration"
+)
+
+// ActionParam describes a named, typed argument accepted by an action
+type ActionParam struct {
+	Name        string
+	Type        ParamType
+	Default     string
+	Description string
+	Required    bool
+}
+
+func (self *ActionParam) parse(value string) (interface{}, error) {
+	switch self.Type {
+	case ParamTypeString, "":
+		return value, nil
+	case ParamTypeInt:
+		return strconv.Atoi(value)
+	case ParamTypeFloat:
+		return strconv.ParseFloat(value, 64)
+	case ParamTypeBool:
+		return strconv.ParseBool(value)
+	case ParamTypeDuration:
+		return time.ParseDuration(value)
+	}
+	return nil, errors.Errorf("unsupported parameter type [%s]", self.Type)
+}
+
+func (self *ActionParam) String() string {
+	result := fmt.Sprintf("%s (%s", self.Name, self.Type)
+	if self.Required {
+		result += ", required"
+	} else if self.Default != "" {
+		result += ", default " + self.Default
+	}
+	result += ")"
+	if self.Description != "" {
+		result += ": " + self.Description
+	}
+	return result
+}
+
+func StringParam(name, defaultValue, description string) *ActionParam {
+	return &ActionParam{Name: name, Type: ParamTypeString, Default: defaultValue, Description: description}
+}
+
+func IntParam(name string, defaultValue int, description string) *ActionParam {
+	return &ActionParam{Name: name, Type: ParamTypeInt, Default: strconv.Itoa(defaultValue), Description: description}
+}
+
+func FloatParam(name string, defaultValue float64, description string) *ActionParam {
+	return &ActionParam{Name: name, Type: ParamTypeFloat, Default: strconv.FormatFloat(defaultValue, 'f', -1, 64), Description: description}
+}
+
+func BoolParam(name string, defaultValue bool, description string) *ActionParam {
+	return &ActionParam{Name: name, Type: ParamTypeBool, Default: strconv.FormatBool(defaultValue), Description: description}
+}
+
+func DurationParam(name string, defaultValue time.Duration, description string) *ActionParam {
+	return &ActionParam{Name: name, Type: ParamTypeDuration, Default: defaultValue.String(), Description: description}
+}
+
+// RequiredParam declares a parameter which must be supplied when the action is executed
+func RequiredParam(name string, paramType ParamType, description string) *ActionParam {
+	return &ActionParam{Name: name, Type: paramType, Description: description, Required: true}
+}
+
+// A ParameterizedAction is an action which accepts named arguments. The arguments are available
+// to the action via GetArgs
+type ParameterizedAction interface {
+	Action
+	GetParams() []*ActionParam
+}
+
+// WithParams declares the parameters accepted by the given action
+func WithParams(action Action, params ...*ActionParam) ParameterizedAction {
+	return &parameterizedAction{
+		Action: action,
+		params: params,
+	}
+}
+
+type parameterizedAction struct {
+	Action
+	params []*ActionParam
+}
+
+func (self *parameterizedAction) GetParams() []*ActionParam {
+	return self.params
+}
+
+// GetActionParams returns the parameters declared by the given action, or nil if it doesn't accept any
+func GetActionParams(action Action) []*ActionParam {
+	if parameterized, ok := action.(ParameterizedAction); ok {
+		return parameterized.GetParams()
+	}
+	return nil
+}
+
+// ActionArgs holds the typed argument values for an action execution
+type ActionArgs struct {
+	values map[string]interface{}
+}
+
+// ResolveArgs validates the given raw argument values against the parameters, converting them to
+// the declared types and filling in defaults. Values which don't match a parameter are ignored, so
+// the same set of raw values can be resolved against multiple actions.
+func ResolveArgs(params []*ActionParam, raw map[string]string) (*ActionArgs, error) {
+	result := &ActionArgs{values: map[string]interface{}{}}
+	for _, param := range params {
+		value, found := raw[param.Name]
+		if !found {
+			if param.Required {
+				return nil, errors.Errorf("missing required argument [%s]", param.Name)
+			}
+			value = param.Default
+			if value == "" && param.Type != ParamTypeString && param.Type != "" {
+				continue
+			}
+		}
+		typedValue, err := param.parse(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value [%s] for argument [%s] of type %s", value, param.Name, param.Type)
+		}
+		result.values[param.Name] = typedValue
+	}
+	return result, nil
+}
+
+// ParseArgs parses arguments of the form name=value
+func ParseArgs(args []string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, arg := range args {
+		name, value, found := strings.Cut(arg, "=")
+		if !found || name == "" {
+			return nil, errors.Errorf("invalid argument [%s], must be of the form name=value", arg)
+		}
+		result[name] = value
+	}
+	return result, nil
+}
+
+func (self *ActionArgs) Has(name string) bool {
+	if self == nil {
+		return false
+	}
+	_, found := self.values[name]
+	return found
+}
+
+func (self *ActionArgs) Get(name string) (interface{}, bool) {
+	if self == nil {
+		return nil, false
+	}
+	value, found := self.values[name]
+	return value, found
+}
+
+func (self *ActionArgs) Names() []string {
+	var result []string
+	if self != nil {
+		for name := range self.values {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (self *ActionArgs) GetString(name string) string {
+	value, _ := self.Get(name)
+	result, _ := value.(string)
+	return result
+}
+
+func (self *ActionArgs) GetInt(name string) int {
+	value, _ := self.Get(name)
+	result, _ := value.(int)
+	return result
+}
+
+func (self *ActionArgs) GetFloat(name string) float64 {
+	value, _ := self.Get(name)
+	result, _ := value.(float64)
+	return result
+}
+
+func (self *ActionArgs) GetBool(name string) bool {
+	value, _ := self.Get(name)
+	result, _ := value.(bool)
+	return result
+}
+
+func (self *ActionArgs) GetDuration(name string) time.Duration {
+	value, _ := self.Get(name)
+	result, _ := value.(time.Duration)
+	return result
+}
+
+// RunWithArgs returns a run which exposes the given arguments through GetArgs
+func RunWithArgs(run Run, args *ActionArgs) Run {
+	return &argsRun{
+		Run:  run,
+		args: args,
+	}
+}
+
+type argsRun struct {
+	Run
+	args *ActionArgs
+}
+
+func (self *argsRun) GetArgs() *ActionArgs {
+	return self.args
+}
+
+func (self *argsRun) Unwrap() Run {
+	return self.Run
+}
+
+// GetArgs returns the arguments the action is being executed with. Runs provide arguments by implementing
+// GetArgs() *ActionArgs, as the runs returned by RunWithArgs do. If the run, or any run it wraps, doesn't
+// provide arguments, empty arguments are returned, so every parameter reads as its zero value.
+func GetArgs(run Run) *ActionArgs {
+	if r, ok := findRun[interface{ GetArgs() *ActionArgs }](run); ok {
+		return r.GetArgs()
+	}
+	return &ActionArgs{}
+}
+
+// findRun returns the first run implementing T, looking through runs which wrap another run by implementing
+// Unwrap() Run
+func findRun[T any](run Run) (T, bool) {
+	for run != nil {
+		if result, ok := run.(T); ok {
+			return result, true
+		}
+		wrapper, ok := run.(interface{ Unwrap() Run })
+		if !ok {
+			break
+		}
+		run = wrapper.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// ExecuteWithArgs resolves the raw arguments against the parameters declared by the action and executes it
+func ExecuteWithArgs(run Run, action Action, raw map[string]string) error {
+	args, err := ResolveArgs(GetActionParams(action), raw)
+	if err != nil {
+		return err
+	}
+	return action.Execute(RunWithArgs(run, args))
+}
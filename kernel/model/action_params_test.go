@@ -0,0 +1,90 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveArgs(t *testing.T) {
+	req := require.New(t)
+
+	params := []*ActionParam{
+		IntParam("batch", 1, "number of routers to restart at once"),
+		StringParam("selector", ".router", "routers to restart"),
+		DurationParam("pause", 5*time.Second, "time to wait between batches"),
+		BoolParam("verify", true, "verify routers are up after each batch"),
+	}
+
+	args, err := ResolveArgs(params, map[string]string{"batch": "5", "selector": ".edge", "other": "ignored"})
+	req.NoError(err)
+	req.Equal(5, args.GetInt("batch"))
+	req.Equal(".edge", args.GetString("selector"))
+	req.Equal(5*time.Second, args.GetDuration("pause"))
+	req.True(args.GetBool("verify"))
+	req.False(args.Has("other"))
+
+	_, err = ResolveArgs(params, map[string]string{"batch": "five"})
+	req.ErrorContains(err, "argument [batch]")
+
+	_, err = ResolveArgs([]*ActionParam{RequiredParam("target", ParamTypeString, "")}, nil)
+	req.ErrorContains(err, "missing required argument [target]")
+}
+
+func TestParseArgs(t *testing.T) {
+	req := require.New(t)
+
+	raw, err := ParseArgs([]string{"batch=5", "filter=a=b"})
+	req.NoError(err)
+	req.Equal(map[string]string{"batch": "5", "filter": "a=b"}, raw)
+
+	_, err = ParseArgs([]string{"batch"})
+	req.Error(err)
+}
+
+func TestExecuteWithArgs(t *testing.T) {
+	req := require.New(t)
+
+	var batch int
+	action := WithParams(ActionFunc(func(run Run) error {
+		batch = GetArgs(run).GetInt("batch")
+		return nil
+	}), IntParam("batch", 2, ""))
+
+	req.NoError(ExecuteWithArgs(&runImpl{}, action, nil))
+	req.Equal(2, batch)
+
+	req.NoError(ExecuteWithArgs(&runImpl{}, action, map[string]string{"batch": "7"}))
+	req.Equal(7, batch)
+}
+
+func TestGetArgs(t *testing.T) {
+	req := require.New(t)
+
+	// runs which don't provide arguments read every parameter as its zero value
+	req.Equal(0, GetArgs(&runImpl{}).GetInt("batch"))
+
+	args, err := ResolveArgs([]*ActionParam{IntParam("batch", 2, "")}, nil)
+	req.NoError(err)
+	req.Equal(2, GetArgs(RunWithArgs(&runImpl{}, args)).GetInt("batch"))
+
+	// arguments are found through runs which wrap the run providing them
+	req.Equal(2, GetArgs(RunWithResult(RunWithArgs(&runImpl{}, args), nil)).GetInt("batch"))
+}
@@ -0,0 +1,192 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package model
+
+import (
+	"sync"
+	"time"
+)
+
+// ActionResult collects the structured result of an action execution. Actions can add per-entity
+// outcomes and values using the result returned from Run.GetResult. All methods are safe to call
+// on a nil result, so actions don't need to check whether results are being collected.
+type ActionResult struct {
+	Action   string         `json:"action"`
+	Success  bool           `json:"success"`
+	Error    string         `json:"error,omitempty"`
+	Start    time.Time      `json:"start"`
+	Duration time.Duration  `json:"duration"`
+	Values   map[string]any `json:"values,omitempty"`
+	Outcomes []*Outcome     `json:"outcomes,omitempty"`
+	lock     sync.Mutex
+}
+
+// Outcome is the result of an action for a single entity, such as a host or a component
+type Outcome struct {
+	EntityType string         `json:"entityType"`
+	EntityId   string         `json:"entityId"`
+	Success    bool           `json:"success"`
+	Error      string         `json:"error,omitempty"`
+	Duration   time.Duration  `json:"duration"`
+	Values     map[string]any `json:"values,omitempty"`
+	lock       sync.Mutex
+}
+
+func NewActionResult(action string) *ActionResult {
+	return &ActionResult{
+		Action: action,
+		Start:  time.Now(),
+	}
+}
+
+// Complete marks the result as finished, recording the duration and any error
+func (self *ActionResult) Complete(err error) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.Duration = time.Since(self.Start)
+	self.Success = err == nil
+	if err != nil {
+		self.Error = err.Error()
+	}
+}
+
+func (self *ActionResult) SetValue(name string, value any) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.Values == nil {
+		self.Values = map[string]any{}
+	}
+	self.Values[name] = value
+}
+
+func (self *ActionResult) AddOutcome(outcome *Outcome) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.Outcomes = append(self.Outcomes, outcome)
+}
+
+// GetOutcomes returns a snapshot of the outcomes recorded so far
+func (self *ActionResult) GetOutcomes() []*Outcome {
+	if self == nil {
+		return nil
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]*Outcome(nil), self.Outcomes...)
+}
+
+// FailedOutcomes returns the outcomes which were not successful
+func (self *ActionResult) FailedOutcomes() []*Outcome {
+	var result []*Outcome
+	for _, outcome := range self.GetOutcomes() {
+		if !outcome.Success {
+			result = append(result, outcome)
+		}
+	}
+	return result
+}
+
+func (self *Outcome) SetValue(name string, value any) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.Values == nil {
+		self.Values = map[string]any{}
+	}
+	self.Values[name] = value
+}
+
+// RecordOutcome runs f for the given entity, adding an outcome with its timing and error to the
+// current action result. The error from f is returned unchanged.
+func RecordOutcome(run Run, entity Entity, f func(outcome *Outcome) error) error {
+	outcome := &Outcome{
+		EntityType: entity.GetType(),
+		EntityId:   GetEntityPathId(entity),
+	}
+	start := time.Now()
+	err := f(outcome)
+	outcome.Duration = time.Since(start)
+	outcome.Success = err == nil
+	if err != nil {
+		outcome.Error = err.Error()
+	}
+	GetResult(run).AddOutcome(outcome)
+	return err
+}
+
+// GetEntityPathId returns a dotted id for the entity which includes its ancestors, excluding the model
+func GetEntityPathId(entity Entity) string {
+	path := GetScopedEntityPath(entity)
+	result := path[0]
+	for _, id := range path[1:] {
+		result += "." + id
+	}
+	return result
+}
+
+// RunWithResult returns a run which exposes the given result through GetResult
+func RunWithResult(run Run, result *ActionResult) Run {
+	return &resultRun{
+		Run:    run,
+		result: result,
+	}
+}
+
+type resultRun struct {
+	Run
+	result *ActionResult
+}
+
+func (self *resultRun) GetResult() *ActionResult {
+	return self.result
+}
+
+func (self *resultRun) Unwrap() Run {
+	return self.Run
+}
+
+// GetResult returns the result collecting the values and outcomes of the action being executed. Runs collect
+// results by implementing GetResult() *ActionResult, as the runs returned by RunWithResult do. If the run, or any
+// run it wraps, doesn't collect results, a new result is returned, so values and outcomes can still be recorded
+// but are discarded.
+func GetResult(run Run) *ActionResult {
+	if r, ok := findRun[interface{ GetResult() *ActionResult }](run); ok {
+		if result := r.GetResult(); result != nil {
+			return result
+		}
+	}
+	return &ActionResult{}
+}
+
+// ExecuteWithResult resolves the raw arguments for the action, executes it and returns the collected result
+func ExecuteWithResult(run Run, name string, action Action, raw map[string]string) (*ActionResult, error) {
+	result := NewActionResult(name)
+	err := ExecuteWithArgs(RunWithResult(run, result), action, raw)
+	result.Complete(err)
+	return result, err
+}
@@ -0,0 +1,90 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteWithResult(t *testing.T) {
+	req := require.New(t)
+
+	m := &Model{
+		Id: "test",
+		Regions: Regions{
+			"us-east-1": {
+				Hosts: Hosts{
+					"ctrl":   {Components: Components{"ctrl1": {}}},
+					"router": {Components: Components{"router1": {}}},
+				},
+			},
+		},
+	}
+	req.NoError(m.init())
+
+	action := ActionFunc(func(run Run) error {
+		GetResult(run).SetValue("total", 2)
+		return m.ForEachComponent("*", 2, func(c *Component) error {
+			return RecordOutcome(run, c, func(outcome *Outcome) error {
+				outcome.SetValue("checked", true)
+				if c.Id == "router1" {
+					return errors.New("not running")
+				}
+				return nil
+			})
+		})
+	})
+
+	result, err := ExecuteWithResult(&runImpl{}, "verify", action, nil)
+	req.Error(err)
+	req.Equal("verify", result.Action)
+	req.False(result.Success)
+	req.Equal(2, result.Values["total"])
+	req.Len(result.GetOutcomes(), 2)
+
+	failed := result.FailedOutcomes()
+	req.Len(failed, 1)
+	req.Equal(EntityTypeComponent, failed[0].EntityType)
+	req.Equal("us-east-1.router.router1", failed[0].EntityId)
+	req.Equal("not running", failed[0].Error)
+	req.Equal(true, failed[0].Values["checked"])
+}
+
+func TestNilResultIsSafe(t *testing.T) {
+	var result *ActionResult
+	result.SetValue("a", 1)
+	result.AddOutcome(&Outcome{})
+	result.Complete(nil)
+	require.Empty(t, result.GetOutcomes())
+}
+
+func TestGetResult(t *testing.T) {
+	req := require.New(t)
+
+	// runs which don't collect results still accept values and outcomes
+	req.NotNil(GetResult(&runImpl{}))
+	host := &Host{Id: "ctrl", Region: &Region{Id: "us-east-1"}}
+	req.NoError(RecordOutcome(&runImpl{}, host, func(outcome *Outcome) error {
+		return nil
+	}))
+
+	result := NewActionResult("verify")
+	req.Same(result, GetResult(RunWithArgs(RunWithResult(&runImpl{}, result), &ActionArgs{})))
+}
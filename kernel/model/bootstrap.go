@@ -108,6 +108,14 @@ func bootstrapModel() error {
 			}
 		}
 
+		if err := model.validateComponentDependencies(); err != nil {
+			return err
+		}
+
+		if err := model.validateSshConfig(); err != nil {
+			return err
+		}
+
 		model.actions = make(map[string]Action)
 		for name, binder := range model.Actions {
 			model.actions[name] = binder(model)
@@ -63,6 +63,15 @@ type ServerComponent interface {
 	Start(run Run, c *Component) error
 }
 
+// A ProcessComponent is able to identify the processes which represent it on its host. This allows
+// the processes to be signalled directly, for example when injecting faults.
+type ProcessComponent interface {
+	ComponentType
+
+	// GetProcessFilter returns a filter matching process listing lines which belong to the component
+	GetProcessFilter(c *Component) func(string) bool
+}
+
 // A FileStagingComponent is able to contribute files to the staging area, to be synced
 // up to the components host. This may include things like binaries, scripts, configuration
 // files and PKI.
@@ -142,6 +151,7 @@ type Component struct {
 	Host        *Host
 	AWS         aws.Component
 	Type        ComponentType
+	DependsOn   []string // selectors for components which must be started before, and stopped after, this one
 	Index       uint32
 	ScaleIndex  uint32
 	initialized atomic.Bool
@@ -153,6 +163,7 @@ func (component *Component) CloneComponent(scaleIndex uint32) *Component {
 		Id:         component.Id,
 		Type:       component.Type,
 		Host:       component.Host,
+		DependsOn:  append([]string(nil), component.DependsOn...),
 		Index:      component.GetModel().GetNextComponentIndex(),
 		ScaleIndex: scaleIndex,
 	}
@@ -277,3 +288,35 @@ func (component *Component) IsRunning(run Run) (bool, error) {
 	}
 	return component.Type.IsRunning(run, component)
 }
+
+// Start starts the component, if it's a ServerComponent, and notifies the model's ComponentStateHandlers
+func (component *Component) Start(run Run) error {
+	startable, ok := component.Type.(ServerComponent)
+	if !ok {
+		return errors.Errorf("component [%s] can't be started", component.Id)
+	}
+	if err := startable.Start(run, component); err != nil {
+		return err
+	}
+	component.GetModel().AcceptComponentState(component, true)
+	return nil
+}
+
+// Stop stops the component and notifies the model's ComponentStateHandlers
+func (component *Component) Stop(run Run) error {
+	if component.Type == nil {
+		return errors.Errorf("component [%s] has no component type defined", component.Id)
+	}
+	if err := component.Type.Stop(run, component); err != nil {
+		return err
+	}
+	component.GetModel().AcceptComponentState(component, false)
+	return nil
+}
+
+// A ComponentStateHandler is notified when a component is started or stopped through Component.Start or
+// Component.Stop. Components may also start or stop for other reasons, so handlers which need an accurate
+// picture should also poll Component.IsRunning.
+type ComponentStateHandler interface {
+	AcceptComponentState(c *Component, running bool)
+}
@@ -0,0 +1,152 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package model
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// GetComponentDependencies returns the components matched by the DependsOn selectors of the given component.
+// A component is never considered a dependency of itself.
+func (m *Model) GetComponentDependencies(c *Component) []*Component {
+	var result []*Component
+	seen := map[*Component]struct{}{}
+	for _, spec := range c.DependsOn {
+		for _, dep := range m.SelectComponents(spec) {
+			if _, found := seen[dep]; !found && dep != c {
+				seen[dep] = struct{}{}
+				result = append(result, dep)
+			}
+		}
+	}
+	return result
+}
+
+// OrderComponents arranges the given components into layers, such that each component only depends on
+// components in earlier layers. Components in the same layer are independent of each other. Dependencies
+// on components outside the given set are ignored, as they aren't being acted on. If reverse is true, the
+// layers are returned in reverse order, which is the order in which components should be stopped.
+func (m *Model) OrderComponents(components []*Component, reverse bool) ([][]*Component, error) {
+	inSet := map[*Component]struct{}{}
+	for _, c := range components {
+		inSet[c] = struct{}{}
+	}
+
+	pending := map[*Component]int{}
+	dependents := map[*Component][]*Component{}
+	for _, c := range components {
+		for _, dep := range m.GetComponentDependencies(c) {
+			if _, found := inSet[dep]; found {
+				pending[c]++
+				dependents[dep] = append(dependents[dep], c)
+			}
+		}
+	}
+
+	var layers [][]*Component
+	var current []*Component
+	for _, c := range components {
+		if pending[c] == 0 {
+			current = append(current, c)
+		}
+	}
+
+	ordered := 0
+	for len(current) > 0 {
+		layers = append(layers, current)
+		ordered += len(current)
+		var next []*Component
+		for _, c := range current {
+			for _, dependent := range dependents[c] {
+				pending[dependent]--
+				if pending[dependent] == 0 {
+					next = append(next, dependent)
+				}
+			}
+		}
+		current = m.sortLike(components, next)
+	}
+
+	if ordered != len(components) {
+		var cycle []string
+		for _, c := range components {
+			if pending[c] > 0 {
+				cycle = append(cycle, c.GetPathId())
+			}
+		}
+		return nil, errors.Errorf("dependency cycle between components [%s]", strings.Join(cycle, ", "))
+	}
+
+	if reverse {
+		for i, j := 0, len(layers)-1; i < j; i, j = i+1, j-1 {
+			layers[i], layers[j] = layers[j], layers[i]
+		}
+	}
+
+	return layers, nil
+}
+
+// sortLike returns the subset in the same relative order as the components appear in the reference list
+func (m *Model) sortLike(reference []*Component, subset []*Component) []*Component {
+	if len(subset) < 2 {
+		return subset
+	}
+	members := map[*Component]struct{}{}
+	for _, c := range subset {
+		members[c] = struct{}{}
+	}
+	result := make([]*Component, 0, len(subset))
+	for _, c := range reference {
+		if _, found := members[c]; found {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// ForEachComponentOrdered runs f on the selected components in dependency order. Components in the same
+// dependency layer are processed with the given concurrency. If reverse is true, dependents are processed
+// before their dependencies, as is appropriate when stopping components.
+func (m *Model) ForEachComponentOrdered(spec string, concurrency int, reverse bool, f func(c *Component) error) error {
+	layers, err := m.OrderComponents(m.SelectComponents(spec), reverse)
+	if err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		if err = m.ForEachComponentIn(layer, concurrency, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateComponentDependencies checks that all component dependencies can be ordered, reporting cycles
+func (m *Model) validateComponentDependencies() error {
+	components := m.SelectComponents("*")
+	for _, c := range components {
+		for _, spec := range c.DependsOn {
+			if len(m.SelectComponents(spec)) == 0 {
+				logrus.Warnf("component [%s] dependency [%s] doesn't match any components", c.GetPathId(), spec)
+			}
+		}
+	}
+	_, err := m.OrderComponents(components, false)
+	return err
+}
@@ -0,0 +1,112 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package model
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func createDependencyTestModel() *Model {
+	return &Model{
+		Id: "test",
+		Regions: Regions{
+			"us-east-1": {
+				Hosts: Hosts{
+					"ctrl": {Components: Components{
+						"ctrl1": {Scope: Scope{Tags: Tags{"ctrl"}}},
+					}},
+					"router": {Components: Components{
+						"router1": {Scope: Scope{Tags: Tags{"router"}}, DependsOn: []string{".ctrl"}},
+						"router2": {Scope: Scope{Tags: Tags{"router"}}, DependsOn: []string{".ctrl"}},
+					}},
+					"client": {Components: Components{
+						"client1": {DependsOn: []string{".router", "client1"}},
+						"metrics": {},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func layerIds(layers [][]*Component) [][]string {
+	var result [][]string
+	for _, layer := range layers {
+		var ids []string
+		for _, c := range layer {
+			ids = append(ids, c.Id)
+		}
+		result = append(result, ids)
+	}
+	return result
+}
+
+func TestOrderComponents(t *testing.T) {
+	req := require.New(t)
+	m := createDependencyTestModel()
+	req.NoError(m.init())
+	req.NoError(m.validateComponentDependencies())
+
+	layers, err := m.OrderComponents(m.SelectComponents("*"), false)
+	req.NoError(err)
+	req.Equal([][]string{{"metrics", "ctrl1"}, {"router1", "router2"}, {"client1"}}, layerIds(layers))
+
+	layers, err = m.OrderComponents(m.SelectComponents("*"), true)
+	req.NoError(err)
+	req.Equal([][]string{{"client1"}, {"router1", "router2"}, {"metrics", "ctrl1"}}, layerIds(layers))
+
+	// dependencies outside the selection don't affect ordering
+	layers, err = m.OrderComponents(m.SelectComponents(".router"), false)
+	req.NoError(err)
+	req.Equal([][]string{{"router1", "router2"}}, layerIds(layers))
+}
+
+func TestOrderComponentsCycle(t *testing.T) {
+	req := require.New(t)
+	m := createDependencyTestModel()
+	req.NoError(m.init())
+
+	ctrl, err := m.SelectComponent("ctrl1")
+	req.NoError(err)
+	ctrl.DependsOn = []string{"client1"}
+	err = m.validateComponentDependencies()
+	req.Error(err)
+	req.Contains(err.Error(), "dependency cycle")
+	req.Contains(err.Error(), "ctrl1")
+	req.NotContains(err.Error(), "metrics")
+}
+
+func TestForEachComponentOrdered(t *testing.T) {
+	req := require.New(t)
+	m := createDependencyTestModel()
+	req.NoError(m.init())
+
+	var lock sync.Mutex
+	var order []string
+	req.NoError(m.ForEachComponentOrdered("*", 3, true, func(c *Component) error {
+		lock.Lock()
+		defer lock.Unlock()
+		order = append(order, c.Id)
+		return nil
+	}))
+	req.Equal("client1", order[0])
+	req.ElementsMatch([]string{"router1", "router2"}, order[1:3])
+	req.ElementsMatch([]string{"ctrl1", "metrics"}, order[3:])
+}
@@ -0,0 +1,427 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// DisruptionsFilename is the name of the file in the instance working directory which records active disruptions
+const DisruptionsFilename = "disruptions.yml"
+
+// A Disruption is a network rule installed on a host, which must be removed to restore normal operation
+type Disruption struct {
+	Host     string      `yaml:"host"`
+	Firewall string      `yaml:"firewall"`
+	Rule     NetworkRule `yaml:"rule"`
+	Run      string      `yaml:"run,omitempty"`
+	Created  time.Time   `yaml:"created"`
+}
+
+func (self *Disruption) matches(other *Disruption) bool {
+	return self.Host == other.Host && self.Rule == other.Rule
+}
+
+func (self *Disruption) unblockCmd() string {
+	switch self.Firewall {
+	case nftablesFirewall{}.name():
+		return nftablesFirewall{}.unblockCmd(self.Rule)
+	default:
+		return iptablesFirewall{}.unblockCmd(self.Rule)
+	}
+}
+
+// disruptionRecord is an entry in the disruptions file. Removals are appended as records with Removed set, which
+// cancel one earlier matching record, so that recording a disruption doesn't rewrite the file.
+type disruptionRecord struct {
+	Disruption `yaml:",inline"`
+	Removed    bool `yaml:"removed,omitempty"`
+}
+
+// DisruptionRegistry persists the disruptions installed on hosts, so they can be listed and removed, even if
+// the process which created them exited without cleaning up. Access is serialized across processes by locking a
+// lock file next to the registry file, as the registry file itself is replaced and removed. All methods are safe
+// to call on a nil registry.
+type DisruptionRegistry struct {
+	path string
+	lock sync.Mutex
+}
+
+func NewDisruptionRegistry(path string) *DisruptionRegistry {
+	return &DisruptionRegistry{path: path}
+}
+
+var disruptionRegistry struct {
+	sync.Mutex
+	registry *DisruptionRegistry
+	runId    string
+}
+
+// setDisruptionRunId sets the run id recorded with disruptions created by this process
+func setDisruptionRunId(runId string) {
+	disruptionRegistry.Lock()
+	defer disruptionRegistry.Unlock()
+	disruptionRegistry.runId = runId
+}
+
+func getDisruptionRunId() string {
+	disruptionRegistry.Lock()
+	defer disruptionRegistry.Unlock()
+	return disruptionRegistry.runId
+}
+
+// GetDisruptionRegistry returns the registry for the active instance, or nil if there is no active instance
+func GetDisruptionRegistry() *DisruptionRegistry {
+	disruptionRegistry.Lock()
+	defer disruptionRegistry.Unlock()
+
+	if instanceConfig == nil || instanceConfig.WorkingDirectory == "" {
+		return nil
+	}
+
+	path := filepath.Join(instanceConfig.WorkingDirectory, DisruptionsFilename)
+	if disruptionRegistry.registry == nil || disruptionRegistry.registry.path != path {
+		disruptionRegistry.registry = NewDisruptionRegistry(path)
+	}
+	return disruptionRegistry.registry
+}
+
+// List returns the recorded disruptions
+func (self *DisruptionRegistry) List() ([]*Disruption, error) {
+	if self == nil {
+		return nil, nil
+	}
+	var result []*Disruption
+	err := self.withLock(func() error {
+		var err error
+		result, err = self.load()
+		return err
+	})
+	return result, err
+}
+
+// Add records the given disruptions, appending them to the file
+func (self *DisruptionRegistry) Add(disruptions ...*Disruption) error {
+	if self == nil || len(disruptions) == 0 {
+		return nil
+	}
+	return self.withLock(func() error {
+		return self.append(disruptions, false)
+	})
+}
+
+// Remove removes one matching record for each of the given disruptions. Removals are appended to the file, which
+// is only removed once no disruptions are left.
+func (self *DisruptionRegistry) Remove(disruptions ...*Disruption) error {
+	if self == nil || len(disruptions) == 0 {
+		return nil
+	}
+	return self.withLock(func() error {
+		current, err := self.load()
+		if err != nil {
+			return err
+		}
+		if len(removeDisruptions(current, disruptions)) == 0 {
+			return self.save(nil)
+		}
+		return self.append(disruptions, true)
+	})
+}
+
+// removeDisruptions removes one matching disruption from current for each of the given disruptions. A record from
+// the same run is preferred, so that removing a rule doesn't remove the record of another run which installed the
+// same rule.
+func removeDisruptions(current []*Disruption, disruptions []*Disruption) []*Disruption {
+	for _, d := range disruptions {
+		match := -1
+		for idx, existing := range current {
+			if existing.matches(d) && (match == -1 || existing.Run == d.Run) {
+				match = idx
+				if existing.Run == d.Run {
+					break
+				}
+			}
+		}
+		if match != -1 {
+			current = append(current[:match], current[match+1:]...)
+		}
+	}
+	return current
+}
+
+// RemoveHost removes all records for the host with the given id
+func (self *DisruptionRegistry) RemoveHost(hostId string) error {
+	return self.update(func(current []*Disruption) []*Disruption {
+		var result []*Disruption
+		for _, d := range current {
+			if d.Host != hostId {
+				result = append(result, d)
+			}
+		}
+		return result
+	})
+}
+
+func (self *DisruptionRegistry) update(f func([]*Disruption) []*Disruption) error {
+	if self == nil {
+		return nil
+	}
+	return self.withLock(func() error {
+		current, err := self.load()
+		if err != nil {
+			return err
+		}
+		return self.save(f(current))
+	})
+}
+
+// withLock runs f holding the registry lock, both within this process and across processes sharing the registry
+func (self *DisruptionRegistry) withLock(f func() error) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	lockPath := self.path + ".lock"
+	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open disruptions lock file [%s] (%w)", lockPath, err)
+	}
+	defer func() { _ = lockFile.Close() }()
+
+	if err = lockExclusive(lockFile); err != nil {
+		return fmt.Errorf("unable to lock disruptions lock file [%s] (%w)", lockPath, err)
+	}
+	defer func() { _ = unlock(lockFile) }()
+
+	return f()
+}
+
+func (self *DisruptionRegistry) load() ([]*Disruption, error) {
+	data, err := os.ReadFile(self.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read disruptions file [%s] (%w)", self.path, err)
+	}
+	var records []*disruptionRecord
+	if err = yaml.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("unable to parse disruptions file [%s] (%w)", self.path, err)
+	}
+	var result []*Disruption
+	for _, record := range records {
+		if record.Removed {
+			result = removeDisruptions(result, []*Disruption{&record.Disruption})
+		} else {
+			result = append(result, &record.Disruption)
+		}
+	}
+	return result, nil
+}
+
+// append adds records for the disruptions to the end of the file. The file is a yaml list, so appending items
+// keeps it valid.
+func (self *DisruptionRegistry) append(disruptions []*Disruption, removed bool) error {
+	var records []*disruptionRecord
+	for _, d := range disruptions {
+		records = append(records, &disruptionRecord{Disruption: *d, Removed: removed})
+	}
+	data, err := yaml.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(self.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open disruptions file [%s] (%w)", self.path, err)
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("unable to write disruptions file [%s] (%w)", self.path, err)
+	}
+	return f.Close()
+}
+
+func (self *DisruptionRegistry) save(disruptions []*Disruption) error {
+	if len(disruptions) == 0 {
+		if err := os.Remove(self.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove disruptions file [%s] (%w)", self.path, err)
+		}
+		return nil
+	}
+
+	data, err := yaml.Marshal(disruptions)
+	if err != nil {
+		return err
+	}
+
+	// write and rename, so an interrupted write doesn't lose the existing records
+	tmpPath := self.path + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("unable to write disruptions file [%s] (%w)", tmpPath, err)
+	}
+	if err = os.Rename(tmpPath, self.path); err != nil {
+		return fmt.Errorf("unable to update disruptions file [%s] (%w)", self.path, err)
+	}
+	return nil
+}
+
+func (host *Host) newDisruptions(fw firewallBackend, rules []NetworkRule) []*Disruption {
+	var result []*Disruption
+	for _, rule := range rules {
+		result = append(result, &Disruption{
+			Host:     GetEntityPathId(host),
+			Firewall: fw.name(),
+			Rule:     rule,
+			Run:      getDisruptionRunId(),
+			Created:  time.Now(),
+		})
+	}
+	return result
+}
+
+func (host *Host) clearDisruptions(disruptions ...*Disruption) {
+	if err := GetDisruptionRegistry().Remove(disruptions...); err != nil {
+		logrus.WithField("hostId", host.Id).WithError(err).Error("unable to clear network disruption record")
+	}
+}
+
+// GetDisruptions returns the recorded disruptions for the host
+func (host *Host) GetDisruptions() ([]*Disruption, error) {
+	all, err := GetDisruptionRegistry().List()
+	if err != nil {
+		return nil, err
+	}
+	hostId := GetEntityPathId(host)
+	var result []*Disruption
+	for _, d := range all {
+		if d.Host == hostId {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+// HealDisruptions removes all recorded disruptions from the host, using a single remote command. Rules which no
+// longer exist are skipped, as rules are recorded before they're applied. If the command fails, for example
+// because the host is unreachable, the records are kept so that healing can be retried.
+func (host *Host) HealDisruptions() error {
+	return host.healDisruptions(nil)
+}
+
+// HealRunDisruptions removes the recorded disruptions which were created by the run with the given id from the host
+func (host *Host) HealRunDisruptions(runId string) error {
+	return host.healDisruptions(runFilter(runId))
+}
+
+func (host *Host) healDisruptions(filter func(*Disruption) bool) error {
+	disruptions, err := host.GetDisruptions()
+	if err != nil {
+		return err
+	}
+	disruptions = filterDisruptions(disruptions, filter)
+	if len(disruptions) == 0 {
+		return nil
+	}
+
+	var cmds []string
+	for _, d := range disruptions {
+		if cmd := d.unblockCmd(); cmd != "" {
+			cmds = append(cmds, ignoreMissingRuleCmd(cmd))
+		}
+	}
+
+	if output, err := host.ExecLogged(strings.Join(cmds, "; ")); err != nil {
+		return fmt.Errorf("failed to remove %d disruptions on [%s]: %w (output: %s)", len(cmds), host.PublicIp, err, output)
+	}
+	host.clearDisruptions(disruptions...)
+	logrus.WithField("hostId", host.Id).Infof("removed %d disruptions", len(disruptions))
+	return nil
+}
+
+// HealDisruptions removes all recorded disruptions from the selected hosts. Records for hosts which are no
+// longer part of the model are reported in the returned error.
+func (m *Model) HealDisruptions(hostSpec string, concurrency int) error {
+	return m.healDisruptions(hostSpec, concurrency, nil)
+}
+
+// HealRunDisruptions removes the recorded disruptions which were created by the run with the given id, leaving
+// disruptions recorded by other runs in place
+func (m *Model) HealRunDisruptions(runId string, concurrency int) error {
+	return m.healDisruptions("*", concurrency, runFilter(runId))
+}
+
+func (m *Model) healDisruptions(hostSpec string, concurrency int, filter func(*Disruption) bool) error {
+	disruptions, err := GetDisruptionRegistry().List()
+	if err != nil {
+		return err
+	}
+	disruptions = filterDisruptions(disruptions, filter)
+	if len(disruptions) == 0 {
+		return nil
+	}
+
+	known := map[string]struct{}{}
+	for _, host := range m.SelectHosts("*") {
+		known[GetEntityPathId(host)] = struct{}{}
+	}
+
+	var unknown []string
+	for _, d := range disruptions {
+		if _, found := known[d.Host]; !found {
+			known[d.Host] = struct{}{}
+			unknown = append(unknown, d.Host)
+		}
+	}
+
+	if err = m.ForEachHost(hostSpec, concurrency, func(host *Host) error {
+		return host.healDisruptions(filter)
+	}); err != nil {
+		return err
+	}
+
+	if len(unknown) > 0 && hostSpec == "*" {
+		return fmt.Errorf("disruptions recorded for hosts not in the model: %v", unknown)
+	}
+	return nil
+}
+
+func runFilter(runId string) func(*Disruption) bool {
+	return func(d *Disruption) bool {
+		return d.Run == runId
+	}
+}
+
+func filterDisruptions(disruptions []*Disruption, filter func(*Disruption) bool) []*Disruption {
+	if filter == nil {
+		return disruptions
+	}
+	var result []*Disruption
+	for _, d := range disruptions {
+		if filter(d) {
+			result = append(result, d)
+		}
+	}
+	return result
+}
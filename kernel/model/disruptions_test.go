@@ -0,0 +1,152 @@
+package model
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisruptionRegistry(t *testing.T) {
+	req := require.New(t)
+	registry := NewDisruptionRegistry(filepath.Join(t.TempDir(), DisruptionsFilename))
+
+	list, err := registry.List()
+	req.NoError(err)
+	req.Empty(list)
+
+	incoming := &Disruption{Host: "us-east-1.ctrl", Firewall: "iptables", Rule: IncomingRule(ProtocolTcp, SinglePort(6262))}
+	outgoing := &Disruption{Host: "us-east-1.router", Firewall: "nftables", Rule: OutgoingRule(ProtocolAll, "10.0.0.1", PortRange{})}
+	req.NoError(registry.Add(incoming, outgoing, outgoing))
+
+	// a new registry on the same file sees the same records, as a later process would
+	registry = NewDisruptionRegistry(registry.path)
+	list, err = registry.List()
+	req.NoError(err)
+	req.Len(list, 3)
+	req.Equal(incoming.Rule, list[0].Rule)
+	req.Equal(nftablesFirewall{}.unblockCmd(outgoing.Rule), list[1].unblockCmd())
+
+	// removing a rule removes one record, as each block call adds one iptables rule
+	req.NoError(registry.Remove(&Disruption{Host: "us-east-1.router", Rule: OutgoingRule(ProtocolAll, "10.0.0.1", PortRange{})}))
+	list, err = registry.List()
+	req.NoError(err)
+	req.Len(list, 2)
+
+	req.NoError(registry.RemoveHost("us-east-1.router"))
+	list, err = registry.List()
+	req.NoError(err)
+	req.Len(list, 1)
+	req.Equal(unblockIncomingCmd(6262), list[0].unblockCmd())
+
+	req.NoError(registry.RemoveHost("us-east-1.ctrl"))
+	req.NoFileExists(registry.path)
+}
+
+func TestNilDisruptionRegistry(t *testing.T) {
+	var registry *DisruptionRegistry
+	require.NoError(t, registry.Add(&Disruption{}))
+	require.NoError(t, registry.Remove(&Disruption{}))
+	list, err := registry.List()
+	require.NoError(t, err)
+	require.Empty(t, list)
+}
+
+func TestDisruptionRegistryAppends(t *testing.T) {
+	req := require.New(t)
+	registry := NewDisruptionRegistry(filepath.Join(t.TempDir(), DisruptionsFilename))
+
+	first := &Disruption{Host: "us-east-1.ctrl", Firewall: "iptables", Rule: IncomingRule(ProtocolTcp, SinglePort(6262))}
+	second := &Disruption{Host: "us-east-1.router", Firewall: "iptables", Rule: OutgoingRule(ProtocolAll, "10.0.0.1", PortRange{})}
+	req.NoError(registry.Add(first))
+	initial, err := os.ReadFile(registry.path)
+	req.NoError(err)
+
+	// adding and removing records appends to the file rather than rewriting it
+	req.NoError(registry.Add(second, second))
+	req.NoError(registry.Remove(second))
+	current, err := os.ReadFile(registry.path)
+	req.NoError(err)
+	req.True(bytes.HasPrefix(current, initial))
+
+	list, err := registry.List()
+	req.NoError(err)
+	req.Len(list, 2)
+	req.Equal(first.Rule, list[0].Rule)
+	req.Equal(second.Rule, list[1].Rule)
+
+	req.NoError(registry.Remove(first, second))
+	req.NoFileExists(registry.path)
+}
+
+func TestDisruptionRegistryRuns(t *testing.T) {
+	req := require.New(t)
+	registry := NewDisruptionRegistry(filepath.Join(t.TempDir(), DisruptionsFilename))
+
+	rule := IncomingRule(ProtocolTcp, SinglePort(6262))
+	first := &Disruption{Host: "us-east-1.ctrl", Firewall: "iptables", Rule: rule, Run: "1"}
+	second := &Disruption{Host: "us-east-1.ctrl", Firewall: "iptables", Rule: rule, Run: "2"}
+	req.NoError(registry.Add(first, second))
+
+	// removing a rule removes the record of the run which removed it, rather than the first matching record
+	req.NoError(registry.Remove(&Disruption{Host: "us-east-1.ctrl", Rule: rule, Run: "2"}))
+	list, err := registry.List()
+	req.NoError(err)
+	req.Len(list, 1)
+	req.Equal("1", list[0].Run)
+
+	// a rule removed by another run, or by hand, still removes a record
+	req.NoError(registry.Add(second))
+	req.NoError(registry.Remove(&Disruption{Host: "us-east-1.ctrl", Rule: rule, Run: "3"}))
+	list, err = registry.List()
+	req.NoError(err)
+	req.Len(list, 1)
+
+	req.Len(filterDisruptions([]*Disruption{first, second}, nil), 2)
+	req.Equal([]*Disruption{second}, filterDisruptions([]*Disruption{first, second}, runFilter("2")))
+	req.Empty(filterDisruptions([]*Disruption{first, second}, runFilter("3")))
+}
+
+func TestDisruptionRegistryLocksAcrossRegistries(t *testing.T) {
+	req := require.New(t)
+	path := filepath.Join(t.TempDir(), DisruptionsFilename)
+
+	// each registry has its own in-process lock, so only the file lock serializes them, as for separate processes
+	var locked atomic.Bool
+	done := make(chan error, 1)
+	req.NoError(NewDisruptionRegistry(path).withLock(func() error {
+		go func() {
+			done <- NewDisruptionRegistry(path).withLock(func() error {
+				locked.Store(true)
+				return nil
+			})
+		}()
+		time.Sleep(100 * time.Millisecond)
+		req.False(locked.Load())
+		return nil
+	}))
+	req.NoError(<-done)
+	req.True(locked.Load())
+}
+
+func TestNewDisruptionsRecordRun(t *testing.T) {
+	previous := getDisruptionRunId()
+	t.Cleanup(func() { setDisruptionRunId(previous) })
+
+	setDisruptionRunId("1234")
+	host := &Host{Id: "ctrl", Region: &Region{Id: "us-east-1"}}
+	disruptions := host.newDisruptions(iptablesFirewall{}, []NetworkRule{IncomingRule(ProtocolTcp, SinglePort(6262))})
+	require.Len(t, disruptions, 1)
+	require.Equal(t, "1234", disruptions[0].Run)
+}
+
+func TestRemoveRulesIgnoresMissingRules(t *testing.T) {
+	rules := outgoingHostRules([]string{"10.0.0.1", "10.0.0.2"})
+	require.Equal(t, "{ sudo iptables -D FABLAB_OUTPUT -d 10.0.0.1 -j DROP; } 2>/dev/null || true; "+
+		"{ sudo iptables -D FABLAB_OUTPUT -d 10.0.0.2 -j DROP; } 2>/dev/null || true",
+		removeRulesCmd(iptablesFirewall{}, rules))
+}
@@ -0,0 +1,199 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A HostFault is a non-network fault which is injected on a host for a limited time.
+//
+// Faults are run by a background shell on the host, which injects the fault, waits for the fault duration and
+// then reverts it. This means faults are reverted even if fablab exits or loses its connection to the host.
+// The shell is tagged with a marker in its process listing, so it can be found to revert the fault early.
+type HostFault string
+
+const (
+	HostFaultPause          HostFault = "pause"
+	HostFaultCpuBurn        HostFault = "cpu-burn"
+	HostFaultMemoryPressure HostFault = "memory-pressure"
+	HostFaultDiskFill       HostFault = "disk-fill"
+	HostFaultClockOffset    HostFault = "clock-offset"
+)
+
+const diskFillFile = ".fablab-disk-fill"
+
+// faultMarker returns the marker tagging the shell running a fault. The kind and name each end with a colon, so the
+// marker of a kind matches the markers of all its faults, while the marker of a name doesn't match longer names
+// which start with it, such as router10 for router1.
+func faultMarker(kind HostFault, name string) string {
+	marker := "fablab-fault-" + string(kind) + ":"
+	if name != "" {
+		marker += name + ":"
+	}
+	return marker
+}
+
+// faultPattern returns a pkill pattern for the marker, which doesn't match the command running pkill
+func faultPattern(marker string) string {
+	return "[" + marker[:1] + "]" + regexp.QuoteMeta(marker[1:])
+}
+
+// startFaultCmd returns a command which starts a background shell running inject, and running revert when
+// the duration has passed, or when the shell is terminated. inject must exit if it fails, so the failure is
+// reported. inject and revert must not contain single quotes.
+//
+// The shell reports whether inject succeeded through a file in a temporary directory. It can't be looked for in the
+// process listing instead, as the login shell running this command has the marker in its command line too.
+func startFaultCmd(marker, inject, revert string, duration time.Duration) string {
+	script := fmt.Sprintf(`revert() { %s; }; trap "revert; [ -e $1/started ] || touch $1/failed" EXIT; trap "exit 1" TERM INT HUP; `+
+		`%s; touch $1/started; sleep %d & wait $!`, revert, inject, durationSeconds(duration))
+	return fmt.Sprintf(`dir=$(mktemp -d) && setsid -f sudo sh -c '%s' '%s' "$dir" >/dev/null 2>&1 </dev/null && `+
+		`{ for i in $(seq 100); do [ -e "$dir/started" ] || [ -e "$dir/failed" ] && break; sleep 0.1; done; `+
+		`[ -e "$dir/started" ]; started=$?; rm -rf "$dir"; [ $started -eq 0 ] || { echo 'fault failed to start'; false; }; }`,
+		script, marker)
+}
+
+func revertFaultCmd(marker string) string {
+	return fmt.Sprintf("sudo pkill -TERM -f '%s' || true", faultPattern(marker))
+}
+
+func durationSeconds(d time.Duration) int64 {
+	return int64(d.Round(time.Second) / time.Second)
+}
+
+func pauseProcessesCmd(name string, pids []int, duration time.Duration) string {
+	var pidList []string
+	for _, pid := range pids {
+		pidList = append(pidList, fmt.Sprintf("%d", pid))
+	}
+	pidArgs := strings.Join(pidList, " ")
+	return startFaultCmd(faultMarker(HostFaultPause, name), "kill -STOP "+pidArgs+" || exit 1", "kill -CONT "+pidArgs, duration)
+}
+
+func cpuBurnCmd(cores int, duration time.Duration) string {
+	inject := fmt.Sprintf("for i in $(seq %d); do (while :; do :; done) & done", cores)
+	return startFaultCmd(faultMarker(HostFaultCpuBurn, ""), inject, "pkill -P $$", duration)
+}
+
+// memoryPressureCmd holds the memory in tail, which buffers its input looking for a line ending until the
+// input is closed
+func memoryPressureCmd(megabytes int, duration time.Duration) string {
+	inject := fmt.Sprintf("{ { head -c %dM /dev/zero; sleep %d; } | tail >/dev/null & }", megabytes, durationSeconds(duration))
+	return startFaultCmd(faultMarker(HostFaultMemoryPressure, ""), inject, "pkill -P $$", duration)
+}
+
+func diskFillCmd(path string, percent int, duration time.Duration) string {
+	file := strings.TrimSuffix(path, "/") + "/" + diskFillFile
+	inject := fmt.Sprintf(`need=$(df -P -B1 %s | awk "NR==2 {print int(\$2*%d/100-\$3)}"); `+
+		`if [ "$need" -gt 0 ]; then fallocate -l "$need" %s || exit 1; fi`, path, percent, file)
+	return startFaultCmd(faultMarker(HostFaultDiskFill, ""), inject, "rm -f "+file, duration)
+}
+
+// clockOffsetCmd shifts the clock, disabling NTP synchronization while the offset is in place, if it was enabled
+func clockOffsetCmd(offset time.Duration, duration time.Duration) string {
+	seconds := durationSeconds(offset)
+	inject := fmt.Sprintf(`NTP=$(timedatectl show -p NTP --value 2>/dev/null); `+
+		`if [ "$NTP" = yes ]; then timedatectl set-ntp false; fi; date -s @$(( $(date +%%s) + (%d) )) >/dev/null || exit 1`, seconds)
+	revert := fmt.Sprintf(`date -s @$(( $(date +%%s) - (%d) )) >/dev/null; `+
+		`if [ "$NTP" = yes ]; then timedatectl set-ntp true; fi`, seconds)
+	return startFaultCmd(faultMarker(HostFaultClockOffset, ""), inject, revert, duration)
+}
+
+func (host *Host) startFault(kind HostFault, cmd string, duration time.Duration) error {
+	if duration < 2*time.Second {
+		return fmt.Errorf("invalid %s duration [%s], must be at least 2s", kind, duration)
+	}
+	if output, err := host.ExecLogged(cmd); err != nil {
+		return fmt.Errorf("failed to start %s on [%s]: %w (output: %s)", kind, host.PublicIp, err, output)
+	}
+	logrus.WithField("hostId", host.Id).Infof("started %s for %s", kind, duration)
+	return nil
+}
+
+// PauseProcesses stops the matching processes with SIGSTOP, and resumes them with SIGCONT after the duration.
+// The name identifies the pause, so it can be ended early using ResumeProcesses.
+func (host *Host) PauseProcesses(name string, filter func(string) bool, duration time.Duration) error {
+	pids, err := host.FindProcesses(filter)
+	if err != nil {
+		return err
+	}
+	if len(pids) == 0 {
+		return fmt.Errorf("no processes to pause for [%s] on [%s]", name, host.PublicIp)
+	}
+	return host.startFault(HostFaultPause, pauseProcessesCmd(name, pids, duration), duration)
+}
+
+// ResumeProcesses ends the pause with the given name early.
+// If the pause has already ended, nothing happens.
+func (host *Host) ResumeProcesses(name string) error {
+	return host.revertFault(faultMarker(HostFaultPause, name))
+}
+
+// BurnCpu keeps the given number of cores busy for the duration
+func (host *Host) BurnCpu(cores int, duration time.Duration) error {
+	if cores < 1 {
+		return fmt.Errorf("invalid core count [%d], must be at least 1", cores)
+	}
+	return host.startFault(HostFaultCpuBurn, cpuBurnCmd(cores, duration), duration)
+}
+
+// ApplyMemoryPressure allocates the given number of megabytes for the duration
+func (host *Host) ApplyMemoryPressure(megabytes int, duration time.Duration) error {
+	if megabytes < 1 {
+		return fmt.Errorf("invalid memory size [%dM], must be at least 1M", megabytes)
+	}
+	return host.startFault(HostFaultMemoryPressure, memoryPressureCmd(megabytes, duration), duration)
+}
+
+// FillDisk fills the file system containing path to the given percentage for the duration. If the file
+// system is already at or above the percentage, nothing is written.
+func (host *Host) FillDisk(path string, percent int, duration time.Duration) error {
+	if percent < 1 || percent > 100 {
+		return fmt.Errorf("invalid disk fill percentage [%d], must be between 1 and 100", percent)
+	}
+	if path == "" || strings.ContainsAny(path, "'\" ") {
+		return fmt.Errorf("invalid disk fill path [%s]", path)
+	}
+	return host.startFault(HostFaultDiskFill, diskFillCmd(path, percent, duration), duration)
+}
+
+// OffsetClock shifts the system clock by the given offset for the duration
+func (host *Host) OffsetClock(offset time.Duration, duration time.Duration) error {
+	if durationSeconds(offset) == 0 {
+		return fmt.Errorf("invalid clock offset [%s], must be at least 1s", offset)
+	}
+	return host.startFault(HostFaultClockOffset, clockOffsetCmd(offset, duration), duration)
+}
+
+// RevertFaults reverts all active faults of the given kind early.
+// If there are no active faults, nothing happens.
+func (host *Host) RevertFaults(kind HostFault) error {
+	return host.revertFault(faultMarker(kind, ""))
+}
+
+func (host *Host) revertFault(marker string) error {
+	if output, err := host.ExecLogged(revertFaultCmd(marker)); err != nil {
+		return fmt.Errorf("failed to revert %s on [%s]: %w (output: %s)", marker, host.PublicIp, err, output)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package model
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultMarkers(t *testing.T) {
+	assert.Equal(t, "fablab-fault-cpu-burn:", faultMarker(HostFaultCpuBurn, ""))
+	assert.Equal(t, "fablab-fault-pause:router1:", faultMarker(HostFaultPause, "router1"))
+	assert.Equal(t, `[f]ablab-fault-pause:router\.1:`, faultPattern(faultMarker(HostFaultPause, "router.1")))
+	assert.Equal(t, "sudo pkill -TERM -f '[f]ablab-fault-disk-fill:' || true", revertFaultCmd(faultMarker(HostFaultDiskFill, "")))
+}
+
+func TestFaultPatternsMatchExactNames(t *testing.T) {
+	cmdline := func(name string) string {
+		return "sh -c revert() { kill -CONT 12; }; sleep 10 " + faultMarker(HostFaultPause, name)
+	}
+	matches := func(marker, cmdline string) bool {
+		return regexp.MustCompile(faultPattern(marker)).MatchString(cmdline)
+	}
+
+	router1 := faultMarker(HostFaultPause, "router1")
+	assert.True(t, matches(router1, cmdline("router1")))
+	assert.False(t, matches(router1, cmdline("router10")))
+	assert.False(t, matches(router1, cmdline("router11")))
+
+	all := faultMarker(HostFaultPause, "")
+	assert.True(t, matches(all, cmdline("router1")))
+	assert.True(t, matches(all, cmdline("router10")))
+	assert.False(t, matches(faultMarker(HostFaultCpuBurn, ""), cmdline("router1")))
+}
+
+func TestStartFaultCmd(t *testing.T) {
+	cmd := startFaultCmd("fablab-fault-test", "inject", "revert", 90*time.Second)
+	assert.Equal(t, `dir=$(mktemp -d) && setsid -f sudo sh -c 'revert() { revert; }; `+
+		`trap "revert; [ -e $1/started ] || touch $1/failed" EXIT; trap "exit 1" TERM INT HUP; `+
+		`inject; touch $1/started; sleep 90 & wait $!' 'fablab-fault-test' "$dir" >/dev/null 2>&1 </dev/null && `+
+		`{ for i in $(seq 100); do [ -e "$dir/started" ] || [ -e "$dir/failed" ] && break; sleep 0.1; done; `+
+		`[ -e "$dir/started" ]; started=$?; rm -rf "$dir"; [ $started -eq 0 ] || { echo 'fault failed to start'; false; }; }`, cmd)
+}
+
+func TestFaultCmds(t *testing.T) {
+	cmd := pauseProcessesCmd("ctrl", []int{12, 34}, 10*time.Second)
+	assert.Contains(t, cmd, "revert() { kill -CONT 12 34; }")
+	assert.Contains(t, cmd, "kill -STOP 12 34 || exit 1; touch $1/started; sleep 10")
+	assert.Contains(t, cmd, "'fablab-fault-pause:ctrl:'")
+
+	cmd = cpuBurnCmd(4, time.Minute)
+	assert.Contains(t, cmd, "for i in $(seq 4); do (while :; do :; done) & done; touch $1/started; sleep 60")
+
+	cmd = memoryPressureCmd(512, time.Minute)
+	assert.Contains(t, cmd, "{ { head -c 512M /dev/zero; sleep 60; } | tail >/dev/null & }")
+
+	cmd = diskFillCmd("/var/", 95, time.Minute)
+	assert.Contains(t, cmd, `df -P -B1 /var/ | awk "NR==2 {print int(\$2*95/100-\$3)}"`)
+	assert.Contains(t, cmd, `fallocate -l "$need" /var/.fablab-disk-fill || exit 1`)
+	assert.Contains(t, cmd, "revert() { rm -f /var/.fablab-disk-fill; }")
+
+	cmd = clockOffsetCmd(-90*time.Second, time.Minute)
+	assert.Contains(t, cmd, "date -s @$(( $(date +%s) + (-90) )) >/dev/null || exit 1")
+	assert.Contains(t, cmd, "date -s @$(( $(date +%s) - (-90) )) >/dev/null")
+}
@@ -0,0 +1,299 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+type TrafficDirection string
+
+const (
+	TrafficIncoming TrafficDirection = "incoming"
+	TrafficOutgoing TrafficDirection = "outgoing"
+)
+
+type Protocol string
+
+const (
+	ProtocolTcp  Protocol = "tcp"
+	ProtocolUdp  Protocol = "udp"
+	ProtocolIcmp Protocol = "icmp"
+	ProtocolAll  Protocol = "all"
+)
+
+// PortRange is an inclusive range of ports. A zero To means the range contains only From
+type PortRange struct {
+	From uint16 `yaml:"from,omitempty"`
+	To   uint16 `yaml:"to,omitempty"`
+}
+
+func SinglePort(port uint16) PortRange {
+	return PortRange{From: port}
+}
+
+func PortsBetween(from, to uint16) PortRange {
+	return PortRange{From: from, To: to}
+}
+
+func (self PortRange) IsSet() bool {
+	return self.From != 0
+}
+
+func (self PortRange) isRange() bool {
+	return self.To != 0 && self.To != self.From
+}
+
+func (self PortRange) format(separator string) string {
+	if self.isRange() {
+		return fmt.Sprintf("%d%s%d", self.From, separator, self.To)
+	}
+	return fmt.Sprintf("%d", self.From)
+}
+
+func (self PortRange) String() string {
+	return self.format("-")
+}
+
+// NetworkRule describes traffic to be blocked on a host. For incoming traffic, Ip is the source address and
+// Ports are local ports. For outgoing traffic, Ip is the destination address and Ports are remote ports.
+//
+// If OriginalOnly is set, the rule only matches packets sent by the side which opened their connection, as tracked
+// by conntrack. An outgoing rule then blocks connections opened by the host, while replies to connections opened
+// by the peer still pass, which blocks traffic in one direction only.
+type NetworkRule struct {
+	Direction    TrafficDirection `yaml:"direction"`
+	Protocol     Protocol         `yaml:"protocol"`
+	Ip           string           `yaml:"ip,omitempty"`
+	Ports        PortRange        `yaml:"ports,omitempty"`
+	OriginalOnly bool             `yaml:"originalOnly,omitempty"`
+}
+
+// IncomingRule matches traffic arriving on the given local ports
+func IncomingRule(protocol Protocol, ports PortRange) NetworkRule {
+	return NetworkRule{Direction: TrafficIncoming, Protocol: protocol, Ports: ports}
+}
+
+// IncomingFromRule matches all traffic of the given protocol arriving from the given address
+func IncomingFromRule(protocol Protocol, ip string) NetworkRule {
+	return NetworkRule{Direction: TrafficIncoming, Protocol: protocol, Ip: ip}
+}
+
+// OutgoingRule matches traffic sent to the given address and ports. An empty ip matches all destinations and
+// an unset port range matches all ports.
+func OutgoingRule(protocol Protocol, ip string, ports PortRange) NetworkRule {
+	return NetworkRule{Direction: TrafficOutgoing, Protocol: protocol, Ip: ip, Ports: ports}
+}
+
+func (self NetworkRule) Validate() error {
+	switch self.Direction {
+	case TrafficIncoming, TrafficOutgoing:
+	default:
+		return fmt.Errorf("invalid traffic direction [%s]", self.Direction)
+	}
+
+	switch self.Protocol {
+	case ProtocolTcp, ProtocolUdp:
+	case ProtocolIcmp, ProtocolAll:
+		if self.Ports.IsSet() {
+			return fmt.Errorf("ports can't be used with protocol [%s]", self.Protocol)
+		}
+	default:
+		return fmt.Errorf("invalid protocol [%s], must be one of tcp, udp, icmp or all", self.Protocol)
+	}
+
+	if self.Ports.isRange() && self.Ports.To < self.Ports.From {
+		return fmt.Errorf("invalid port range [%s]", self.Ports)
+	}
+
+	if self.Direction == TrafficIncoming && self.Ip == "" && !self.Ports.IsSet() {
+		return fmt.Errorf("incoming rules require an ip or ports, otherwise they would block ssh access")
+	}
+	return nil
+}
+
+func (self NetworkRule) String() string {
+	result := string(self.Direction) + " " + string(self.Protocol)
+	if self.Ip != "" {
+		if self.Direction == TrafficIncoming {
+			result += " from " + self.Ip
+		} else {
+			result += " to " + self.Ip
+		}
+	}
+	if self.Ports.IsSet() {
+		result += " port " + self.Ports.String()
+	}
+	if self.OriginalOnly {
+		result += " original-direction"
+	}
+	return result
+}
+
+// firewallBackend builds the commands used to block traffic in the fablab chains. Rules are kept in chains
+// named FABLAB_INPUT and FABLAB_OUTPUT, so they can be removed without touching other rules on the host.
+type firewallBackend interface {
+	name() string
+	ensureChainsCmd() string
+	blockCmd(rule NetworkRule) string
+	unblockCmd(rule NetworkRule) string
+	unblockAllCmd() string
+}
+
+func detectFirewallCmd() string {
+	return "if command -v iptables >/dev/null 2>&1 || sudo sh -c 'command -v iptables' >/dev/null 2>&1; then echo iptables;" +
+		" elif command -v nft >/dev/null 2>&1 || sudo sh -c 'command -v nft' >/dev/null 2>&1; then echo nftables;" +
+		" else echo none; fi"
+}
+
+func firewallChain(direction TrafficDirection) string {
+	if direction == TrafficIncoming {
+		return "FABLAB_INPUT"
+	}
+	return "FABLAB_OUTPUT"
+}
+
+type iptablesFirewall struct{}
+
+func (self iptablesFirewall) name() string {
+	return "iptables"
+}
+
+func (self iptablesFirewall) ensureChainsCmd() string {
+	return "sudo iptables -N FABLAB_INPUT 2>/dev/null || true" +
+		" && sudo iptables -N FABLAB_OUTPUT 2>/dev/null || true" +
+		" && (sudo iptables -C INPUT -j FABLAB_INPUT 2>/dev/null || sudo iptables -I INPUT -j FABLAB_INPUT)" +
+		" && (sudo iptables -C OUTPUT -j FABLAB_OUTPUT 2>/dev/null || sudo iptables -I OUTPUT -j FABLAB_OUTPUT)"
+}
+
+func (self iptablesFirewall) ruleSpec(rule NetworkRule) string {
+	var args []string
+	if rule.Protocol != ProtocolAll {
+		args = append(args, "-p "+string(rule.Protocol))
+	}
+	if rule.Ip != "" {
+		if rule.Direction == TrafficIncoming {
+			args = append(args, "-s "+rule.Ip)
+		} else {
+			args = append(args, "-d "+rule.Ip)
+		}
+	}
+	if rule.Ports.IsSet() {
+		args = append(args, "--dport "+rule.Ports.format(":"))
+	}
+	if rule.OriginalOnly {
+		args = append(args, "-m conntrack --ctdir ORIGINAL")
+	}
+	args = append(args, "-j DROP")
+	return strings.Join(args, " ")
+}
+
+func (self iptablesFirewall) blockCmd(rule NetworkRule) string {
+	return fmt.Sprintf("sudo iptables -A %s %s", firewallChain(rule.Direction), self.ruleSpec(rule))
+}
+
+func (self iptablesFirewall) unblockCmd(rule NetworkRule) string {
+	return fmt.Sprintf("sudo iptables -D %s %s", firewallChain(rule.Direction), self.ruleSpec(rule))
+}
+
+func (self iptablesFirewall) unblockAllCmd() string {
+	return "sudo iptables -F FABLAB_INPUT 2>/dev/null || true" +
+		" && sudo iptables -F FABLAB_OUTPUT 2>/dev/null || true"
+}
+
+// nftablesFirewall keeps rules in base chains of a dedicated fablab table. A drop verdict in any base chain is
+// final, so this has the same effect as the iptables chains. Each rule is tagged with a comment, which is used
+// to find its handle when it is removed.
+type nftablesFirewall struct{}
+
+func (self nftablesFirewall) name() string {
+	return "nftables"
+}
+
+func (self nftablesFirewall) ensureChainsCmd() string {
+	return "sudo nft add table inet fablab" +
+		" && sudo nft 'add chain inet fablab FABLAB_INPUT { type filter hook input priority 0; policy accept; }'" +
+		" && sudo nft 'add chain inet fablab FABLAB_OUTPUT { type filter hook output priority 0; policy accept; }'"
+}
+
+func (self nftablesFirewall) ruleExpr(rule NetworkRule) string {
+	var args []string
+	if rule.Ip != "" {
+		if rule.Direction == TrafficIncoming {
+			args = append(args, "ip saddr "+rule.Ip)
+		} else {
+			args = append(args, "ip daddr "+rule.Ip)
+		}
+	}
+	if rule.Ports.IsSet() {
+		args = append(args, fmt.Sprintf("%s dport %s", rule.Protocol, rule.Ports.format("-")))
+	} else if rule.Protocol != ProtocolAll {
+		args = append(args, "meta l4proto "+string(rule.Protocol))
+	}
+	if rule.OriginalOnly {
+		args = append(args, "ct direction original")
+	}
+	args = append(args, "drop")
+	return strings.Join(args, " ")
+}
+
+func (self nftablesFirewall) ruleComment(rule NetworkRule) string {
+	return "fablab " + strings.ReplaceAll(rule.String(), " ", "_")
+}
+
+func (self nftablesFirewall) blockCmd(rule NetworkRule) string {
+	return fmt.Sprintf("sudo nft 'add rule inet fablab %s %s comment \"%s\"'",
+		firewallChain(rule.Direction), self.ruleExpr(rule), self.ruleComment(rule))
+}
+
+func (self nftablesFirewall) unblockCmd(rule NetworkRule) string {
+	chain := firewallChain(rule.Direction)
+	return fmt.Sprintf("HANDLE=$(sudo nft -a list chain inet fablab %s | grep -F 'comment \"%s\"' | head -n 1 | sed 's/.*# handle //')"+
+		" && [ -n \"$HANDLE\" ] && sudo nft delete rule inet fablab %s handle $HANDLE", chain, self.ruleComment(rule), chain)
+}
+
+func (self nftablesFirewall) unblockAllCmd() string {
+	return "sudo nft flush chain inet fablab FABLAB_INPUT 2>/dev/null || true" +
+		" && sudo nft flush chain inet fablab FABLAB_OUTPUT 2>/dev/null || true"
+}
+
+// getFirewall returns the firewall backend used on the host: iptables if it's available, otherwise nftables.
+// The result is cached for the lifetime of the host.
+func (host *Host) getFirewall() (firewallBackend, error) {
+	host.firewallLock.Lock()
+	defer host.firewallLock.Unlock()
+
+	if host.firewall != nil {
+		return host.firewall, nil
+	}
+
+	output, err := host.ExecLogged(detectFirewallCmd())
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect firewall on [%s]: %w (output: %s)", host.PublicIp, err, output)
+	}
+
+	switch strings.TrimSpace(output) {
+	case "iptables":
+		host.firewall = iptablesFirewall{}
+	case "nftables":
+		host.firewall = nftablesFirewall{}
+	default:
+		return nil, fmt.Errorf("neither iptables nor nft found on [%s]", host.PublicIp)
+	}
+	return host.firewall, nil
+}
@@ -0,0 +1,86 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkRuleValidate(t *testing.T) {
+	assert.NoError(t, IncomingRule(ProtocolUdp, PortsBetween(5000, 5100)).Validate())
+	assert.NoError(t, IncomingFromRule(ProtocolAll, "10.0.0.1").Validate())
+	assert.NoError(t, OutgoingRule(ProtocolIcmp, "10.0.0.1", PortRange{}).Validate())
+	assert.NoError(t, OutgoingRule(ProtocolAll, "", PortRange{}).Validate())
+
+	assert.Error(t, IncomingRule(ProtocolTcp, PortRange{}).Validate())
+	assert.Error(t, IncomingRule(ProtocolIcmp, SinglePort(80)).Validate())
+	assert.Error(t, IncomingRule("sctp", SinglePort(80)).Validate())
+	assert.Error(t, IncomingRule(ProtocolTcp, PortsBetween(200, 100)).Validate())
+	assert.Error(t, NetworkRule{Protocol: ProtocolTcp, Ports: SinglePort(80)}.Validate())
+}
+
+func TestNetworkRuleString(t *testing.T) {
+	assert.Equal(t, "incoming udp port 5000-5100", IncomingRule(ProtocolUdp, PortsBetween(5000, 5100)).String())
+	assert.Equal(t, "outgoing tcp to 10.0.0.1 port 6262", OutgoingRule(ProtocolTcp, "10.0.0.1", SinglePort(6262)).String())
+	assert.Equal(t, "incoming icmp from 10.0.0.1", IncomingFromRule(ProtocolIcmp, "10.0.0.1").String())
+	assert.Equal(t, "outgoing all to 10.0.0.1 original-direction", originalOnly(OutgoingRule(ProtocolAll, "10.0.0.1", PortRange{})).String())
+}
+
+func originalOnly(rule NetworkRule) NetworkRule {
+	rule.OriginalOnly = true
+	return rule
+}
+
+func TestIptablesFirewall(t *testing.T) {
+	fw := iptablesFirewall{}
+	assert.Equal(t, "sudo iptables -A FABLAB_INPUT -p udp --dport 5000:5100 -j DROP", fw.blockCmd(IncomingRule(ProtocolUdp, PortsBetween(5000, 5100))))
+	assert.Equal(t, "sudo iptables -A FABLAB_INPUT -p icmp -s 10.0.0.1 -j DROP", fw.blockCmd(IncomingFromRule(ProtocolIcmp, "10.0.0.1")))
+	assert.Equal(t, "sudo iptables -D FABLAB_OUTPUT -p udp -d 10.0.0.1 --dport 53 -j DROP", fw.unblockCmd(OutgoingRule(ProtocolUdp, "10.0.0.1", SinglePort(53))))
+	assert.Equal(t, "sudo iptables -A FABLAB_OUTPUT -j DROP", fw.blockCmd(OutgoingRule(ProtocolAll, "", PortRange{})))
+	assert.Equal(t, "sudo iptables -A FABLAB_OUTPUT -d 10.0.0.1 -m conntrack --ctdir ORIGINAL -j DROP",
+		fw.blockCmd(originalOnly(OutgoingRule(ProtocolAll, "10.0.0.1", PortRange{}))))
+}
+
+func TestNftablesFirewall(t *testing.T) {
+	fw := nftablesFirewall{}
+
+	cmd := fw.ensureChainsCmd()
+	assert.Contains(t, cmd, "sudo nft add table inet fablab")
+	assert.Contains(t, cmd, "add chain inet fablab FABLAB_INPUT { type filter hook input priority 0; policy accept; }")
+	assert.Contains(t, cmd, "add chain inet fablab FABLAB_OUTPUT { type filter hook output priority 0; policy accept; }")
+
+	assert.Equal(t, `sudo nft 'add rule inet fablab FABLAB_INPUT udp dport 5000-5100 drop comment "fablab incoming_udp_port_5000-5100"'`,
+		fw.blockCmd(IncomingRule(ProtocolUdp, PortsBetween(5000, 5100))))
+	assert.Equal(t, `sudo nft 'add rule inet fablab FABLAB_OUTPUT ip daddr 10.0.0.1 tcp dport 6262 drop comment "fablab outgoing_tcp_to_10.0.0.1_port_6262"'`,
+		fw.blockCmd(OutgoingRule(ProtocolTcp, "10.0.0.1", SinglePort(6262))))
+	assert.Equal(t, `sudo nft 'add rule inet fablab FABLAB_INPUT ip saddr 10.0.0.1 meta l4proto icmp drop comment "fablab incoming_icmp_from_10.0.0.1"'`,
+		fw.blockCmd(IncomingFromRule(ProtocolIcmp, "10.0.0.1")))
+	assert.Equal(t, `sudo nft 'add rule inet fablab FABLAB_OUTPUT ip daddr 10.0.0.1 drop comment "fablab outgoing_all_to_10.0.0.1"'`,
+		fw.blockCmd(OutgoingRule(ProtocolAll, "10.0.0.1", PortRange{})))
+	assert.Equal(t, `sudo nft 'add rule inet fablab FABLAB_OUTPUT ip daddr 10.0.0.1 ct direction original drop comment "fablab outgoing_all_to_10.0.0.1_original-direction"'`,
+		fw.blockCmd(originalOnly(OutgoingRule(ProtocolAll, "10.0.0.1", PortRange{}))))
+
+	cmd = fw.unblockCmd(OutgoingRule(ProtocolAll, "10.0.0.1", PortRange{}))
+	assert.Contains(t, cmd, `sudo nft -a list chain inet fablab FABLAB_OUTPUT | grep -F 'comment "fablab outgoing_all_to_10.0.0.1"'`)
+	assert.Contains(t, cmd, "sudo nft delete rule inet fablab FABLAB_OUTPUT handle $HANDLE")
+
+	cmd = fw.unblockAllCmd()
+	assert.Contains(t, cmd, "sudo nft flush chain inet fablab FABLAB_INPUT")
+	assert.Contains(t, cmd, "sudo nft flush chain inet fablab FABLAB_OUTPUT")
+}
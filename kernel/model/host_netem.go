@@ -0,0 +1,425 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Netem rules are implemented with an htb root qdisc on the host's default interface. Unmatched traffic goes
+// to the default class 1:1, which carries the global netem rule, if there is one. Each targeted rule gets its
+// own class, with a netem qdisc attached, and u32 filters which steer matching traffic into the class.
+const (
+	netemDefaultClass  = 0x1
+	netemGlobalHandle  = 0xfffe
+	netemFirstClass    = 0x2
+	netemUnlimitedRate = "10gbit"
+)
+
+// NetemConfig describes the impairments applied by a netem rule. Percentages are in the range 0-100.
+type NetemConfig struct {
+	Delay   time.Duration
+	Jitter  time.Duration
+	Loss    float64
+	Reorder float64
+	Corrupt float64
+	// Rate limits bandwidth, using tc rate syntax, for example 10mbit
+	Rate string
+}
+
+func (self *NetemConfig) validate() error {
+	if self.Jitter > 0 && self.Delay == 0 {
+		return fmt.Errorf("netem jitter requires a delay")
+	}
+	if self.Reorder > 0 && self.Delay == 0 {
+		return fmt.Errorf("netem reordering requires a delay")
+	}
+	for name, pct := range map[string]float64{"loss": self.Loss, "reorder": self.Reorder, "corrupt": self.Corrupt} {
+		if pct < 0 || pct > 100 {
+			return fmt.Errorf("netem %s must be between 0 and 100, was %v", name, pct)
+		}
+	}
+	if self.Delay == 0 && self.Loss == 0 && self.Corrupt == 0 && self.Rate == "" {
+		return fmt.Errorf("netem config doesn't specify any impairments")
+	}
+	return nil
+}
+
+func (self *NetemConfig) args() string {
+	var args []string
+	if self.Delay > 0 {
+		delay := fmt.Sprintf("delay %dus", self.Delay.Microseconds())
+		if self.Jitter > 0 {
+			delay += fmt.Sprintf(" %dus", self.Jitter.Microseconds())
+		}
+		args = append(args, delay)
+	}
+	if self.Reorder > 0 {
+		args = append(args, "reorder "+formatPct(self.Reorder))
+	}
+	if self.Loss > 0 {
+		args = append(args, "loss "+formatPct(self.Loss))
+	}
+	if self.Corrupt > 0 {
+		args = append(args, "corrupt "+formatPct(self.Corrupt))
+	}
+	if self.Rate != "" {
+		args = append(args, "rate "+self.Rate)
+	}
+	return strings.Join(args, " ")
+}
+
+func formatPct(pct float64) string {
+	return strconv.FormatFloat(pct, 'f', -1, 64) + "%"
+}
+
+// NetemTarget selects the outgoing traffic a netem rule applies to. An empty target applies to all traffic.
+// If a port is set, traffic to that port, as well as traffic sent from that port, is matched.
+type NetemTarget struct {
+	Ip   string
+	Port uint16
+}
+
+func NetemGlobal() NetemTarget {
+	return NetemTarget{}
+}
+
+func NetemToIp(ip string) NetemTarget {
+	return NetemTarget{Ip: ip}
+}
+
+func NetemToPort(port uint16) NetemTarget {
+	return NetemTarget{Port: port}
+}
+
+func (self NetemTarget) IsGlobal() bool {
+	return self.Ip == "" && self.Port == 0
+}
+
+func (self NetemTarget) String() string {
+	if self.IsGlobal() {
+		return "all"
+	}
+	var parts []string
+	if self.Ip != "" {
+		parts = append(parts, "ip "+self.Ip)
+	}
+	if self.Port != 0 {
+		parts = append(parts, fmt.Sprintf("port %d", self.Port))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// NetemRule is a netem rule currently installed on a host
+type NetemRule struct {
+	ClassId uint16
+	Target  NetemTarget
+	Params  string
+	filters []string
+}
+
+// command construction helpers (unexported, testable)
+
+func netemDeviceCmd() string {
+	return "ip route show default | awk '/default/ {print $5; exit}'"
+}
+
+func ensureNetemRootCmd(dev string) string {
+	return fmt.Sprintf("sudo tc qdisc show dev %[1]s | grep -q 'qdisc htb 1: root'"+
+		" || (sudo tc qdisc replace dev %[1]s root handle 1: htb default %[2]x"+
+		" && sudo tc class add dev %[1]s parent 1: classid 1:%[2]x htb rate %[3]s)", dev, netemDefaultClass, netemUnlimitedRate)
+}
+
+func setNetemGlobalCmd(dev string, config *NetemConfig) string {
+	return fmt.Sprintf("sudo tc qdisc replace dev %s parent 1:%x handle %x: netem %s", dev, netemDefaultClass, netemGlobalHandle, config.args())
+}
+
+func clearNetemGlobalCmd(dev string) string {
+	return fmt.Sprintf("sudo tc qdisc del dev %s parent 1:%x handle %x: 2>/dev/null || true", dev, netemDefaultClass, netemGlobalHandle)
+}
+
+func addNetemClassCmd(dev string, classId uint16, config *NetemConfig) string {
+	return fmt.Sprintf("sudo tc class add dev %[1]s parent 1: classid 1:%[2]x htb rate %[3]s"+
+		" && sudo tc qdisc add dev %[1]s parent 1:%[2]x handle %[2]x: netem %[4]s", dev, classId, netemUnlimitedRate, config.args())
+}
+
+func changeNetemClassCmd(dev string, classId uint16, config *NetemConfig) string {
+	return fmt.Sprintf("sudo tc qdisc replace dev %[1]s parent 1:%[2]x handle %[2]x: netem %[3]s", dev, classId, config.args())
+}
+
+func addNetemFiltersCmd(dev string, classId uint16, target NetemTarget) string {
+	base := fmt.Sprintf("sudo tc filter add dev %s parent 1: protocol ip prio 1 u32", dev)
+	if target.Ip != "" {
+		base += fmt.Sprintf(" match ip dst %s/32", target.Ip)
+	}
+	flow := fmt.Sprintf(" flowid 1:%x", classId)
+	if target.Port == 0 {
+		return base + flow
+	}
+	return base + fmt.Sprintf(" match ip dport %d 0xffff", target.Port) + flow +
+		" && " + base + fmt.Sprintf(" match ip sport %d 0xffff", target.Port) + flow
+}
+
+func removeNetemClassCmd(dev string, classId uint16, filters []string) string {
+	var cmds []string
+	for _, handle := range filters {
+		cmds = append(cmds, fmt.Sprintf("sudo tc filter del dev %s parent 1: handle %s prio 1 u32", dev, handle))
+	}
+	cmds = append(cmds, fmt.Sprintf("sudo tc class del dev %s classid 1:%x", dev, classId))
+	return strings.Join(cmds, " && ")
+}
+
+func clearAllNetemCmd(dev string) string {
+	return fmt.Sprintf("sudo tc qdisc del dev %s root 2>/dev/null || true", dev)
+}
+
+func listNetemQdiscsCmd(dev string) string {
+	return fmt.Sprintf("sudo tc qdisc show dev %s", dev)
+}
+
+func listNetemFiltersCmd(dev string) string {
+	return fmt.Sprintf("sudo tc filter show dev %s parent 1: 2>/dev/null || true", dev)
+}
+
+// parseNetemRules builds the rule list from the output of tc qdisc show and tc filter show
+func parseNetemRules(qdiscs, filters string) []*NetemRule {
+	rules := map[uint16]*NetemRule{}
+	var result []*NetemRule
+
+	for _, line := range strings.Split(qdiscs, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[0] != "qdisc" || fields[1] != "netem" || fields[3] != "parent" {
+			continue
+		}
+		classId, ok := parseTcMinor(fields[4])
+		if !ok {
+			continue
+		}
+		params := fields[5:]
+		if len(params) >= 2 && params[0] == "limit" {
+			params = params[2:]
+		}
+		rule := &NetemRule{ClassId: classId, Params: strings.Join(params, " ")}
+		if classId == netemDefaultClass {
+			rule.ClassId = 0
+		}
+		rules[classId] = rule
+		result = append(result, rule)
+	}
+
+	var current *NetemRule
+	for _, line := range strings.Split(filters, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "filter" {
+			current = nil
+			handle := fieldAfter(fields, "fh")
+			flowId := fieldAfter(fields, "flowid")
+			if handle == "" || flowId == "" {
+				continue
+			}
+			if classId, ok := parseTcMinor(flowId); ok && rules[classId] != nil {
+				current = rules[classId]
+				current.filters = append(current.filters, handle)
+			}
+		} else if fields[0] == "match" && current != nil && len(fields) >= 4 {
+			applyNetemMatch(&current.Target, fields[1], fields[3])
+		}
+	}
+
+	return result
+}
+
+func applyNetemMatch(target *NetemTarget, valueAndMask string, offset string) {
+	value, mask, found := strings.Cut(valueAndMask, "/")
+	if !found {
+		return
+	}
+	v, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return
+	}
+	switch {
+	case offset == "16" && mask == "ffffffff":
+		target.Ip = net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).String()
+	case offset == "20" && mask == "0000ffff":
+		target.Port = uint16(v)
+	case offset == "20" && mask == "ffff0000":
+		target.Port = uint16(v >> 16)
+	}
+}
+
+func fieldAfter(fields []string, name string) string {
+	for i, field := range fields[:len(fields)-1] {
+		if field == name {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+func parseTcMinor(classId string) (uint16, bool) {
+	major, minor, found := strings.Cut(classId, ":")
+	if !found || major != "1" {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(minor, 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint16(v), true
+}
+
+func (host *Host) netemDevice() (string, error) {
+	output, err := host.ExecLogged(netemDeviceCmd())
+	if err != nil {
+		return "", fmt.Errorf("failed to find default network interface on [%s]: %w (output: %s)", host.PublicIp, err, output)
+	}
+	dev := strings.TrimSpace(output)
+	if dev == "" {
+		return "", fmt.Errorf("no default network interface found on [%s]", host.PublicIp)
+	}
+	return dev, nil
+}
+
+func (host *Host) listNetem(dev string) ([]*NetemRule, error) {
+	qdiscs, err := host.ExecLogged(listNetemQdiscsCmd(dev))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list qdiscs on [%s]: %w (output: %s)", host.PublicIp, err, qdiscs)
+	}
+	filters, err := host.ExecLogged(listNetemFiltersCmd(dev))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list filters on [%s]: %w (output: %s)", host.PublicIp, err, filters)
+	}
+	return parseNetemRules(qdiscs, filters), nil
+}
+
+// AddNetem applies delay, jitter, loss, reordering, corruption and/or rate limiting to outgoing traffic
+// matching the target, using tc and netem. If a rule for the same target already exists, it is replaced.
+func (host *Host) AddNetem(target NetemTarget, config *NetemConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	dev, err := host.netemDevice()
+	if err != nil {
+		return err
+	}
+
+	if output, err := host.ExecLogged(ensureNetemRootCmd(dev)); err != nil {
+		return fmt.Errorf("failed to set up tc root qdisc on [%s]: %w (output: %s)", host.PublicIp, err, output)
+	}
+
+	if target.IsGlobal() {
+		if output, err := host.ExecLogged(setNetemGlobalCmd(dev, config)); err != nil {
+			return fmt.Errorf("failed to apply netem to all traffic on [%s]: %w (output: %s)", host.PublicIp, err, output)
+		}
+		return nil
+	}
+
+	rules, err := host.listNetem(dev)
+	if err != nil {
+		return err
+	}
+
+	used := map[uint16]bool{}
+	for _, rule := range rules {
+		if rule.ClassId != 0 && rule.Target == target {
+			if output, err := host.ExecLogged(changeNetemClassCmd(dev, rule.ClassId, config)); err != nil {
+				return fmt.Errorf("failed to change netem for [%s] on [%s]: %w (output: %s)", target, host.PublicIp, err, output)
+			}
+			return nil
+		}
+		used[rule.ClassId] = true
+	}
+
+	classId := uint16(netemFirstClass)
+	for used[classId] {
+		classId++
+	}
+
+	if output, err := host.ExecLogged(addNetemClassCmd(dev, classId, config)); err != nil {
+		return fmt.Errorf("failed to add netem for [%s] on [%s]: %w (output: %s)", target, host.PublicIp, err, output)
+	}
+	if output, err := host.ExecLogged(addNetemFiltersCmd(dev, classId, target)); err != nil {
+		return fmt.Errorf("failed to add netem filters for [%s] on [%s]: %w (output: %s)", target, host.PublicIp, err, output)
+	}
+	return nil
+}
+
+// ListNetem returns the netem rules currently installed on the host
+func (host *Host) ListNetem() ([]*NetemRule, error) {
+	dev, err := host.netemDevice()
+	if err != nil {
+		return nil, err
+	}
+	return host.listNetem(dev)
+}
+
+// ClearNetem removes the netem rule for the given target.
+// This is best-effort: if there is no such rule, a warning is logged but no error is returned.
+func (host *Host) ClearNetem(target NetemTarget) error {
+	dev, err := host.netemDevice()
+	if err != nil {
+		return err
+	}
+
+	if target.IsGlobal() {
+		if output, err := host.ExecLogged(clearNetemGlobalCmd(dev)); err != nil {
+			logrus.WithField("hostId", host.Id).Warnf("failed to clear netem for all traffic on [%s]: %v (output: %s)", host.PublicIp, err, output)
+		}
+		return nil
+	}
+
+	rules, err := host.listNetem(dev)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if rule.ClassId != 0 && rule.Target == target {
+			if output, err := host.ExecLogged(removeNetemClassCmd(dev, rule.ClassId, rule.filters)); err != nil {
+				return fmt.Errorf("failed to clear netem for [%s] on [%s]: %w (output: %s)", target, host.PublicIp, err, output)
+			}
+			return nil
+		}
+	}
+
+	logrus.WithField("hostId", host.Id).Warnf("no netem rule for [%s] found on [%s]", target, host.PublicIp)
+	return nil
+}
+
+// ClearAllNetem removes all netem rules by deleting the root qdisc from the default interface.
+// This is best-effort: if there are no rules, no error is returned.
+func (host *Host) ClearAllNetem() error {
+	dev, err := host.netemDevice()
+	if err != nil {
+		return err
+	}
+	if output, err := host.ExecLogged(clearAllNetemCmd(dev)); err != nil {
+		logrus.WithField("hostId", host.Id).Warnf("failed to clear netem on [%s]: %v (output: %s)", host.PublicIp, err, output)
+	}
+	return nil
+}
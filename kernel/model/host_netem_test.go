@@ -0,0 +1,94 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetemConfigArgs(t *testing.T) {
+	config := &NetemConfig{
+		Delay:   100 * time.Millisecond,
+		Jitter:  20 * time.Millisecond,
+		Loss:    0.5,
+		Reorder: 25,
+		Corrupt: 0.1,
+		Rate:    "10mbit",
+	}
+	assert.NoError(t, config.validate())
+	assert.Equal(t, "delay 100000us 20000us reorder 25% loss 0.5% corrupt 0.1% rate 10mbit", config.args())
+
+	assert.Equal(t, "loss 1%", (&NetemConfig{Loss: 1}).args())
+}
+
+func TestNetemConfigValidate(t *testing.T) {
+	assert.Error(t, (&NetemConfig{}).validate())
+	assert.Error(t, (&NetemConfig{Jitter: time.Millisecond}).validate())
+	assert.Error(t, (&NetemConfig{Reorder: 10, Loss: 1}).validate())
+	assert.Error(t, (&NetemConfig{Loss: 101}).validate())
+	assert.NoError(t, (&NetemConfig{Rate: "1mbit"}).validate())
+}
+
+func TestEnsureNetemRootCmd(t *testing.T) {
+	cmd := ensureNetemRootCmd("eth0")
+	assert.Contains(t, cmd, "sudo tc qdisc show dev eth0 | grep -q 'qdisc htb 1: root'")
+	assert.Contains(t, cmd, "sudo tc qdisc replace dev eth0 root handle 1: htb default 1")
+	assert.Contains(t, cmd, "sudo tc class add dev eth0 parent 1: classid 1:1 htb rate 10gbit")
+}
+
+func TestSetNetemGlobalCmd(t *testing.T) {
+	assert.Equal(t, "sudo tc qdisc replace dev eth0 parent 1:1 handle fffe: netem loss 2%",
+		setNetemGlobalCmd("eth0", &NetemConfig{Loss: 2}))
+	assert.Equal(t, "sudo tc qdisc del dev eth0 parent 1:1 handle fffe: 2>/dev/null || true", clearNetemGlobalCmd("eth0"))
+}
+
+func TestAddNetemClassCmd(t *testing.T) {
+	assert.Equal(t, "sudo tc class add dev eth0 parent 1: classid 1:1a htb rate 10gbit"+
+		" && sudo tc qdisc add dev eth0 parent 1:1a handle 1a: netem delay 5000us",
+		addNetemClassCmd("eth0", 0x1a, &NetemConfig{Delay: 5 * time.Millisecond}))
+}
+
+func TestAddNetemFiltersCmd(t *testing.T) {
+	assert.Equal(t, "sudo tc filter add dev eth0 parent 1: protocol ip prio 1 u32 match ip dst 10.0.0.1/32 flowid 1:2",
+		addNetemFiltersCmd("eth0", 2, NetemToIp("10.0.0.1")))
+	assert.Equal(t, "sudo tc filter add dev eth0 parent 1: protocol ip prio 1 u32 match ip dport 6262 0xffff flowid 1:3"+
+		" && sudo tc filter add dev eth0 parent 1: protocol ip prio 1 u32 match ip sport 6262 0xffff flowid 1:3",
+		addNetemFiltersCmd("eth0", 3, NetemToPort(6262)))
+}
+
+func TestRemoveNetemClassCmd(t *testing.T) {
+	assert.Equal(t, "sudo tc filter del dev eth0 parent 1: handle 800::800 prio 1 u32 && sudo tc class del dev eth0 classid 1:2",
+		removeNetemClassCmd("eth0", 2, []string{"800::800"}))
+}
+
+func TestParseNetemRules(t *testing.T) {
+	qdiscs := "qdisc htb 1: root refcnt 2 r2q 10 default 0x1 direct_packets_stat 0 direct_qlen 1000\n" +
+		"qdisc netem fffe: parent 1:1 limit 1000 loss 1%\n" +
+		"qdisc netem 2: parent 1:2 limit 1000 delay 100ms  20ms\n" +
+		"qdisc netem 3: parent 1:3 limit 1000 rate 10Mbit\n"
+	filters := "filter parent 1: protocol ip pref 1 u32 chain 0 \n" +
+		"filter parent 1: protocol ip pref 1 u32 chain 0 fh 800: ht divisor 1 \n" +
+		"filter parent 1: protocol ip pref 1 u32 chain 0 fh 800::800 order 2048 key ht 800 bkt 0 flowid 1:2 not_in_hw \n" +
+		"  match 0a000001/ffffffff at 16\n" +
+		"filter parent 1: protocol ip pref 1 u32 chain 0 fh 800::801 order 2049 key ht 800 bkt 0 flowid 1:3 not_in_hw \n" +
+		"  match 00001876/0000ffff at 20\n" +
+		"filter parent 1: protocol ip pref 1 u32 chain 0 fh 800::802 order 2050 key ht 800 bkt 0 flowid 1:3 not_in_hw \n" +
+		"  match 18760000/ffff0000 at 20\n"
+
+	rules := parseNetemRules(qdiscs, filters)
+	assert.Len(t, rules, 3)
+
+	assert.Equal(t, uint16(0), rules[0].ClassId)
+	assert.True(t, rules[0].Target.IsGlobal())
+	assert.Equal(t, "loss 1%", rules[0].Params)
+
+	assert.Equal(t, uint16(2), rules[1].ClassId)
+	assert.Equal(t, NetemToIp("10.0.0.1"), rules[1].Target)
+	assert.Equal(t, "delay 100ms 20ms", rules[1].Params)
+	assert.Equal(t, []string{"800::800"}, rules[1].filters)
+
+	assert.Equal(t, uint16(3), rules[2].ClassId)
+	assert.Equal(t, NetemToPort(6262), rules[2].Target)
+	assert.Equal(t, []string{"800::801", "800::802"}, rules[2].filters)
+}
@@ -18,41 +18,87 @@ package model
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
-// command construction helpers (unexported, testable)
+// command construction helpers (unexported, testable). These produce iptables commands, hosts without
+// iptables use the nftables backend via the rule methods below.
 
 func ensureFablabChainsCmd() string {
-	return "sudo iptables -N FABLAB_INPUT 2>/dev/null || true" +
-		" && sudo iptables -N FABLAB_OUTPUT 2>/dev/null || true" +
-		" && (sudo iptables -C INPUT -j FABLAB_INPUT 2>/dev/null || sudo iptables -I INPUT -j FABLAB_INPUT)" +
-		" && (sudo iptables -C OUTPUT -j FABLAB_OUTPUT 2>/dev/null || sudo iptables -I OUTPUT -j FABLAB_OUTPUT)"
+	return iptablesFirewall{}.ensureChainsCmd()
 }
 
 func blockIncomingCmd(port uint16) string {
-	return fmt.Sprintf("sudo iptables -A FABLAB_INPUT -p tcp --dport %d -j DROP", port)
+	return iptablesFirewall{}.blockCmd(IncomingRule(ProtocolTcp, SinglePort(port)))
 }
 
 func unblockIncomingCmd(port uint16) string {
-	return fmt.Sprintf("sudo iptables -D FABLAB_INPUT -p tcp --dport %d -j DROP", port)
+	return iptablesFirewall{}.unblockCmd(IncomingRule(ProtocolTcp, SinglePort(port)))
 }
 
 func blockOutgoingCmd(ip string, port uint16) string {
-	return fmt.Sprintf("sudo iptables -A FABLAB_OUTPUT -p tcp -d %s --dport %d -j DROP", ip, port)
+	return iptablesFirewall{}.blockCmd(OutgoingRule(ProtocolTcp, ip, SinglePort(port)))
 }
 
 func unblockOutgoingCmd(ip string, port uint16) string {
-	return fmt.Sprintf("sudo iptables -D FABLAB_OUTPUT -p tcp -d %s --dport %d -j DROP", ip, port)
+	return iptablesFirewall{}.unblockCmd(OutgoingRule(ProtocolTcp, ip, SinglePort(port)))
 }
 
 func blockOutgoingHostCmd(ip string) string {
-	return fmt.Sprintf("sudo iptables -A FABLAB_OUTPUT -d %s -j DROP", ip)
+	return iptablesFirewall{}.blockCmd(OutgoingRule(ProtocolAll, ip, PortRange{}))
 }
 
 func unblockOutgoingHostCmd(ip string) string {
-	return fmt.Sprintf("sudo iptables -D FABLAB_OUTPUT -d %s -j DROP", ip)
+	return iptablesFirewall{}.unblockCmd(OutgoingRule(ProtocolAll, ip, PortRange{}))
+}
+
+func blockOutgoingHostsCmd(ips []string) string {
+	return blockRulesCmd(iptablesFirewall{}, outgoingHostRules(ips))
+}
+
+func unblockOutgoingHostsCmd(ips []string) string {
+	return unblockRulesCmd(iptablesFirewall{}, outgoingHostRules(ips))
+}
+
+func blockRulesCmd(fw firewallBackend, rules []NetworkRule) string {
+	var cmds []string
+	for _, rule := range rules {
+		cmds = append(cmds, fw.blockCmd(rule))
+	}
+	return strings.Join(cmds, " && ")
+}
+
+func unblockRulesCmd(fw firewallBackend, rules []NetworkRule) string {
+	var cmds []string
+	for _, rule := range rules {
+		cmds = append(cmds, fw.unblockCmd(rule))
+	}
+	return strings.Join(cmds, "; ")
+}
+
+// removeRulesCmd removes one instance of each of the rules, skipping rules which aren't installed
+func removeRulesCmd(fw firewallBackend, rules []NetworkRule) string {
+	var cmds []string
+	for _, rule := range rules {
+		cmds = append(cmds, ignoreMissingRuleCmd(fw.unblockCmd(rule)))
+	}
+	return strings.Join(cmds, "; ")
+}
+
+// ignoreMissingRuleCmd makes an unblock command succeed if the rule isn't installed, so that the result only
+// reflects whether the host could be reached
+func ignoreMissingRuleCmd(cmd string) string {
+	return "{ " + cmd + "; } 2>/dev/null || true"
+}
+
+func outgoingHostRules(ips []string) []NetworkRule {
+	var result []NetworkRule
+	for _, ip := range ips {
+		result = append(result, OutgoingRule(ProtocolAll, ip, PortRange{}))
+	}
+	return result
 }
 
 func killOutgoingHostCmd(ip string) string {
@@ -60,8 +106,7 @@ func killOutgoingHostCmd(ip string) string {
 }
 
 func unblockAllCmd() string {
-	return "sudo iptables -F FABLAB_INPUT 2>/dev/null || true" +
-		" && sudo iptables -F FABLAB_OUTPUT 2>/dev/null || true"
+	return iptablesFirewall{}.unblockAllCmd()
 }
 
 func killIncomingCmd(port uint16) string {
@@ -72,63 +117,137 @@ func killOutgoingCmd(ip string, port uint16) string {
 	return fmt.Sprintf("sudo ss -K -t state established dst %s:%d", ip, port)
 }
 
-// ensureFablabChains idempotently creates the FABLAB_INPUT and FABLAB_OUTPUT
-// iptables chains and inserts jump rules from the built-in INPUT/OUTPUT chains.
-func (host *Host) ensureFablabChains() error {
-	if output, err := host.ExecLogged(ensureFablabChainsCmd()); err != nil {
-		return fmt.Errorf("failed to ensure fablab iptables chains on [%s]: %w (output: %s)", host.PublicIp, err, output)
+// ensureFablabChains idempotently creates the FABLAB_INPUT and FABLAB_OUTPUT chains and hooks them
+// into incoming and outgoing traffic processing.
+func (host *Host) ensureFablabChains(fw firewallBackend) error {
+	if output, err := host.ExecLogged(fw.ensureChainsCmd()); err != nil {
+		return fmt.Errorf("failed to ensure fablab %s chains on [%s]: %w (output: %s)", fw.name(), host.PublicIp, err, output)
 	}
 	return nil
 }
 
-// BlockIncoming adds an iptables rule to DROP all incoming TCP traffic on the given port.
-func (host *Host) BlockIncoming(port uint16) error {
-	if err := host.ensureFablabChains(); err != nil {
+// BlockRule adds a rule to DROP the traffic matched by the given rule. iptables is used if it's available,
+// otherwise nftables.
+func (host *Host) BlockRule(rule NetworkRule) error {
+	return host.BlockRules(rule)
+}
+
+// BlockRules adds rules to DROP the traffic matched by each of the given rules, using a single remote command.
+// The rules are recorded as disruptions before they're applied, so they can be healed even if applying them fails
+// part way, or the process exits before it cleans up. If applying them fails, any rules which were applied are
+// removed again.
+func (host *Host) BlockRules(rules ...NetworkRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid rule [%s]: %w", rule, err)
+		}
+	}
+
+	fw, err := host.getFirewall()
+	if err != nil {
+		return err
+	}
+
+	if err = host.ensureFablabChains(fw); err != nil {
 		return err
 	}
 
-	if output, err := host.ExecLogged(blockIncomingCmd(port)); err != nil {
-		return fmt.Errorf("failed to block incoming port %d on [%s]: %w (output: %s)", port, host.PublicIp, err, output)
+	disruptions := host.newDisruptions(fw, rules)
+	if err = GetDisruptionRegistry().Add(disruptions...); err != nil {
+		return fmt.Errorf("unable to record %s on [%s] before blocking: %w", describeRules(rules), host.PublicIp, err)
+	}
+
+	if output, err := host.ExecLogged(blockRulesCmd(fw, rules)); err != nil {
+		if undoOutput, undoErr := host.ExecLogged(removeRulesCmd(fw, rules)); undoErr != nil {
+			logrus.WithField("hostId", host.Id).Warnf("failed to remove partially applied %s on [%s], left for heal: %v (output: %s)",
+				describeRules(rules), host.PublicIp, undoErr, undoOutput)
+		} else {
+			host.clearDisruptions(disruptions...)
+		}
+		return fmt.Errorf("failed to block %s on [%s]: %w (output: %s)", describeRules(rules), host.PublicIp, err, output)
 	}
 	return nil
 }
 
-// UnblockIncoming removes the iptables rule that DROPs incoming TCP traffic on the given port.
+// UnblockRule removes a rule previously added with BlockRule.
 // This is best-effort: if the rule doesn't exist, a warning is logged but no error is returned.
-func (host *Host) UnblockIncoming(port uint16) error {
-	if output, err := host.ExecLogged(unblockIncomingCmd(port)); err != nil {
-		logrus.WithField("hostId", host.Id).Warnf("failed to unblock incoming port %d on [%s]: %v (output: %s)", port, host.PublicIp, err, output)
+func (host *Host) UnblockRule(rule NetworkRule) error {
+	return host.UnblockRules(rule)
+}
+
+// UnblockRules removes one rule for each of the given rules, using a single remote command.
+// This is best-effort: rules which don't exist are skipped. If the host can't be reached, a warning is logged,
+// the rules stay recorded as disruptions, so they can be healed later, and no error is returned.
+func (host *Host) UnblockRules(rules ...NetworkRule) error {
+	if err := host.RemoveRules(rules...); err != nil {
+		logrus.WithField("hostId", host.Id).Warn(err.Error())
 	}
 	return nil
 }
 
-// BlockOutgoing adds an iptables rule to DROP all outgoing TCP traffic to the given ip and port.
-func (host *Host) BlockOutgoing(ip string, port uint16) error {
-	if err := host.ensureFablabChains(); err != nil {
+// RemoveRules removes one rule for each of the given rules, using a single remote command, like UnblockRules, but
+// returns an error if the host can't be reached. Rules which don't exist are skipped.
+func (host *Host) RemoveRules(rules ...NetworkRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	fw, err := host.getFirewall()
+	if err != nil {
 		return err
 	}
 
-	if output, err := host.ExecLogged(blockOutgoingCmd(ip, port)); err != nil {
-		return fmt.Errorf("failed to block outgoing %s:%d on [%s]: %w (output: %s)", ip, port, host.PublicIp, err, output)
+	if output, err := host.ExecLogged(removeRulesCmd(fw, rules)); err != nil {
+		return fmt.Errorf("failed to unblock %s on [%s]: %w (output: %s)", describeRules(rules), host.PublicIp, err, output)
 	}
+	host.clearDisruptions(host.newDisruptions(fw, rules)...)
 	return nil
 }
 
-// UnblockOutgoing removes the iptables rule that DROPs outgoing TCP traffic to the given ip and port.
+func describeRules(rules []NetworkRule) string {
+	if len(rules) == 1 {
+		return rules[0].String()
+	}
+	return fmt.Sprintf("%d rules", len(rules))
+}
+
+// BlockIncoming adds a rule to DROP all incoming TCP traffic on the given port.
+func (host *Host) BlockIncoming(port uint16) error {
+	return host.BlockRule(IncomingRule(ProtocolTcp, SinglePort(port)))
+}
+
+// UnblockIncoming removes the rule that DROPs incoming TCP traffic on the given port.
+// This is best-effort: if the rule doesn't exist, a warning is logged but no error is returned.
+func (host *Host) UnblockIncoming(port uint16) error {
+	return host.UnblockRule(IncomingRule(ProtocolTcp, SinglePort(port)))
+}
+
+// BlockOutgoing adds a rule to DROP all outgoing TCP traffic to the given ip and port.
+func (host *Host) BlockOutgoing(ip string, port uint16) error {
+	return host.BlockRule(OutgoingRule(ProtocolTcp, ip, SinglePort(port)))
+}
+
+// UnblockOutgoing removes the rule that DROPs outgoing TCP traffic to the given ip and port.
 // This is best-effort: if the rule doesn't exist, a warning is logged but no error is returned.
 func (host *Host) UnblockOutgoing(ip string, port uint16) error {
-	if output, err := host.ExecLogged(unblockOutgoingCmd(ip, port)); err != nil {
-		logrus.WithField("hostId", host.Id).Warnf("failed to unblock outgoing %s:%d on [%s]: %v (output: %s)", ip, port, host.PublicIp, err, output)
-	}
-	return nil
+	return host.UnblockRule(OutgoingRule(ProtocolTcp, ip, SinglePort(port)))
 }
 
 // UnblockAll flushes all rules from the FABLAB_INPUT and FABLAB_OUTPUT chains.
 // This is best-effort: if the chains don't exist, a warning is logged but no error is returned.
 func (host *Host) UnblockAll() error {
-	if output, err := host.ExecLogged(unblockAllCmd()); err != nil {
+	if fw, err := host.getFirewall(); err != nil {
+		logrus.WithField("hostId", host.Id).WithError(err).Warn("unable to unblock all")
+	} else if output, err := host.ExecLogged(fw.unblockAllCmd()); err != nil {
 		logrus.WithField("hostId", host.Id).Warnf("failed to unblock all on [%s]: %v (output: %s)", host.PublicIp, err, output)
 	}
+	if err := GetDisruptionRegistry().RemoveHost(GetEntityPathId(host)); err != nil {
+		logrus.WithField("hostId", host.Id).WithError(err).Error("unable to clear network disruption records")
+	}
 	return nil
 }
 
@@ -164,26 +283,28 @@ func (host *Host) DisruptOutgoing(ip string, port uint16) error {
 	return host.BlockOutgoing(ip, port)
 }
 
-// BlockOutgoingHost adds an iptables rule to DROP all outgoing traffic to the given IP,
+// BlockOutgoingHost adds a rule to DROP all outgoing traffic to the given IP,
 // regardless of port or protocol.
 func (host *Host) BlockOutgoingHost(ip string) error {
-	if err := host.ensureFablabChains(); err != nil {
-		return err
-	}
-
-	if output, err := host.ExecLogged(blockOutgoingHostCmd(ip)); err != nil {
-		return fmt.Errorf("failed to block outgoing to %s on [%s]: %w (output: %s)", ip, host.PublicIp, err, output)
-	}
-	return nil
+	return host.BlockRule(OutgoingRule(ProtocolAll, ip, PortRange{}))
 }
 
-// UnblockOutgoingHost removes the iptables rule that DROPs all outgoing traffic to the given IP.
+// UnblockOutgoingHost removes the rule that DROPs all outgoing traffic to the given IP.
 // This is best-effort: if the rule doesn't exist, a warning is logged but no error is returned.
 func (host *Host) UnblockOutgoingHost(ip string) error {
-	if output, err := host.ExecLogged(unblockOutgoingHostCmd(ip)); err != nil {
-		logrus.WithField("hostId", host.Id).Warnf("failed to unblock outgoing to %s on [%s]: %v (output: %s)", ip, host.PublicIp, err, output)
-	}
-	return nil
+	return host.UnblockRule(OutgoingRule(ProtocolAll, ip, PortRange{}))
+}
+
+// BlockOutgoingHosts adds rules to DROP all outgoing traffic to each of the given IPs,
+// using a single remote command. This is more efficient than calling BlockOutgoingHost for each IP.
+func (host *Host) BlockOutgoingHosts(ips ...string) error {
+	return host.BlockRules(outgoingHostRules(ips)...)
+}
+
+// UnblockOutgoingHosts removes one rule DROPping outgoing traffic for each of the given IPs.
+// This is best-effort: if rules don't exist, a warning is logged but no error is returned.
+func (host *Host) UnblockOutgoingHosts(ips ...string) error {
+	return host.UnblockRules(outgoingHostRules(ips)...)
 }
 
 // KillOutgoingHost kills all established TCP connections to the given remote IP using ss -K.
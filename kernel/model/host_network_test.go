@@ -69,6 +69,16 @@ func TestUnblockOutgoingHostCmd(t *testing.T) {
 	assert.Equal(t, "sudo iptables -D FABLAB_OUTPUT -d 10.0.0.1 -j DROP", unblockOutgoingHostCmd("10.0.0.1"))
 }
 
+func TestBlockOutgoingHostsCmd(t *testing.T) {
+	assert.Equal(t, "sudo iptables -A FABLAB_OUTPUT -d 10.0.0.1 -j DROP && sudo iptables -A FABLAB_OUTPUT -d 10.0.0.2 -j DROP",
+		blockOutgoingHostsCmd([]string{"10.0.0.1", "10.0.0.2"}))
+}
+
+func TestUnblockOutgoingHostsCmd(t *testing.T) {
+	assert.Equal(t, "sudo iptables -D FABLAB_OUTPUT -d 10.0.0.1 -j DROP; sudo iptables -D FABLAB_OUTPUT -d 10.0.0.2 -j DROP",
+		unblockOutgoingHostsCmd([]string{"10.0.0.1", "10.0.0.2"}))
+}
+
 func TestKillOutgoingHostCmd(t *testing.T) {
 	assert.Equal(t, "sudo ss -K -t state established dst 10.0.0.1", killOutgoingHostCmd("10.0.0.1"))
 }
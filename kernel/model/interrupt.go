@@ -0,0 +1,65 @@
+/*
+	(c) Copyright NetFoundry Inc. Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package model
+
+import "sync"
+
+var interruptHandlers struct {
+	sync.Mutex
+	nextId   int
+	handlers []interruptHandler
+}
+
+type interruptHandler struct {
+	id int
+	f  func()
+}
+
+// OnInterrupt registers a handler to run if the process is interrupted or terminated, before it exits. Actions which
+// undo their changes when they complete register a handler while they run, so the changes are also undone if the
+// process is interrupted. The returned function unregisters the handler.
+func OnInterrupt(f func()) func() {
+	interruptHandlers.Lock()
+	defer interruptHandlers.Unlock()
+
+	id := interruptHandlers.nextId
+	interruptHandlers.nextId++
+	interruptHandlers.handlers = append(interruptHandlers.handlers, interruptHandler{id: id, f: f})
+
+	return func() {
+		interruptHandlers.Lock()
+		defer interruptHandlers.Unlock()
+		for idx, handler := range interruptHandlers.handlers {
+			if handler.id == id {
+				interruptHandlers.handlers = append(interruptHandlers.handlers[:idx], interruptHandlers.handlers[idx+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// RunInterruptHandlers runs the registered handlers, most recently registered first, and unregisters them
+func RunInterruptHandlers() {
+	interruptHandlers.Lock()
+	handlers := interruptHandlers.handlers
+	interruptHandlers.handlers = nil
+	interruptHandlers.Unlock()
+
+	for i := len(handlers) - 1; i >= 0; i-- {
+		handlers[i].f()
+	}
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterruptHandlers(t *testing.T) {
+	var calls []string
+	OnInterrupt(func() { calls = append(calls, "first") })
+	remove := OnInterrupt(func() { calls = append(calls, "removed") })
+	OnInterrupt(func() { calls = append(calls, "last") })
+	remove()
+
+	RunInterruptHandlers()
+	require.Equal(t, []string{"last", "first"}, calls)
+
+	RunInterruptHandlers()
+	require.Equal(t, []string{"last", "first"}, calls)
+}
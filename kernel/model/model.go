@@ -17,17 +17,19 @@
 package model
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"io"
 	"io/fs"
 	"maps"
+	"net"
 	"os"
-	"path"
 	"path/filepath"
 	"reflect"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -40,9 +42,7 @@ import (
 	"github.com/openziti/foundation/v2/util"
 	cmap "github.com/orcaman/concurrent-map/v2"
 	"github.com/pkg/errors"
-	"github.com/pkg/sftp"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/ssh"
 )
 
 const (
@@ -240,6 +240,11 @@ type Model struct {
 
 	initialized atomic.Bool
 
+	stateHandlers struct {
+		sync.Mutex
+		handlers []ComponentStateHandler
+	}
+
 	regionIds    IdPool
 	hostIds      IdPool
 	componentIds IdPool
@@ -570,9 +575,8 @@ type Host struct {
 	ScaleIndex           uint32
 	initialized          atomic.Bool
 	lock                 sync.Mutex
-	sshLock              sync.Mutex
-	sshClient            *ssh.Client
-	sshConfigFactory     libssh.SshConfigFactory
+	firewallLock         sync.Mutex
+	firewall             firewallBackend
 }
 
 func (host *Host) DoExclusive(f func()) {
@@ -588,28 +592,17 @@ func (host *Host) DoExclusiveFallible(f func() error) error {
 }
 
 func (host *Host) ExecLoggedWithTimeout(timeout time.Duration, cmds ...string) (string, error) {
-	resultCh := make(chan struct {
-		output string
-		err    error
-	}, 1)
-
-	go func() {
-		result, err := host.ExecLogged(cmds...)
-		resultCh <- struct {
-			output string
-			err    error
-		}{
-			output: result,
-			err:    err,
-		}
-	}()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	select {
-	case result := <-resultCh:
-		return result.output, result.err
-	case <-time.After(timeout):
-		return "", errors.Errorf("timed out after %v", timeout)
+	buf := &libssh.SyncBuffer{}
+	if err := host.ExecContext(ctx, buf, cmds...); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", errors.Errorf("timed out after %v", timeout)
+		}
+		return buf.String(), err
 	}
+	return buf.String(), nil
 }
 
 func (host *Host) ExecLogged(cmds ...string) (string, error) {
@@ -630,101 +623,296 @@ func (host *Host) GetSshUser() string {
 	return host.MustStringVariable("credentials.ssh.username")
 }
 
-func (host *Host) NewSshConfigFactory() *libssh.SshConfigFactoryImpl {
-	keyPath := host.MustStringVariable("credentials.ssh.key_path")
-	return libssh.NewSshConfigFactory(host.GetSshUser(), keyPath, host.PublicIp)
+// GetSshPort returns the credentials.ssh.port of the host, defaulting to 22
+func (host *Host) GetSshPort() (int, error) {
+	value := host.GetStringVariableOr("credentials.ssh.port", "22")
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid ssh port [%s] for host [%s]", value, host.GetPath())
+	}
+	return port, nil
 }
 
-func (host *Host) Exec(out io.Writer, cmds ...string) error {
-	host.sshLock.Lock()
-	defer host.sshLock.Unlock()
+// GetHomeDir returns the home directory of the ssh user on the host, which can be set with ssh.home_dir. Defaults
+// to /root for root and /home/<user> for other users.
+func (host *Host) GetHomeDir() string {
+	if homeDir := host.GetStringVariableOr("ssh.home_dir", ""); homeDir != "" {
+		return homeDir
+	}
+	if user := host.GetSshUser(); user != "root" {
+		return "/home/" + user
+	}
+	return "/root"
+}
 
-	if host.sshClient == nil {
-		if host.sshConfigFactory == nil {
-			host.sshConfigFactory = host.NewSshConfigFactory()
-		}
+// NewSshConfigFactory returns the ssh configuration for the host. The credentials.ssh variables are resolved for
+// the host, so they can differ between hosts:
+//
+//   - username: the user to log in as
+//   - port: the ssh port, defaults to 22
+//   - key_path: the private key file
+//   - password: a password, tried after the key file and the ssh agent. The ssh client run by rsync can't be given
+//     the password, so rsync distributions need a key or the ssh agent to be accepted by the host as well
+//   - agent_only: if true, only keys from the ssh agent are used, ignoring key_path
+//
+// If the ssh.jump_host variable is set for the host, connections are tunneled through the jump host, to the
+// private address of the host, or to its public address if it has no private address. The jump host is either a
+// selector matching a single host in the model, which may have a jump host of its own, or an external address in
+// the form [user@]host[:port], which is logged into with the credentials of the host. Jump hosts are checked when
+// the model is bootstrapped.
+func (host *Host) NewSshConfigFactory() (*libssh.SshConfigFactoryImpl, error) {
+	return host.newSshConfigFactory(nil)
+}
 
-		client, err := ssh.Dial("tcp", host.sshConfigFactory.Address(), host.sshConfigFactory.Config())
-		if err != nil {
-			return err
-		}
-		host.sshClient = client
+func (host *Host) newSshConfigFactory(chain []*Host) (*libssh.SshConfigFactoryImpl, error) {
+	jumpHost, err := host.newJumpHostFactory(append(chain, host))
+	if err != nil {
+		return nil, err
 	}
 
-	for idx, cmd := range cmds {
-		session, err := host.sshClient.NewSession()
+	port, err := host.GetSshPort()
+	if err != nil {
+		return nil, err
+	}
+
+	address := host.PublicIp
+	if jumpHost != nil && host.PrivateIp != "" {
+		address = host.PrivateIp
+	}
+	factory, err := host.newCredentialsFactory(host.GetSshUser(), address, port)
+	if err != nil {
+		return nil, err
+	}
+	if jumpHost != nil {
+		factory.SetJumpHost(jumpHost)
+	}
+	return factory, nil
+}
+
+// newJumpHostFactory returns the ssh configuration for the host's jump host, or nil if the host is connected to
+// directly. The chain holds the hosts being tunneled to, so that cycles are caught.
+func (host *Host) newJumpHostFactory(chain []*Host) (libssh.SshConfigFactory, error) {
+	jumpHost, spec, err := host.getJumpHost(chain)
+	if err != nil || spec == "" {
+		return nil, err
+	}
+
+	if jumpHost != nil {
+		factory, err := jumpHost.newSshConfigFactory(chain)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		session.Stdout = out
-		session.Stderr = out
+		return factory, nil
+	}
 
-		if idx > 0 {
-			logrus.Infof("executing [%s]: '%s'", host.sshConfigFactory.Address(), cmd)
+	user, address, port, err := host.parseJumpHost(spec)
+	if err != nil {
+		return nil, err
+	}
+	if user == "" {
+		user = host.GetSshUser()
+	}
+	return host.newCredentialsFactory(user, address, port)
+}
+
+// getJumpHost returns the ssh.jump_host variable of the host, and the host in the model it selects, if any. The
+// spec is empty if the host is connected to directly.
+func (host *Host) getJumpHost(chain []*Host) (*Host, string, error) {
+	spec := host.GetStringVariableOr("ssh.jump_host", "")
+	if spec == "" {
+		return nil, "", nil
+	}
+
+	jumpHosts := host.GetModel().SelectHosts(spec)
+	if len(jumpHosts) > 1 {
+		return nil, "", fmt.Errorf("jump host [%s] for host [%s] matched [%d] hosts, must match exactly 1", spec, host.GetPath(), len(jumpHosts))
+	}
+	if len(jumpHosts) == 0 {
+		return nil, spec, nil
+	}
+
+	jumpHost := jumpHosts[0]
+	if jumpHost == host {
+		// the jump host inherited the variable from its region or model
+		return nil, "", nil
+	}
+	if slices.Contains(chain, jumpHost) {
+		return nil, "", fmt.Errorf("jump host [%s] for host [%s] leads back to host [%s]", spec, host.GetPath(), jumpHost.GetPath())
+	}
+	return jumpHost, spec, nil
+}
+
+// parseJumpHost parses an external jump host in the form [user@]host[:port]. The user is empty if not given.
+func (host *Host) parseJumpHost(spec string) (user, address string, port int, err error) {
+	address, port = spec, 22
+	if idx := strings.LastIndex(address, "@"); idx >= 0 {
+		user, address = address[:idx], address[idx+1:]
+	}
+	if h, p, splitErr := net.SplitHostPort(address); splitErr == nil {
+		if port, err = strconv.Atoi(p); err != nil || port < 1 || port > 65535 {
+			return "", "", 0, fmt.Errorf("invalid port in jump host [%s] for host [%s]", spec, host.GetPath())
 		}
-		err = session.Run(cmd)
-		_ = session.Close()
+		address = h
+	}
+	if address == "" {
+		return "", "", 0, fmt.Errorf("invalid jump host [%s] for host [%s]", spec, host.GetPath())
+	}
+	return user, address, port, nil
+}
 
-		if err != nil {
+// checkJumpHost checks the jump hosts of the host, following jump hosts in the model, so that invalid jump hosts
+// are reported when the model is bootstrapped
+func (host *Host) checkJumpHost(chain []*Host) error {
+	jumpHost, spec, err := host.getJumpHost(chain)
+	if err != nil || spec == "" {
+		return err
+	}
+	if jumpHost == nil {
+		_, _, _, err = host.parseJumpHost(spec)
+		return err
+	}
+	return jumpHost.checkJumpHost(append(chain, jumpHost))
+}
+
+// validateSshConfig checks the ssh variables of all hosts
+func (m *Model) validateSshConfig() error {
+	for _, host := range m.SelectHosts("*") {
+		if _, err := host.GetSshPort(); err != nil {
+			return err
+		}
+		if err := host.checkJumpHost([]*Host{host}); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
-func (host *Host) SendFile(localPath string, remotePath string) error {
-	localFile, err := os.ReadFile(localPath)
+// newCredentialsFactory returns the ssh configuration for the address, authenticating with the host's credentials
+func (host *Host) newCredentialsFactory(user, address string, port int) (*libssh.SshConfigFactoryImpl, error) {
+	knownHosts, err := KnownHosts()
+	if err != nil {
+		return nil, err
+	}
+	factory := libssh.NewSshConfigFactory(user, host.GetStringVariableOr("credentials.ssh.key_path", ""), address)
+	factory.SetPort(port)
+	factory.SetPassword(host.GetStringVariableOr("credentials.ssh.password", ""))
+	factory.SetAgentOnly(host.GetFlag("credentials.ssh.agent_only"))
+	factory.SetKnownHosts(knownHosts)
+	return factory, nil
+}
 
+// ResetHostKey forgets the recorded host key, so that the key presented on the next connection is trusted. Used
+// when a host has legitimately been replaced. Returns the number of keys removed.
+func (host *Host) ResetHostKey() (int, error) {
+	factory, err := host.NewSshConfigFactory()
 	if err != nil {
-		return errors.Wrapf(err, "unable to read local file %v", localFile)
+		return 0, err
 	}
+	return factory.KnownHosts().Remove(libssh.HostKeyAddress(factory))
+}
 
-	return host.SendData(localFile, remotePath)
+var instanceKnownHosts struct {
+	sync.Mutex
+	knownHosts *libssh.KnownHosts
 }
 
-func (host *Host) SendData(data []byte, remotePath string) error {
-	host.sshLock.Lock()
-	defer host.sshLock.Unlock()
+// KnownHosts returns the host keys of the active instance, which are recorded on the first connection to each host
+// and verified on later connections. Returns an error if there is no active instance.
+func KnownHosts() (*libssh.KnownHosts, error) {
+	instance, err := loadActiveInstanceConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to verify ssh host keys, which are recorded per instance (%w)", err)
+	}
+	if instance.WorkingDirectory == "" {
+		return nil, fmt.Errorf("unable to verify ssh host keys, instance [%s] has no working directory", instance.Id)
+	}
 
-	if host.sshClient == nil {
-		if host.sshConfigFactory == nil {
-			host.sshConfigFactory = host.NewSshConfigFactory()
-		}
+	instanceKnownHosts.Lock()
+	defer instanceKnownHosts.Unlock()
 
-		client, err := ssh.Dial("tcp", host.sshConfigFactory.Address(), host.sshConfigFactory.Config())
-		if err != nil {
+	path := filepath.Join(instance.WorkingDirectory, "known_hosts")
+	if instanceKnownHosts.knownHosts == nil || instanceKnownHosts.knownHosts.Path() != path {
+		instanceKnownHosts.knownHosts = libssh.NewKnownHosts(path)
+	}
+	return instanceKnownHosts.knownHosts, nil
+}
+
+// SshClient returns the pooled ssh client for the host, which all ssh operations against the host share
+func (host *Host) SshClient() (*libssh.PooledClient, error) {
+	factory, err := host.NewSshConfigFactory()
+	if err != nil {
+		return nil, err
+	}
+	return libssh.GetClient(factory), nil
+}
+
+func (host *Host) Exec(out io.Writer, cmds ...string) error {
+	return host.ExecContext(context.Background(), out, cmds...)
+}
+
+// ExecContext runs the commands like Exec, giving up once ctx is done. The running command's session is closed,
+// so it doesn't keep holding one of the host's pooled ssh sessions.
+func (host *Host) ExecContext(ctx context.Context, out io.Writer, cmds ...string) error {
+	client, err := host.SshClient()
+	if err != nil {
+		return err
+	}
+	for idx, cmd := range cmds {
+		if idx > 0 {
+			logrus.Infof("executing [%s]: '%s'", client.Address(), cmd)
+		}
+		if err := client.ExecContext(ctx, out, cmd); err != nil {
 			return err
 		}
-		host.sshClient = client
 	}
+	return nil
+}
 
-	client, err := sftp.NewClient(host.sshClient)
+// ExecStream runs cmd on the host, streaming stdout and stderr line by line. A command which fails returns a
+// *libssh.ExitError.
+func (host *Host) ExecStream(cmd string, options *libssh.ExecOptions) error {
+	client, err := host.SshClient()
 	if err != nil {
-		return errors.Wrap(err, "error creating sftp client")
+		return err
 	}
-	defer func() { _ = client.Close() }()
+	return client.ExecStream(cmd, options)
+}
 
-	path.Dir(remotePath)
-	logrus.Infof("Creating paths %s", path.Dir(remotePath))
-	if err := client.MkdirAll(path.Dir(remotePath)); err != nil {
-		return errors.Wrapf(err, "unable to create directories for %v", remotePath)
+// LocalForward listens on the local bindAddr, forwarding connections through the host to targetAddr. The host
+// connects to the target, so it may be a port bound to the host's loopback or a private address reachable from it.
+func (host *Host) LocalForward(bindAddr, targetAddr string) (*libssh.Tunnel, error) {
+	client, err := host.SshClient()
+	if err != nil {
+		return nil, err
 	}
+	return client.LocalForward(bindAddr, targetAddr)
+}
 
-	rmtFile, err := client.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+// RemoteForward listens on bindAddr on the host, forwarding connections back to the local targetAddr
+func (host *Host) RemoteForward(bindAddr, targetAddr string) (*libssh.Tunnel, error) {
+	client, err := host.SshClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.RemoteForward(bindAddr, targetAddr)
+}
+
+func (host *Host) SendFile(localPath string, remotePath string) error {
+	localFile, err := os.ReadFile(localPath)
 
 	if err != nil {
-		return errors.Wrapf(err, "unable to open remote file %v", remotePath)
+		return errors.Wrapf(err, "unable to read local file %v", localFile)
 	}
-	defer func() { _ = rmtFile.Close() }()
 
-	_, err = rmtFile.Write(data)
+	return host.SendData(localFile, remotePath)
+}
 
+func (host *Host) SendData(data []byte, remotePath string) error {
+	factory, err := host.NewSshConfigFactory()
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return libssh.SendData(factory, data, remotePath)
 }
 
 func (host *Host) FindProcesses(filter func(string) bool) ([]int, error) {
@@ -957,6 +1145,7 @@ func NewRun() (Run, error) {
 		instanceConfig: instanceConfig,
 		oneTimeOps:     cmap.New[*oneTimeOpContext](),
 	}
+	setDisruptionRunId(result.runId)
 	return result.init()
 }
 
@@ -1336,6 +1525,32 @@ func (m *Model) AcceptHostMetrics(host *Host, event *MetricsEvent) {
 	}
 }
 
+// AddStateHandler registers a handler to be notified of component state changes. The returned function removes
+// the handler.
+func (m *Model) AddStateHandler(handler ComponentStateHandler) func() {
+	m.stateHandlers.Lock()
+	defer m.stateHandlers.Unlock()
+	m.stateHandlers.handlers = append(m.stateHandlers.handlers, handler)
+
+	return func() {
+		m.stateHandlers.Lock()
+		defer m.stateHandlers.Unlock()
+		m.stateHandlers.handlers = slices.DeleteFunc(slices.Clone(m.stateHandlers.handlers), func(h ComponentStateHandler) bool {
+			return h == handler
+		})
+	}
+}
+
+func (m *Model) AcceptComponentState(c *Component, running bool) {
+	m.stateHandlers.Lock()
+	handlers := m.stateHandlers.handlers
+	m.stateHandlers.Unlock()
+
+	for _, handler := range handlers {
+		handler.AcceptComponentState(c, running)
+	}
+}
+
 func GetScopedEntityPath(entity Entity) []string {
 	parent := entity.GetParentEntity()
 	if parent != nil {
@@ -18,9 +18,12 @@ package model
 
 import (
 	"fmt"
+	"path/filepath"
 	"testing"
 
+	"github.com/openziti/fablab/kernel/libssh"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetVariable(t *testing.T) {
@@ -50,3 +53,250 @@ func TestGetVariable(t *testing.T) {
 	_, found = m.GetVariable("d.e.f")
 	assert.False(t, found)
 }
+
+// useTestInstance makes a temporary instance the active instance until the test completes
+func useTestInstance(t *testing.T) {
+	previous := instanceConfig
+	instanceConfig = &InstanceConfig{Id: "test", WorkingDirectory: t.TempDir()}
+	t.Cleanup(func() { instanceConfig = previous })
+}
+
+func newJumpHostTestModel(t *testing.T, hosts Hosts) *Model {
+	useTestInstance(t)
+
+	m := &Model{
+		Id: "test",
+		Scope: Scope{
+			Defaults: Variables{
+				"credentials": Variables{
+					"ssh": Variables{
+						"username": "ubuntu",
+						"key_path": "/keys/id",
+					},
+				},
+				"ssh": Variables{
+					"jump_host": "#edge",
+				},
+			},
+		},
+		Regions: Regions{
+			"region1": {Hosts: hosts},
+		},
+	}
+	require.NoError(t, m.init())
+	return m
+}
+
+func hostWithJump(publicIp, privateIp, jumpHost string) *Host {
+	host := &Host{PublicIp: publicIp, PrivateIp: privateIp}
+	if jumpHost != "" {
+		host.Scope.Defaults = Variables{"ssh": Variables{"jump_host": jumpHost}}
+	}
+	return host
+}
+
+func TestJumpHosts(t *testing.T) {
+	m := newJumpHostTestModel(t, Hosts{
+		"edge":    hostWithJump("1.2.3.4", "10.0.0.10", ""),
+		"mid":     hostWithJump("", "10.0.0.1", ""),
+		"inner":   hostWithJump("", "10.0.0.2", "#mid"),
+		"outside": hostWithJump("5.6.7.8", "10.0.0.3", "admin@bastion.example.com:2200"),
+	})
+	require.NoError(t, m.validateSshConfig())
+
+	factory := func(id string) *libssh.SshConfigFactoryImpl {
+		result, err := m.Regions["region1"].Hosts[id].NewSshConfigFactory()
+		require.NoError(t, err)
+		return result
+	}
+
+	// the jump host inherits the model jump host, which is itself
+	require.Nil(t, factory("edge").JumpHost())
+	require.Equal(t, "1.2.3.4:22", factory("edge").Address())
+
+	// hosts behind a jump host are reached at their private address
+	require.Equal(t, "10.0.0.1:22", factory("mid").Address())
+	jump := factory("mid").JumpHost()
+	require.Equal(t, "1.2.3.4:22", jump.Address())
+
+	jump = factory("inner").JumpHost()
+	require.Equal(t, "10.0.0.1:22", jump.Address())
+	require.Equal(t, "1.2.3.4:22", jump.(*libssh.SshConfigFactoryImpl).JumpHost().Address())
+
+	jump = factory("outside").JumpHost()
+	require.Equal(t, "10.0.0.3:22", factory("outside").Address())
+	require.Equal(t, "bastion.example.com:2200", jump.Address())
+	require.Equal(t, "admin", jump.User())
+	require.Equal(t, "/keys/id", jump.KeyPath())
+}
+
+func TestInvalidJumpHosts(t *testing.T) {
+	cases := map[string]struct {
+		hosts Hosts
+		err   string
+	}{
+		"cycle": {
+			hosts: Hosts{
+				"edge": hostWithJump("1.2.3.4", "", ""),
+				"a":    hostWithJump("", "10.0.0.4", "#b"),
+				"b":    hostWithJump("", "10.0.0.5", "#a"),
+			},
+			err: "leads back to host",
+		},
+		"ambiguous": {
+			hosts: Hosts{
+				"edge": hostWithJump("1.2.3.4", "", ""),
+				"a":    hostWithJump("", "10.0.0.4", "*"),
+			},
+			err: "must match exactly 1",
+		},
+		"bad port": {
+			hosts: Hosts{
+				"edge": hostWithJump("1.2.3.4", "", ""),
+				"a":    hostWithJump("", "10.0.0.4", "bastion.example.com:ssh"),
+			},
+			err: "invalid port in jump host [bastion.example.com:ssh]",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			m := newJumpHostTestModel(t, c.hosts)
+			require.ErrorContains(t, m.validateSshConfig(), c.err)
+
+			_, err := m.Regions["region1"].Hosts["a"].NewSshConfigFactory()
+			require.ErrorContains(t, err, c.err)
+		})
+	}
+}
+
+func TestPerHostSshCredentials(t *testing.T) {
+	useTestInstance(t)
+
+	m := &Model{
+		Id: "test",
+		Scope: Scope{
+			Defaults: Variables{
+				"credentials": Variables{
+					"ssh": Variables{
+						"username": "ubuntu",
+						"key_path": "/keys/id",
+					},
+				},
+			},
+		},
+		Regions: Regions{
+			"region1": {
+				Hosts: Hosts{
+					"ubuntu": {PublicIp: "10.0.0.1"},
+					"amazon": {
+						PublicIp: "10.0.0.2",
+						Scope: Scope{
+							Defaults: Variables{
+								"credentials": Variables{
+									"ssh": Variables{
+										"username":   "ec2-user",
+										"port":       2222,
+										"agent_only": true,
+									},
+								},
+							},
+						},
+					},
+					"appliance": {
+						PublicIp: "10.0.0.3",
+						Scope: Scope{
+							Defaults: Variables{
+								"credentials": Variables{
+									"ssh": Variables{
+										"username": "root",
+										"password": "secret",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	require.NoError(t, m.init())
+	hosts := m.Regions["region1"].Hosts
+
+	factory, err := hosts["ubuntu"].NewSshConfigFactory()
+	require.NoError(t, err)
+	require.Equal(t, "ubuntu", factory.User())
+	require.Equal(t, "10.0.0.1:22", factory.Address())
+	require.Equal(t, "/keys/id", libssh.IdentityFile(factory))
+	require.Equal(t, "/home/ubuntu", hosts["ubuntu"].GetHomeDir())
+
+	factory, err = hosts["amazon"].NewSshConfigFactory()
+	require.NoError(t, err)
+	require.Equal(t, "ec2-user", factory.User())
+	require.Equal(t, "10.0.0.2:2222", factory.Address())
+	require.True(t, factory.AgentOnly())
+	require.Equal(t, "", libssh.IdentityFile(factory))
+	require.Equal(t, "/home/ec2-user", hosts["amazon"].GetHomeDir())
+
+	factory, err = hosts["appliance"].NewSshConfigFactory()
+	require.NoError(t, err)
+	require.Equal(t, "root", factory.User())
+	require.Equal(t, "secret", factory.Password())
+	require.Equal(t, "/root", hosts["appliance"].GetHomeDir())
+}
+
+func TestInvalidSshPort(t *testing.T) {
+	m := newJumpHostTestModel(t, Hosts{
+		"edge": {
+			PublicIp: "1.2.3.4",
+			Scope:    Scope{Defaults: Variables{"credentials": Variables{"ssh": Variables{"port": "ssh"}}}},
+		},
+	})
+	require.ErrorContains(t, m.validateSshConfig(), "invalid ssh port [ssh] for host")
+
+	_, err := m.Regions["region1"].Hosts["edge"].NewSshConfigFactory()
+	require.ErrorContains(t, err, "invalid ssh port [ssh] for host")
+}
+
+func TestKnownHostsFollowActiveInstance(t *testing.T) {
+	useTestInstance(t)
+	first, err := KnownHosts()
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(instanceConfig.WorkingDirectory, "known_hosts"), first.Path())
+
+	again, err := KnownHosts()
+	require.NoError(t, err)
+	require.Same(t, first, again)
+
+	useTestInstance(t)
+	second, err := KnownHosts()
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(instanceConfig.WorkingDirectory, "known_hosts"), second.Path())
+
+	instanceConfig.WorkingDirectory = ""
+	_, err = KnownHosts()
+	require.ErrorContains(t, err, "instance [test] has no working directory")
+}
+
+type countingStateHandler struct {
+	count int
+}
+
+func (self *countingStateHandler) AcceptComponentState(*Component, bool) {
+	self.count++
+}
+
+func TestRemoveStateHandler(t *testing.T) {
+	m := &Model{}
+	first := &countingStateHandler{}
+	second := &countingStateHandler{}
+	removeFirst := m.AddStateHandler(first)
+	m.AddStateHandler(second)
+
+	m.AcceptComponentState(&Component{}, true)
+	removeFirst()
+	m.AcceptComponentState(&Component{}, false)
+
+	require.Equal(t, 1, first.count)
+	require.Equal(t, 2, second.count)
+}